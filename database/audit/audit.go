@@ -0,0 +1,468 @@
+// Package audit keeps an audit trail of changes in the LogDb database.
+// Record only queues an entry in memory, a background goroutine writes the queue to the audit
+// table in batches with BulkInsert. While LogDb is down entries wait in memory up to MaxBuffer,
+// and beyond that they are appended to a spill file which is replayed once LogDb is back.
+// Close writes whatever is still queued, or spills it if LogDb cannot take it, so entries
+// are not lost on shutdown. A crash during replay may write some entries twice.
+//
+//	audit.Default = audit.New(database.LogDb)
+//	audit.Default.Start()
+//	defer audit.Default.Close(context.Background())
+//
+//	ctx := audit.WithRequestID(r.Context(), r.Header.Get("X-Request-ID"))
+//	err := audit.Record(ctx, strEmail, "update", fmt.Sprintf("item:%d", intItemID), oldItem, newItem)
+//
+// The audit table:
+//
+//	CREATE TABLE audit_log (
+//	    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+//	    logged_at DATETIME(6) NOT NULL,
+//	    request_id VARCHAR(64) NULL,
+//	    actor VARCHAR(255) NOT NULL,
+//	    action VARCHAR(64) NOT NULL,
+//	    entity VARCHAR(255) NOT NULL,
+//	    before_value JSON NULL,
+//	    after_value JSON NULL
+//	)
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/knousere/web-service-commons/database"
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// These are the Writer defaults.
+const (
+	defaultTable      = "audit_log"
+	defaultBatchSize  = 500
+	defaultFlushEvery = time.Second
+	defaultMaxBuffer  = 10000
+	defaultSpillPath  = "audit.spill"
+	maxSpillLine      = 16 << 20
+)
+
+// These errors are returned by Record.
+var (
+	ErrClosed     = errors.New("audit writer is closed")
+	ErrNotStarted = errors.New("audit writer is not started")
+)
+
+// columns are the audit table columns written, in Entry order.
+var columns = []string{"logged_at", "request_id", "actor", "action", "entity", "before_value", "after_value"}
+
+// Default is the Writer used by the package level Record.
+var Default *Writer
+
+// requestIDKey is the context key set by WithRequestID.
+type requestIDKey struct{}
+
+// Entry is one audit record. Before and After hold JSON, or nothing for NULL.
+type Entry struct {
+	LoggedAt  time.Time       `json:"logged_at"`
+	RequestID string          `json:"request_id,omitempty"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Entity    string          `json:"entity"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+}
+
+// Stats is a snapshot of the counters of a Writer.
+type Stats struct {
+	Pending  int   // entries waiting in memory
+	Written  int64 // entries written to the audit table
+	Spilled  int64 // entries appended to the spill file
+	Failures int64 // failed flushes
+}
+
+// Writer batches audit entries into a table of Db.
+type Writer struct {
+	Db         *database.DBConnection
+	Table      string        // audit table, default audit_log
+	BatchSize  int           // entries per insert, default 500
+	FlushEvery time.Duration // longest wait before queued entries are written, default 1s
+	MaxBuffer  int           // entries held in memory before they spill, default 10000
+	SpillPath  string        // spill file, default audit.spill in the working directory
+
+	mutex    sync.Mutex
+	pending  []Entry
+	spill    *os.File
+	bStarted bool
+	bClosed  bool
+	stats    Stats
+	signal   chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// New returns a Writer for dbConn with the default settings.
+func New(dbConn *database.DBConnection) *Writer {
+	return &Writer{Db: dbConn}
+}
+
+// Record queues an entry with Default. See Writer.Record.
+func Record(ctx context.Context, strActor, strAction, strEntity string, before, after interface{}) error {
+	if Default == nil {
+		return ErrNotStarted
+	}
+	return Default.Record(ctx, strActor, strAction, strEntity, before, after)
+}
+
+// WithRequestID returns a context whose audit entries are tagged with strRequestID.
+func WithRequestID(ctx context.Context, strRequestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, strRequestID)
+}
+
+// RequestID returns the request ID that Record takes from ctx: the one set by WithRequestID,
+// or else the one set by database.WithTrace, or else "".
+func RequestID(ctx context.Context) string {
+	if strRequestID, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return strRequestID
+	}
+	strRequestID, _ := database.TraceID(ctx)
+	return strRequestID
+}
+
+// Start starts the background flushing. Entries spilled by an earlier run are replayed.
+func (w *Writer) Start() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.bStarted {
+		return
+	}
+	w.bStarted = true
+	w.signal = make(chan struct{}, 1)
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.loop()
+}
+
+// Record queues an entry. before and after are stored as JSON, nil as NULL.
+// The request ID is taken from ctx, see RequestID.
+// Record does not wait for the database. It only fails if the entry cannot be marshaled,
+// the Writer is not running, or the entry has to be spilled and the spill file cannot be written.
+func (w *Writer) Record(ctx context.Context, strActor, strAction, strEntity string, before, after interface{}) error {
+	entry := Entry{
+		LoggedAt:  time.Now().UTC(),
+		RequestID: RequestID(ctx),
+		Actor:     strActor,
+		Action:    strAction,
+		Entity:    strEntity,
+	}
+	var err error
+	if entry.Before, err = marshal(before); err != nil {
+		return err
+	}
+	if entry.After, err = marshal(after); err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	switch {
+	case w.bClosed:
+		return ErrClosed
+	case !w.bStarted:
+		return ErrNotStarted
+	case len(w.pending) >= w.maxBuffer():
+		return w.spillLocked([]Entry{entry})
+	}
+	w.pending = append(w.pending, entry)
+	if len(w.pending) >= w.batchSize() {
+		select {
+		case w.signal <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Stats returns the counters of w.
+func (w *Writer) Stats() Stats {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	stats := w.stats
+	stats.Pending = len(w.pending)
+	return stats
+}
+
+// Close stops the background flushing and writes every queued entry.
+// Entries that cannot be written before ctx is done are spilled. The error reports entries
+// that could neither be written nor spilled.
+func (w *Writer) Close(ctx context.Context) error {
+	w.mutex.Lock()
+	if w.bClosed || !w.bStarted {
+		w.bClosed = true
+		w.mutex.Unlock()
+		return nil
+	}
+	w.bClosed = true
+	w.mutex.Unlock()
+	close(w.stop)
+	<-w.done
+
+	for ctx.Err() == nil && w.flush(ctx) {
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var err error
+	if len(w.pending) > 0 {
+		if err = w.spillLocked(w.pending); err != nil {
+			err = fmt.Errorf("audit lost %d entries: %w", len(w.pending), err)
+		}
+		w.pending = nil
+	}
+	if w.spill != nil {
+		w.spill.Close()
+		w.spill = nil
+	}
+	return err
+}
+
+// loop flushes every FlushEvery, or sooner when a batch is full.
+func (w *Writer) loop() {
+	defer close(w.done)
+	flushEvery := w.FlushEvery
+	if flushEvery <= 0 {
+		flushEvery = defaultFlushEvery
+	}
+	ticker := time.NewTicker(flushEvery)
+	defer ticker.Stop()
+
+	w.replay()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		case <-w.signal:
+		}
+		// write full batches until the queue is empty or the database fails
+		for w.flush(context.Background()) {
+		}
+		if w.Stats().Pending == 0 {
+			w.replay()
+		}
+	}
+}
+
+// flush writes one batch and reports whether it wrote anything.
+// The batch stays queued if the insert fails.
+func (w *Writer) flush(ctx context.Context) bool {
+	w.mutex.Lock()
+	n := len(w.pending)
+	if n > w.batchSize() {
+		n = w.batchSize()
+	}
+	batch := w.pending[:n:n]
+	w.mutex.Unlock()
+	if n == 0 {
+		return false
+	}
+
+	err := w.insert(ctx, batch)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err != nil {
+		return false
+	}
+	// Record only appends, so the batch is still at the front
+	w.pending = w.pending[n:]
+	w.stats.Written += int64(n)
+	return true
+}
+
+// insert writes entries to the audit table in one transaction.
+func (w *Writer) insert(ctx context.Context, entries []Entry) error {
+	rows := make([][]interface{}, len(entries))
+	for i, entry := range entries {
+		var requestID interface{}
+		if entry.RequestID != "" {
+			requestID = entry.RequestID
+		}
+		rows[i] = []interface{}{entry.LoggedAt, requestID, entry.Actor, entry.Action, entry.Entity,
+			nullJSON(entry.Before), nullJSON(entry.After)}
+	}
+	_, err := w.Db.BulkInsertContext(ctx, w.table(), columns, rows, &database.BulkOptions{Atomic: true})
+	if err != nil {
+		utils.Warning.Println("audit flush failed", len(entries), "entries", err.Error())
+		w.mutex.Lock()
+		w.stats.Failures++
+		w.mutex.Unlock()
+	}
+	return err
+}
+
+// spillLocked appends entries to the spill file. The caller holds the mutex.
+func (w *Writer) spillLocked(entries []Entry) error {
+	buf := make([]byte, 0, 256*len(entries))
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	if err := w.writeSpillLocked(bytes.NewReader(buf)); err != nil {
+		return err
+	}
+	w.stats.Spilled += int64(len(entries))
+	return nil
+}
+
+// writeSpillLocked appends r to the spill file, opening it on first use. The caller holds the mutex.
+func (w *Writer) writeSpillLocked(r io.Reader) error {
+	if w.spill == nil {
+		file, err := os.OpenFile(w.spillPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			utils.Error.Println("audit spill failed", err.Error())
+			return err
+		}
+		w.spill = file
+	}
+	if _, err := io.Copy(w.spill, r); err != nil {
+		utils.Error.Println("audit spill failed", err.Error())
+		return err
+	}
+	return nil
+}
+
+// replay writes the spill file to the audit table. The file is moved aside first so that
+// new spills do not mix with it. If the database fails again, the part of the file not yet
+// written goes back to the spill file.
+func (w *Writer) replay() {
+	strReplay := w.spillPath() + ".replay"
+	w.mutex.Lock()
+	if _, err := os.Stat(strReplay); os.IsNotExist(err) {
+		// otherwise a replay file left by a crash is replayed first
+		if w.spill != nil {
+			w.spill.Close()
+			w.spill = nil
+		}
+		if err = os.Rename(w.spillPath(), strReplay); err != nil {
+			w.mutex.Unlock()
+			return
+		}
+	}
+	w.mutex.Unlock()
+
+	file, err := os.Open(strReplay)
+	if err != nil {
+		utils.Warning.Println("audit replay failed", err.Error())
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), maxSpillLine)
+	batch := make([]Entry, 0, w.batchSize())
+	var offset, batchOffset int64
+	bFailed := false
+	intReplayed := 0
+	for !bFailed && scanner.Scan() {
+		line := scanner.Bytes()
+		if len(batch) == 0 {
+			batchOffset = offset
+		}
+		offset += int64(len(line)) + 1
+		var entry Entry
+		if err = json.Unmarshal(line, &entry); err != nil {
+			utils.Warning.Println("audit replay skipped a corrupt entry", err.Error())
+			continue
+		}
+		batch = append(batch, entry)
+		if len(batch) == cap(batch) {
+			if bFailed = w.insert(context.Background(), batch) != nil; !bFailed {
+				intReplayed += len(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		// keep the file for the next attempt rather than lose what could not be read
+		utils.Warning.Println("audit replay failed", err.Error())
+		return
+	}
+	if !bFailed && len(batch) > 0 {
+		if bFailed = w.insert(context.Background(), batch) != nil; !bFailed {
+			intReplayed += len(batch)
+		}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.stats.Written += int64(intReplayed)
+	if bFailed {
+		if _, err = file.Seek(batchOffset, io.SeekStart); err != nil {
+			return
+		}
+		if err = w.writeSpillLocked(file); err != nil {
+			// the replay file still holds them, the written part will be written again
+			return
+		}
+	}
+	os.Remove(strReplay)
+	if intReplayed > 0 {
+		utils.Info.Println("audit replayed", intReplayed, "spilled entries")
+	}
+}
+
+func (w *Writer) table() string {
+	if w.Table == "" {
+		return defaultTable
+	}
+	return w.Table
+}
+
+func (w *Writer) batchSize() int {
+	if w.BatchSize <= 0 {
+		return defaultBatchSize
+	}
+	return w.BatchSize
+}
+
+func (w *Writer) maxBuffer() int {
+	if w.MaxBuffer <= 0 {
+		return defaultMaxBuffer
+	}
+	return w.MaxBuffer
+}
+
+func (w *Writer) spillPath() string {
+	if w.SpillPath == "" {
+		return defaultSpillPath
+	}
+	return w.SpillPath
+}
+
+// marshal encodes a before or after value. nil stays nil for NULL.
+func marshal(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("audit value: %w", err)
+	}
+	return b, nil
+}
+
+// nullJSON turns an empty value into NULL for the insert.
+func nullJSON(b json.RawMessage) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
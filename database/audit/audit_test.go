@@ -0,0 +1,208 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knousere/web-service-commons/database"
+	"github.com/knousere/web-service-commons/database/dbtest"
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// TestMain quiets the logs, including the warnings of failing flushes.
+func TestMain(m *testing.M) {
+	utils.InitLog(utils.LogNil, utils.LogNil, utils.LogNil, utils.LogNil)
+	os.Exit(m.Run())
+}
+
+// reInsert matches the batch insert of the audit table.
+const reInsert = "^INSERT INTO `audit_log`"
+
+// newWriter returns a Writer on fake that spills to a file in a temporary directory.
+func newWriter(t *testing.T, fake *dbtest.Fake, strDir string) *Writer {
+	t.Helper()
+	w := New(fake.DB())
+	w.SpillPath = filepath.Join(strDir, "audit.spill")
+	return w
+}
+
+// tempDir returns a temporary directory and a func that removes it.
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+	strDir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strDir, func() { os.RemoveAll(strDir) }
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, strWhat string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", strWhat)
+		}
+	}
+}
+
+// inserted returns the number of rows of each insert sent to fake.
+func inserted(fake *dbtest.Fake) []int {
+	var counts []int
+	for _, call := range fake.Calls() {
+		if strings.HasPrefix(call.Query, "INSERT") {
+			counts = append(counts, len(call.Args)/len(columns))
+		}
+	}
+	return counts
+}
+
+// record queues n entries with w.
+func record(t *testing.T, w *Writer, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := w.Record(context.Background(), "ann", "update", "item:7", nil, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		id   string
+	}{
+		{"none", ctx, ""},
+		{"untraced request", WithRequestID(ctx, "req-1"), "req-1"},
+		{"traced request", database.WithTrace(ctx, "trace-1"), "trace-1"},
+		{"both", database.WithTrace(WithRequestID(ctx, "req-1"), "trace-1"), "req-1"},
+	}
+	for _, test := range tests {
+		if id := RequestID(test.ctx); id != test.id {
+			t.Errorf("%s: expected %q, got %q", test.name, test.id, id)
+		}
+	}
+
+	// queue only, without the background flushing
+	w := New(nil)
+	w.bStarted = true
+	if err := w.Record(WithRequestID(ctx, "req-2"), "ann", "update", "item:7", nil, map[string]int{"id": 7}); err != nil {
+		t.Fatal(err)
+	}
+	entry := w.pending[0]
+	if entry.RequestID != "req-2" || string(entry.After) != `{"id":7}` || entry.Before != nil {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+}
+
+func TestFlushBatchSize(t *testing.T) {
+	strDir, cleanup := tempDir(t)
+	defer cleanup()
+	fake := dbtest.New()
+	fake.OnQuery(reInsert).ExecResult(0, 3)
+	w := newWriter(t, fake, strDir)
+	w.BatchSize = 3
+	w.FlushEvery = time.Hour
+	w.Start()
+
+	// a full batch is written at once, a partial one waits for the interval
+	record(t, w, 3)
+	waitFor(t, "the full batch", func() bool { return w.Stats().Written == 3 })
+	record(t, w, 2)
+	time.Sleep(10 * time.Millisecond)
+	if stats := w.Stats(); stats.Written != 3 || stats.Pending != 2 {
+		t.Errorf("expected 3 written and 2 pending, got %+v", stats)
+	}
+
+	// Close writes the rest
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if counts := inserted(fake); len(counts) != 2 || counts[0] != 3 || counts[1] != 2 {
+		t.Errorf("expected inserts of 3 and 2 rows, got %v", counts)
+	}
+	if err := w.Record(context.Background(), "ann", "update", "item:7", nil, nil); err != ErrClosed {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	strDir, cleanup := tempDir(t)
+	defer cleanup()
+	fake := dbtest.New()
+	fake.OnQuery(reInsert).ExecResult(0, 1)
+	w := newWriter(t, fake, strDir)
+	w.FlushEvery = 5 * time.Millisecond
+	w.Start()
+	defer w.Close(context.Background())
+
+	record(t, w, 1)
+	waitFor(t, "the interval flush", func() bool { return w.Stats().Written == 1 })
+	if counts := inserted(fake); len(counts) != 1 || counts[0] != 1 {
+		t.Errorf("expected one insert of 1 row, got %v", counts)
+	}
+}
+
+func TestSpillAndReplay(t *testing.T) {
+	strDir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// the database is down: entries beyond MaxBuffer spill at once, the rest on Close
+	down := dbtest.New()
+	down.OnQuery(reInsert).Error(sql.ErrConnDone)
+	w := newWriter(t, down, strDir)
+	w.MaxBuffer = 2
+	w.FlushEvery = time.Hour
+	w.Start()
+	record(t, w, 3)
+	if stats := w.Stats(); stats.Pending != 2 || stats.Spilled != 1 {
+		t.Errorf("expected 2 pending and 1 spilled, got %+v", stats)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := w.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stats := w.Stats(); stats.Pending != 0 || stats.Spilled != 3 || stats.Written != 0 || stats.Failures == 0 {
+		t.Errorf("expected every entry spilled after Close, got %+v", stats)
+	}
+	file, err := os.Open(w.SpillPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); lines++ {
+	}
+	file.Close()
+	if lines != 3 {
+		t.Errorf("expected 3 spilled lines, got %d", lines)
+	}
+
+	// the next run replays the spill file once the database is back
+	up := dbtest.New()
+	up.OnQuery(reInsert).ExecResult(0, 3)
+	w = newWriter(t, up, strDir)
+	w.FlushEvery = time.Hour
+	w.Start()
+	waitFor(t, "the replay", func() bool { return w.Stats().Written == 3 })
+	if err = w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if counts := inserted(up); len(counts) != 1 || counts[0] != 3 {
+		t.Errorf("expected one insert of the 3 spilled rows, got %v", counts)
+	}
+	for _, strPath := range []string{w.SpillPath, w.SpillPath + ".replay"} {
+		if _, err = os.Stat(strPath); !os.IsNotExist(err) {
+			t.Errorf("%s was kept after the replay", strPath)
+		}
+	}
+}
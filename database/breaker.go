@@ -0,0 +1,228 @@
+package database
+
+// The circuit breaker stops every caller from waiting out a dial timeout while mysql is down.
+// It counts consecutive connection failures: failed dials of new pool connections and
+// driver.ErrBadConn returned to a wrapper. After BreakerThreshold of them the breaker opens and
+// new connections fail at once with ErrDatabaseUnavailable. Once BreakerCooldown has passed,
+// the next connection attempt is let through as a probe and pinged. If it succeeds the breaker
+// closes, otherwise it stays open for another cooldown.
+//
+// With FailoverHosts set, a failed dial tries the other hosts in order before it counts as a
+// failure. New connections stay on the host that answered until it fails in turn.
+//
+//  database.AppDb.BreakerThreshold = 5
+//  database.AppDb.FailoverHosts = []string{"10.0.0.12:3306"}
+//
+//  rows, err := database.AppDb.GetRows("CALL sp_item_list(?)", intUserID)
+//  if errors.Is(err, database.ErrDatabaseUnavailable) {
+//  	// 503
+//  }
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// ErrDatabaseUnavailable is returned without contacting mysql while the breaker is open.
+var ErrDatabaseUnavailable = errors.New("database unavailable")
+
+// defaultBreakerCooldown is how long the breaker stays open before a probe.
+const defaultBreakerCooldown = 5 * time.Second
+
+// breakerState is the state of a breaker.
+type breakerState int
+
+// These are the breaker states.
+const (
+	breakerClosed   breakerState = iota // connections go ahead
+	breakerOpen                         // connections fail fast
+	breakerHalfOpen                     // one probe is in flight, the rest fail fast
+)
+
+// breaker is the circuit breaker of a DBConnection.
+type breaker struct {
+	mutex    sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+// breakerOn reports whether the breaker of dbConn is enabled.
+func (dbConn *DBConnection) breakerOn() bool {
+	return dbConn.BreakerThreshold > 0
+}
+
+// breakerCooldown returns BreakerCooldown or its default.
+func (dbConn *DBConnection) breakerCooldown() time.Duration {
+	if dbConn.BreakerCooldown <= 0 {
+		return defaultBreakerCooldown
+	}
+	return dbConn.BreakerCooldown
+}
+
+// Available reports whether new connections are attempted, i.e. the breaker is not open.
+func (dbConn *DBConnection) Available() bool {
+	if !dbConn.breakerOn() {
+		return true
+	}
+	dbConn.breaker.mutex.Lock()
+	defer dbConn.breaker.mutex.Unlock()
+	return dbConn.breaker.state == breakerClosed
+}
+
+// allow reports whether a connection attempt may go ahead and whether it is the probe.
+func (b *breaker) allow(cooldown time.Duration) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case breakerClosed:
+		return false, nil
+	case breakerOpen:
+		if time.Since(b.openedAt) < cooldown {
+			return false, ErrDatabaseUnavailable
+		}
+		b.state = breakerHalfOpen
+		return true, nil
+	default:
+		return false, ErrDatabaseUnavailable
+	}
+}
+
+// success closes the breaker.
+func (b *breaker) success(strHost string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state != breakerClosed {
+		utils.Info.Println("database available again", strHost)
+	}
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// failure counts a connection failure and opens the breaker at threshold, or at once if it was the probe.
+func (b *breaker) failure(threshold int, strHost string, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures++
+	switch {
+	case b.state == breakerHalfOpen:
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		utils.Trace.Println("database probe failed", strHost, err.Error())
+	case b.state == breakerClosed && b.failures >= threshold:
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		utils.Warning.Println("database unavailable, failing fast", strHost, err.Error())
+	}
+}
+
+// abandon returns a probe that was canceled by its caller, so that the next attempt probes at once.
+func (b *breaker) abandon() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+	}
+}
+
+// observeConn feeds the result of a wrapper call to the breaker.
+// Only a lost connection counts as a failure, any answer from mysql means it is up.
+func (dbConn *DBConnection) observeConn(err error) {
+	if !dbConn.breakerOn() {
+		return
+	}
+	switch {
+	case errors.Is(err, driver.ErrBadConn):
+		dbConn.breaker.failure(dbConn.BreakerThreshold, dbConn.Host, err)
+	case err == nil:
+		dbConn.breaker.mutex.Lock()
+		dbConn.breaker.failures = 0
+		dbConn.breaker.mutex.Unlock()
+	}
+}
+
+// primaryHosts returns Host followed by FailoverHosts.
+func (dbConn *DBConnection) primaryHosts() []string {
+	return append([]string{dbConn.Host}, dbConn.FailoverHosts...)
+}
+
+// connectPrimary dials a new primary connection through the breaker, trying the failover hosts
+// if the current host fails.
+func (c *connector) connectPrimary(ctx context.Context) (driver.Conn, error) {
+	dbConn := c.dbConn
+	bProbe := false
+	if dbConn.breakerOn() {
+		var err error
+		if bProbe, err = dbConn.breaker.allow(dbConn.breakerCooldown()); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mutex.Lock()
+	start := c.hostIndex
+	c.mutex.Unlock()
+
+	var err error
+	for i := 0; i < len(c.hosts); i++ {
+		hostIndex := (start + i) % len(c.hosts)
+		var conn driver.Conn
+		conn, err = c.dial(ctx, c.hosts[hostIndex], bProbe)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				// the caller gave up, the host did not fail
+				break
+			}
+			continue
+		}
+		if hostIndex != start {
+			c.mutex.Lock()
+			c.hostIndex = hostIndex
+			c.mutex.Unlock()
+			utils.Warning.Println("database failover from", c.hosts[start], "to", c.hosts[hostIndex])
+		}
+		if dbConn.breakerOn() {
+			dbConn.breaker.success(c.hosts[hostIndex])
+		}
+		return conn, nil
+	}
+
+	if dbConn.breakerOn() {
+		if !errors.Is(err, context.Canceled) {
+			dbConn.breaker.failure(dbConn.BreakerThreshold, c.hosts[start], err)
+		} else if bProbe {
+			dbConn.breaker.abandon()
+		}
+	}
+	return nil, err
+}
+
+// dial opens one connection to strHost. The probe is pinged before it is handed out.
+func (c *connector) dial(ctx context.Context, strHost string, bProbe bool) (driver.Conn, error) {
+	strDSN := c.dbConn.hostConnectionString(strHost, c.password())
+	var conn driver.Conn
+	var err error
+	if driverContext, ok := c.driver.(driver.DriverContext); ok {
+		// a connector honors the deadline of ctx while dialing
+		var dc driver.Connector
+		if dc, err = driverContext.OpenConnector(strDSN); err == nil {
+			conn, err = dc.Connect(ctx)
+		}
+	} else {
+		conn, err = c.driver.Open(strDSN)
+	}
+	if err != nil || !bProbe {
+		return conn, err
+	}
+	if pinger, ok := conn.(driver.Pinger); ok {
+		if err = pinger.Ping(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
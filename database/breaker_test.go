@@ -0,0 +1,203 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/knousere/web-service-commons/go-sql-driver/mysql"
+)
+
+// hostDriver answers dials to the hosts that are up and records every host it was asked for.
+type hostDriver struct {
+	down    map[string]error // hosts that fail to dial and their error
+	badPing bool             // the ping of a probe fails
+	dialed  []string
+}
+
+func (d *hostDriver) Open(strDSN string) (driver.Conn, error) {
+	dsn, err := mysql.ParseDSN(strDSN)
+	if err != nil {
+		return nil, err
+	}
+	d.dialed = append(d.dialed, dsn.Addr)
+	if err = d.down[dsn.Addr]; err != nil {
+		return nil, err
+	}
+	return &hostConn{bBadPing: d.badPing}, nil
+}
+
+// hostConn is a connection of hostDriver.
+type hostConn struct {
+	bBadPing bool
+}
+
+func (c *hostConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c *hostConn) Close() error              { return nil }
+func (c *hostConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+func (c *hostConn) Ping(ctx context.Context) error {
+	if c.bBadPing {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// newHostConnector returns a primary connector over hostDriver with the breaker enabled.
+func newHostConnector(drv *hostDriver, threshold int, hosts ...string) *connector {
+	dbConn := &DBConnection{Host: hosts[0], FailoverHosts: hosts[1:], BreakerThreshold: threshold}
+	return &connector{dbConn: dbConn, hosts: dbConn.primaryHosts(), driver: drv}
+}
+
+func TestBreakerStates(t *testing.T) {
+	errDial := errors.New("connection refused")
+	b := &breaker{}
+	cooldown := time.Minute
+
+	if bProbe, err := b.allow(cooldown); bProbe || err != nil {
+		t.Errorf("closed: allow = %v, %v, expected false, nil", bProbe, err)
+	}
+	b.failure(2, "db1", errDial)
+	if b.state != breakerClosed {
+		t.Errorf("opened below the threshold")
+	}
+	b.failure(2, "db1", errDial)
+	if b.state != breakerOpen {
+		t.Fatalf("expected open at the threshold, got state %d", b.state)
+	}
+	if _, err := b.allow(cooldown); err != ErrDatabaseUnavailable {
+		t.Errorf("open: expected ErrDatabaseUnavailable during the cooldown, got %v", err)
+	}
+
+	// after the cooldown one caller probes and the others keep failing fast
+	b.openedAt = time.Now().Add(-cooldown)
+	if bProbe, err := b.allow(cooldown); !bProbe || err != nil || b.state != breakerHalfOpen {
+		t.Errorf("expected a probe after the cooldown, got %v, %v, state %d", bProbe, err, b.state)
+	}
+	if _, err := b.allow(cooldown); err != ErrDatabaseUnavailable {
+		t.Errorf("half open: expected ErrDatabaseUnavailable, got %v", err)
+	}
+
+	// a failed probe opens the breaker for another cooldown
+	b.failure(2, "db1", errDial)
+	if _, err := b.allow(cooldown); b.state != breakerOpen || err != ErrDatabaseUnavailable {
+		t.Errorf("failed probe: expected open, got state %d, %v", b.state, err)
+	}
+
+	// an abandoned probe keeps the old openedAt, so that the next caller probes at once
+	b.openedAt = time.Now().Add(-cooldown)
+	b.allow(cooldown)
+	b.abandon()
+	if bProbe, _ := b.allow(cooldown); b.state != breakerHalfOpen || !bProbe {
+		t.Errorf("abandoned probe: expected another probe, got state %d", b.state)
+	}
+
+	b.success("db1")
+	if b.state != breakerClosed || b.failures != 0 {
+		t.Errorf("success: expected closed with no failures, got state %d, %d failures", b.state, b.failures)
+	}
+	b.abandon()
+	if b.state != breakerClosed {
+		t.Errorf("abandon changed a closed breaker to state %d", b.state)
+	}
+}
+
+func TestObserveConn(t *testing.T) {
+	dbConn := &DBConnection{Host: "db1:3306", BreakerThreshold: 2}
+
+	// only a lost connection counts, an answer from mysql resets the count
+	dbConn.observeConn(driver.ErrBadConn)
+	dbConn.observeConn(&mysql.MySQLError{Number: 1213})
+	dbConn.observeConn(nil)
+	dbConn.observeConn(driver.ErrBadConn)
+	if !dbConn.Available() {
+		t.Errorf("opened without %d consecutive failures", dbConn.BreakerThreshold)
+	}
+
+	// the deadlock wrapper reports its error the same way
+	dbConn.recordDeadlock("UpdateRowsWithDeadlock", time.Now(), false, driver.ErrBadConn, "CALL sp_vote(?)", 7)
+	if dbConn.Available() {
+		t.Error("recordDeadlock did not count the lost connection")
+	}
+
+	dbConn = &DBConnection{}
+	dbConn.observeConn(driver.ErrBadConn)
+	if !dbConn.Available() || dbConn.breaker.failures != 0 {
+		t.Error("a disabled breaker counted a failure")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	errDial := errors.New("connection refused")
+	drv := &hostDriver{down: map[string]error{"db1:3306": errDial}}
+	c := newHostConnector(drv, 1, "db1:3306", "db2:3306", "db3:3306")
+
+	tests := []struct {
+		name   string
+		down   []string
+		dialed []string
+		err    error
+	}{
+		{"first host down", []string{"db1:3306"}, []string{"db1:3306", "db2:3306"}, nil},
+		{"stays on the host that answered", []string{"db1:3306"}, []string{"db2:3306"}, nil},
+		{"moves on in order", []string{"db2:3306"}, []string{"db2:3306", "db3:3306"}, nil},
+		{"wraps around", []string{"db3:3306"}, []string{"db3:3306", "db1:3306"}, nil},
+		{"all down", []string{"db1:3306", "db2:3306", "db3:3306"}, []string{"db1:3306", "db2:3306", "db3:3306"}, errDial},
+		{"breaker open", nil, nil, ErrDatabaseUnavailable},
+	}
+	for _, test := range tests {
+		drv.down = map[string]error{}
+		for _, strHost := range test.down {
+			drv.down[strHost] = errDial
+		}
+		drv.dialed = nil
+		_, err := c.Connect(context.Background())
+		if err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+		if !reflect.DeepEqual(drv.dialed, test.dialed) {
+			t.Errorf("%s: expected dials to %q, got %q", test.name, test.dialed, drv.dialed)
+		}
+	}
+
+	// the probe after the cooldown is pinged before it closes the breaker
+	c.dbConn.breaker.openedAt = time.Now().Add(-defaultBreakerCooldown)
+	drv.badPing = true
+	if _, err := c.Connect(context.Background()); err != driver.ErrBadConn || c.dbConn.Available() {
+		t.Errorf("expected the failed ping to keep the breaker open, got %v", err)
+	}
+	c.dbConn.breaker.openedAt = time.Now().Add(-defaultBreakerCooldown)
+	drv.badPing = false
+	if _, err := c.Connect(context.Background()); err != nil || !c.dbConn.Available() {
+		t.Errorf("expected the probe to close the breaker, got %v", err)
+	}
+}
+
+func TestFailoverCanceled(t *testing.T) {
+	drv := &hostDriver{down: map[string]error{"db1:3306": context.Canceled}}
+	c := newHostConnector(drv, 1, "db1:3306", "db2:3306")
+
+	// the caller gave up, so neither the other host is tried nor a failure counted
+	if _, err := c.Connect(context.Background()); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if !reflect.DeepEqual(drv.dialed, []string{"db1:3306"}) || !c.dbConn.Available() {
+		t.Errorf("canceled dial failed over or opened the breaker: dialed %q", drv.dialed)
+	}
+
+	// a canceled probe is abandoned and the next caller probes at once
+	c.dbConn.breaker.failure(1, "db1:3306", driver.ErrBadConn)
+	c.dbConn.breaker.openedAt = time.Now().Add(-defaultBreakerCooldown)
+	c.Connect(context.Background())
+	if c.dbConn.breaker.state != breakerOpen {
+		t.Fatalf("expected the abandoned probe to leave the breaker open, got state %d", c.dbConn.breaker.state)
+	}
+	drv.down = nil
+	if _, err := c.Connect(context.Background()); err != nil || !c.dbConn.Available() {
+		t.Errorf("expected the next caller to probe, got %v", err)
+	}
+}
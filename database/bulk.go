@@ -0,0 +1,237 @@
+package database
+
+// BulkInsert writes many rows with multi-row INSERT statements. The rows are split into chunks
+// that fit the max_allowed_packet the driver read at connect time, and the 65535 placeholder
+// limit of a prepared statement.
+//
+//  rows := [][]interface{}{{intUserID, "a", 1}, {intUserID, "b", 2}}
+//  counts, err := database.AppDb.BulkInsert("user_tag", []string{"user_id", "tag", "weight"}, rows,
+//  	&database.BulkOptions{Update: []string{"weight"}, Atomic: true})
+//
+// Without Atomic each chunk commits on its own. If one fails, the chunks before it stay written
+// and their counts are returned along with the error.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// These bound the size of a BulkInsert chunk.
+const (
+	maxPlaceholders       = 65535
+	defaultMaxPacket      = 4 << 20 // mysql 5.7 default, used if the driver cannot tell
+	bulkPacketHeadroom    = 1024
+	bulkVariableValueSize = 64 // estimate for values of unknown size
+	bulkTimeValueSize     = 28 // time.Time goes as text, '2006-01-02 15:04:05.999999' quoted
+)
+
+// BulkOptions modify BulkInsert. A nil *BulkOptions means plain INSERT without a transaction.
+type BulkOptions struct {
+	Update  []string // on duplicate key set these columns from the new row
+	Atomic  bool     // insert every chunk in one transaction, all or nothing
+	MaxRows int      // rows per chunk, 0 for as many as fit
+}
+
+// BulkInsert inserts rows into strTable and returns the affected count of every chunk.
+// With BulkOptions.Update a row that updates an existing row counts 2, as reported by mysql.
+func (dbConn *DBConnection) BulkInsert(strTable string, columns []string, rows [][]interface{}, opts *BulkOptions) ([]int, error) {
+	return dbConn.BulkInsertContext(context.Background(), strTable, columns, rows, opts)
+}
+
+// BulkInsertContext is BulkInsert bounded by ctx. The default query timeout applies to each chunk.
+func (dbConn *DBConnection) BulkInsertContext(ctx context.Context, strTable string, columns []string, rows [][]interface{}, opts *BulkOptions) ([]int, error) {
+	if opts == nil {
+		opts = &BulkOptions{}
+	}
+	strPrefix, strSuffix, err := bulkStatement(strTable, columns, rows, opts)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+
+	conn, err := dbConn.db.Conn(ctx)
+	if err != nil {
+		warnError(err, "", strPrefix)
+		return nil, err
+	}
+	defer conn.Close()
+
+	chunks := bulkChunks(rows, len(strPrefix)+len(strSuffix), maxAllowedPacket(conn), opts.MaxRows)
+	var runner queryRunner = conn
+	var tx *sql.Tx
+	if opts.Atomic {
+		if tx, err = conn.BeginTx(ctx, nil); err != nil {
+			warnError(err, "", strPrefix)
+			return nil, err
+		}
+		runner = tx
+	}
+
+	counts := make([]int, 0, len(chunks))
+	for i, chunk := range chunks {
+		affectedCount, err := dbConn.bulkChunk(ctx, runner, strPrefix, strSuffix, chunk)
+		if err != nil {
+			err = fmt.Errorf("bulk insert chunk %d of %d: %w", i+1, len(chunks), err)
+			if tx != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			if len(counts) > 0 {
+				dbConn.markWrite()
+			}
+			return counts, err
+		}
+		counts = append(counts, affectedCount)
+	}
+
+	if tx != nil {
+		if err = tx.Commit(); err != nil {
+			warnError(err, "", strPrefix)
+			return nil, err
+		}
+	}
+	dbConn.markWrite()
+	return counts, nil
+}
+
+// bulkChunk inserts one chunk. The statement is recorded by its prefix so that every chunk
+// shares one histogram and the slow query log is not flooded with values.
+func (dbConn *DBConnection) bulkChunk(ctx context.Context, runner queryRunner, strPrefix, strSuffix string, chunk [][]interface{}) (int, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+
+	rowMarkers := "(" + strings.TrimSuffix(strings.Repeat("?,", len(chunk[0])), ",") + ")"
+	markers := strings.TrimSuffix(strings.Repeat(rowMarkers+",", len(chunk)), ",")
+	args := make([]interface{}, 0, len(chunk)*len(chunk[0]))
+	for _, row := range chunk {
+		args = append(args, row...)
+	}
+
+	strRows := fmt.Sprintf("rows=%d", len(chunk))
+	strArgs := doTrace(ctx, strPrefix+"..."+strSuffix, strRows)
+	var affectedCount int64
+	result, err := runner.ExecContext(ctx, strPrefix+markers+strSuffix, args...)
+	if err == nil {
+		affectedCount, err = result.RowsAffected()
+	}
+	if err != nil {
+		warnError(err, strArgs, strPrefix+"..."+strSuffix, strRows)
+	}
+	dbConn.record("BulkInsert", startTime, err, strPrefix)
+	return int(affectedCount), err
+}
+
+// bulkStatement checks the arguments of BulkInsert and returns the parts of the statement
+// before and after the row placeholders.
+func bulkStatement(strTable string, columns []string, rows [][]interface{}, opts *BulkOptions) (string, string, error) {
+	if len(columns) == 0 {
+		return "", "", errors.New("bulk insert needs at least one column")
+	}
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return "", "", fmt.Errorf("bulk insert row %d has %d values for %d columns", i, len(row), len(columns))
+		}
+	}
+	table, err := quoteIdent(strTable)
+	if err != nil {
+		return "", "", err
+	}
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		if quoted[i], err = quoteIdent(column); err != nil {
+			return "", "", err
+		}
+	}
+	strPrefix := "INSERT INTO " + table + " (" + strings.Join(quoted, ",") + ") VALUES "
+
+	var strSuffix string
+	if len(opts.Update) > 0 {
+		updates := make([]string, len(opts.Update))
+		for i, column := range opts.Update {
+			quotedColumn, err := quoteIdent(column)
+			if err != nil {
+				return "", "", err
+			}
+			updates[i] = quotedColumn + "=VALUES(" + quotedColumn + ")"
+		}
+		strSuffix = " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ",")
+	}
+	return strPrefix, strSuffix, nil
+}
+
+// quoteIdent quotes an identifier, or each part of a schema qualified one.
+func quoteIdent(strIdent string) (string, error) {
+	parts := strings.Split(strIdent, ".")
+	for i, part := range parts {
+		if part == "" || strings.ContainsAny(part, "`\x00") {
+			return "", fmt.Errorf("invalid identifier %q", strIdent)
+		}
+		parts[i] = "`" + part + "`"
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// maxAllowedPacket asks the driver for the max_allowed_packet of conn.
+func maxAllowedPacket(conn *sql.Conn) int {
+	size := defaultMaxPacket
+	conn.Raw(func(driverConn interface{}) error {
+		if sizer, ok := driverConn.(interface{ MaxAllowedPacket() int }); ok {
+			size = sizer.MaxAllowedPacket()
+		}
+		return nil
+	})
+	return size
+}
+
+// bulkChunks splits rows so that the execute packet of every chunk fits in maxPacket.
+// The size of a row is estimated the way the driver encodes a prepared statement parameter.
+func bulkChunks(rows [][]interface{}, intStatementLen, maxPacket, maxRows int) [][][]interface{} {
+	columnCount := len(rows[0])
+	budget := maxPacket - bulkPacketHeadroom - intStatementLen
+	rowLimit := maxPlaceholders / columnCount
+	if maxRows > 0 && maxRows < rowLimit {
+		rowLimit = maxRows
+	}
+
+	chunks := make([][][]interface{}, 0, 4)
+	start, size := 0, 0
+	for i, row := range rows {
+		// per column 2 type bytes, a null bit, the value and the ?, marker, plus the () of the row
+		rowSize := 2*columnCount + 2 + (columnCount+7)/8 + 2*columnCount
+		for _, v := range row {
+			rowSize += valueSize(v)
+		}
+		if i > start && (size+rowSize > budget || i-start >= rowLimit) {
+			chunks = append(chunks, rows[start:i])
+			start, size = i, 0
+		}
+		size += rowSize
+	}
+	return append(chunks, rows[start:])
+}
+
+// valueSize estimates the encoded size of a parameter value.
+func valueSize(v interface{}) int {
+	switch value := v.(type) {
+	case nil:
+		return 0
+	case bool, int8, uint8:
+		return 1
+	case int, int16, int32, int64, uint, uint16, uint32, uint64, float32, float64:
+		return 8
+	case string:
+		return len(value) + 9
+	case []byte:
+		return len(value) + 9
+	case time.Time:
+		return bulkTimeValueSize
+	case SensitiveArg:
+		return valueSize(value.value)
+	default:
+		return bulkVariableValueSize
+	}
+}
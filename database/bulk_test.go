@@ -0,0 +1,181 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuoteIdent(t *testing.T) {
+	tests := []struct {
+		ident  string
+		quoted string
+	}{
+		{"user_tag", "`user_tag`"},
+		{"app.user_tag", "`app`.`user_tag`"},
+		{"user tag; DROP TABLE x", "`user tag; DROP TABLE x`"},
+		{"", ""},
+		{"app.", ""},
+		{"app..user_tag", ""},
+		{"user`tag", ""},
+		{"user` ; DROP TABLE x; --", ""},
+		{"user\x00tag", ""},
+	}
+	for _, test := range tests {
+		quoted, err := quoteIdent(test.ident)
+		if test.quoted == "" {
+			if err == nil {
+				t.Errorf("quoteIdent(%q) = %q, expected an error", test.ident, quoted)
+			}
+			continue
+		}
+		if err != nil || quoted != test.quoted {
+			t.Errorf("quoteIdent(%q) = %q, %v, expected %q", test.ident, quoted, err, test.quoted)
+		}
+	}
+}
+
+func TestBulkStatement(t *testing.T) {
+	rows := [][]interface{}{{1, "a"}}
+	prefix, suffix, err := bulkStatement("app.user_tag", []string{"user_id", "tag"}, rows,
+		&BulkOptions{Update: []string{"tag"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "INSERT INTO `app`.`user_tag` (`user_id`,`tag`) VALUES "; prefix != expected {
+		t.Errorf("expected prefix %q, got %q", expected, prefix)
+	}
+	if expected := " ON DUPLICATE KEY UPDATE `tag`=VALUES(`tag`)"; suffix != expected {
+		t.Errorf("expected suffix %q, got %q", expected, suffix)
+	}
+
+	invalid := []struct {
+		name    string
+		table   string
+		columns []string
+		rows    [][]interface{}
+		opts    *BulkOptions
+	}{
+		{"no columns", "t", nil, rows, &BulkOptions{}},
+		{"short row", "t", []string{"a", "b"}, [][]interface{}{{1, 2}, {1}}, &BulkOptions{}},
+		{"bad table", "t`", []string{"a", "b"}, rows, &BulkOptions{}},
+		{"bad column", "t", []string{"a", "b`"}, rows, &BulkOptions{}},
+		{"bad update column", "t", []string{"a", "b"}, rows, &BulkOptions{Update: []string{"b`=1"}}},
+	}
+	for _, test := range invalid {
+		if _, _, err := bulkStatement(test.table, test.columns, test.rows, test.opts); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+// makeRows returns count rows of columnCount values each.
+func makeRows(count, columnCount int, value interface{}) [][]interface{} {
+	rows := make([][]interface{}, count)
+	for i := range rows {
+		rows[i] = make([]interface{}, columnCount)
+		for j := range rows[i] {
+			rows[i][j] = value
+		}
+	}
+	return rows
+}
+
+func chunkLengths(chunks [][][]interface{}) []int {
+	lengths := make([]int, len(chunks))
+	for i, chunk := range chunks {
+		lengths[i] = len(chunk)
+	}
+	return lengths
+}
+
+func TestBulkChunks(t *testing.T) {
+	// a single column string of 84 bytes is estimated at 100 bytes per row
+	const statementLen = 50
+	value := strings.Repeat("x", 84)
+	tenRows := bulkPacketHeadroom + statementLen + 10*100
+
+	tests := []struct {
+		name      string
+		rows      [][]interface{}
+		maxPacket int
+		maxRows   int
+		lengths   []int
+	}{
+		{"packet holds exactly 10 rows", makeRows(25, 1, value), tenRows, 0, []int{10, 10, 5}},
+		{"packet a byte short of 10 rows", makeRows(25, 1, value), tenRows - 1, 0, []int{9, 9, 7}},
+		{"rows fit in one packet", makeRows(10, 1, value), tenRows, 0, []int{10}},
+		{"oversized rows go alone", makeRows(3, 1, value), 10, 0, []int{1, 1, 1}},
+		{"max rows", makeRows(25, 1, value), tenRows, 4, []int{4, 4, 4, 4, 4, 4, 1}},
+		{"65535 placeholders with 5 columns", makeRows(30000, 5, 1), 1 << 30, 0, []int{13107, 13107, 3786}},
+		{"65534 placeholders with 7 columns", makeRows(20000, 7, 1), 1 << 30, 0, []int{9362, 9362, 1276}},
+		{"max rows above the placeholder limit", makeRows(30000, 5, 1), 1 << 30, 20000, []int{13107, 13107, 3786}},
+		{"max rows below the placeholder limit", makeRows(30000, 5, 1), 1 << 30, 10000, []int{10000, 10000, 10000}},
+	}
+	for _, test := range tests {
+		chunks := bulkChunks(test.rows, statementLen, test.maxPacket, test.maxRows)
+		if !reflect.DeepEqual(chunkLengths(chunks), test.lengths) {
+			t.Errorf("%s: expected chunks of %v rows, got %v", test.name, test.lengths, chunkLengths(chunks))
+		}
+
+		// every row is kept in order, and no chunk breaks a limit unless it is a single row
+		var joined [][]interface{}
+		for i, chunk := range chunks {
+			if len(chunk) == 0 {
+				t.Errorf("%s: chunk %d is empty", test.name, i)
+			}
+			if n := len(chunk) * len(chunk[0]); n > maxPlaceholders {
+				t.Errorf("%s: chunk %d has %d placeholders", test.name, i, n)
+			}
+			if test.maxRows > 0 && len(chunk) > test.maxRows {
+				t.Errorf("%s: chunk %d has %d rows", test.name, i, len(chunk))
+			}
+			if size := chunkSize(chunk); len(chunk) > 1 && size > test.maxPacket-bulkPacketHeadroom-statementLen {
+				t.Errorf("%s: chunk %d of %d bytes exceeds the packet", test.name, i, size)
+			}
+			joined = append(joined, chunk...)
+		}
+		if !reflect.DeepEqual(joined, test.rows) {
+			t.Errorf("%s: rows were lost or reordered", test.name)
+		}
+	}
+}
+
+func TestValueSize(t *testing.T) {
+	// the longest text a time.Time is sent as
+	at := time.Date(2024, 12, 31, 23, 59, 59, 123456000, time.UTC)
+	strTime := "'" + at.Format("2006-01-02 15:04:05.999999") + "'"
+
+	tests := []struct {
+		value interface{}
+		size  int
+	}{
+		{nil, 0},
+		{true, 1},
+		{int64(7), 8},
+		{"abc", 12},
+		{[]byte("abc"), 12},
+		{at, len(strTime)},
+		{Sensitive(at), len(strTime)},
+		{struct{}{}, bulkVariableValueSize},
+	}
+	for _, test := range tests {
+		if size := valueSize(test.value); size != test.size {
+			t.Errorf("valueSize(%#v) expected %d, got %d", test.value, test.size, size)
+		}
+	}
+}
+
+// chunkSize is the size estimate bulkChunks makes for a chunk.
+func chunkSize(chunk [][]interface{}) int {
+	size := 0
+	for _, row := range chunk {
+		columnCount := len(row)
+		size += 4*columnCount + 2 + (columnCount+7)/8
+		for _, v := range row {
+			size += valueSize(v)
+		}
+	}
+	return size
+}
@@ -0,0 +1,287 @@
+package database
+
+// ProcCall builds a stored procedure call so that callers no longer assemble "CALL sp_x(?,?,?)"
+// by hand. OUT and INOUT parameters are bound to session variables, which are read back on the
+// same connection right after the call.
+//
+//  var intCount int
+//  call := database.Call("sp_item_insert").In(intUserID, strName).Out(&intCount).Scan(&id)
+//  result, err := database.AppDb.Run(call)
+//
+// The result code follows the InsertRowResult and UpdateRowsResult convention: it is the first
+// column of the first row the procedure selects, and Scan receives the columns after it.
+// A procedure that reports its result code in an OUT parameter declares it with OutResult instead,
+// and then Scan receives the whole first row. A procedure that does neither has result code 0.
+// RunChecked returns the result code as a typed error, see RegisterResultCode.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// paramMode is the direction of a stored procedure parameter.
+type paramMode int
+
+// These are the parameter directions.
+const (
+	paramIn paramMode = iota
+	paramOut
+	paramInOut
+	paramResult // OUT parameter holding the result code
+)
+
+// procParam is one parameter of a ProcCall.
+type procParam struct {
+	mode  paramMode
+	value interface{} // IN and INOUT
+	dest  interface{} // OUT and INOUT
+}
+
+// ProcCall is a stored procedure call under construction.
+type ProcCall struct {
+	name   string
+	params []procParam
+	scan   []interface{}
+}
+
+// Call starts a call of the named stored procedure.
+// Parameters are added in declaration order by In, Out, InOut and OutResult.
+func Call(strName string) *ProcCall {
+	return &ProcCall{name: strName}
+}
+
+// In adds IN parameters.
+func (call *ProcCall) In(args ...interface{}) *ProcCall {
+	for _, arg := range args {
+		call.params = append(call.params, procParam{mode: paramIn, value: arg})
+	}
+	return call
+}
+
+// Out adds OUT parameters. Each dest is a pointer as for sql.Rows.Scan.
+func (call *ProcCall) Out(dests ...interface{}) *ProcCall {
+	for _, dest := range dests {
+		call.params = append(call.params, procParam{mode: paramOut, dest: dest})
+	}
+	return call
+}
+
+// InOut adds an INOUT parameter that is passed value and returned in dest.
+func (call *ProcCall) InOut(value, dest interface{}) *ProcCall {
+	call.params = append(call.params, procParam{mode: paramInOut, value: value, dest: dest})
+	return call
+}
+
+// OutResult adds an OUT parameter that holds the result code.
+func (call *ProcCall) OutResult() *ProcCall {
+	call.params = append(call.params, procParam{mode: paramResult})
+	return call
+}
+
+// Scan sets the destinations for the first row selected by the procedure,
+// after the result code unless OutResult is used.
+func (call *ProcCall) Scan(dests ...interface{}) *ProcCall {
+	call.scan = dests
+	return call
+}
+
+// String returns the CALL statement, or the error if the procedure name is invalid.
+func (call *ProcCall) String() string {
+	query, _, err := call.statement()
+	if err != nil {
+		return err.Error()
+	}
+	return query
+}
+
+// statement returns the CALL statement and its arguments.
+// The procedure name is quoted so it cannot carry more SQL.
+func (call *ProcCall) statement() (string, []interface{}, error) {
+	name, err := quoteIdent(call.name)
+	if err != nil {
+		return "", nil, fmt.Errorf("stored procedure name: %w", err)
+	}
+	markers := make([]string, len(call.params))
+	args := make([]interface{}, 0, len(call.params))
+	for i, param := range call.params {
+		if param.mode == paramIn {
+			markers[i] = "?"
+			args = append(args, param.value)
+		} else {
+			markers[i] = sessionVar(i)
+		}
+	}
+	return fmt.Sprintf("CALL %s(%s)", name, strings.Join(markers, ",")), args, nil
+}
+
+// sessionVar names the session variable bound to parameter i.
+func sessionVar(i int) string {
+	return fmt.Sprintf("@_p%d", i+1)
+}
+
+// Run calls the stored procedure and reads back its OUT parameters.
+// It returns the result code.
+func (dbConn *DBConnection) Run(call *ProcCall) (int, error) {
+	return dbConn.RunContext(context.Background(), call)
+}
+
+// RunContext is Run bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) RunContext(ctx context.Context, call *ProcCall) (int, error) {
+	query, args, err := call.statement()
+	if err != nil {
+		return -1, err
+	}
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+
+	// the session variables only live on one connection
+	var result int
+	conn, err := dbConn.db.Conn(ctx)
+	if err == nil {
+		result, err = runCall(ctx, conn, call, query, args)
+		conn.Close()
+	} else {
+		warnError(err, "", query, args...)
+		result = -1
+	}
+	dbConn.record("Run", startTime, err, query, args...)
+	dbConn.markWrite()
+	return result, err
+}
+
+// RunChecked is Run with the result code returned as a typed error.
+func (dbConn *DBConnection) RunChecked(call *ProcCall) error {
+	return dbConn.RunCheckedContext(context.Background(), call)
+}
+
+// RunCheckedContext is RunChecked bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) RunCheckedContext(ctx context.Context, call *ProcCall) error {
+	result, err := dbConn.RunContext(ctx, call)
+	return checkResult(result, err)
+}
+
+// Run calls the stored procedure within the transaction and reads back its OUT parameters.
+func (tx *Tx) Run(call *ProcCall) (int, error) {
+	query, args, err := call.statement()
+	if err != nil {
+		return -1, err
+	}
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	result, err := runCall(ctx, tx.tx, call, query, args)
+	tx.dbConn.record("Run", startTime, err, query, args...)
+	return result, err
+}
+
+// RunChecked is Run with the result code returned as a typed error.
+func (tx *Tx) RunChecked(call *ProcCall) error {
+	result, err := tx.Run(call)
+	return checkResult(result, err)
+}
+
+// runCall is the shared implementation of Run. The runner must be a single connection.
+func runCall(ctx context.Context, runner queryRunner, call *ProcCall, query string, args []interface{}) (int, error) {
+	strArgs := doTrace(ctx, query, args...)
+	result := -1
+
+	// INOUT values go in through their session variables
+	var sets []string
+	var values []interface{}
+	for i, param := range call.params {
+		if param.mode == paramInOut {
+			sets = append(sets, sessionVar(i)+" = ?")
+			values = append(values, param.value)
+		}
+	}
+	if len(sets) > 0 {
+		if _, err := runner.ExecContext(ctx, "SET "+strings.Join(sets, ", "), values...); err != nil {
+			warnError(err, strArgs, query, args...)
+			return result, err
+		}
+	}
+
+	code, bCode, err := readCallRow(ctx, runner, call, query, args...)
+	if err != nil {
+		warnError(err, strArgs, query, args...)
+		return result, err
+	}
+
+	// OUT values come back through their session variables
+	var vars []string
+	var dests []interface{}
+	for i, param := range call.params {
+		switch param.mode {
+		case paramOut, paramInOut:
+			vars = append(vars, sessionVar(i))
+			dests = append(dests, param.dest)
+		case paramResult:
+			vars = append(vars, sessionVar(i))
+			dests = append(dests, &code)
+			bCode = true
+		}
+	}
+	if len(vars) > 0 {
+		if err = runner.QueryRowContext(ctx, "SELECT "+strings.Join(vars, ", ")).Scan(dests...); err != nil {
+			warnError(err, strArgs, query, args...)
+			return result, err
+		}
+	}
+
+	result = 0
+	if bCode {
+		result = code
+	}
+	if result < 0 {
+		utils.Warning.Printf("query returned result:%d %s", result, refreshTrace(strArgs, query, args...))
+	}
+	return result, nil
+}
+
+// readCallRow runs the CALL and scans the first row it selects, if any.
+// It reports whether that row held the result code. Further rows and result sets are discarded.
+func readCallRow(ctx context.Context, runner queryRunner, call *ProcCall, query string, args ...interface{}) (int, bool, error) {
+	rows, err := runner.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, false, err
+	}
+	if len(columns) == 0 || !rows.Next() {
+		if err = rows.Err(); err == nil && len(call.scan) > 0 {
+			err = sql.ErrNoRows
+		}
+		return 0, false, err
+	}
+
+	var code int
+	bCode := true
+	dests := make([]interface{}, 0, len(columns))
+	for _, param := range call.params {
+		if param.mode == paramResult {
+			bCode = false
+		}
+	}
+	if bCode {
+		dests = append(dests, &code)
+	}
+	dests = append(dests, call.scan...)
+	// columns the caller did not ask for are skipped
+	for len(dests) < len(columns) {
+		dests = append(dests, new(interface{}))
+	}
+	if err = rows.Scan(dests...); err != nil {
+		return 0, false, err
+	}
+	return code, bCode, rows.Close()
+}
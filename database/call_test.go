@@ -0,0 +1,40 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProcCallStatement(t *testing.T) {
+	var intCount, intTotal int
+	call := Call("app.sp_item_insert").In(7, "x").Out(&intCount).InOut(3, &intTotal).OutResult()
+	query, args, err := call.statement()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "CALL `app`.`sp_item_insert`(?,?,@_p3,@_p4,@_p5)"; query != expected {
+		t.Errorf("expected %q, got %q", expected, query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{7, "x"}) {
+		t.Errorf("expected the IN values as arguments, got %v", args)
+	}
+	if call.String() != query {
+		t.Errorf("String() = %q, expected %q", call.String(), query)
+	}
+
+	// a name with SQL in it stays a single identifier
+	query, _, _ = Call("sp_x(); DROP TABLE user; --").statement()
+	if expected := "CALL `sp_x(); DROP TABLE user; --`()"; query != expected {
+		t.Errorf("expected %q, got %q", expected, query)
+	}
+
+	for _, strName := range []string{"", "sp_x`(); DROP TABLE user; --", "app..sp_x"} {
+		call = Call(strName).In(1)
+		if query, _, err = call.statement(); err == nil {
+			t.Errorf("Call(%q) gave %q, expected an error", strName, query)
+		}
+		if result, err := (&DBConnection{}).Run(call); err == nil || result != -1 {
+			t.Errorf("Run(Call(%q)) = %d, %v, expected an error before reaching the database", strName, result, err)
+		}
+	}
+}
@@ -4,26 +4,50 @@ package database
 // Errors are handled internally unless they indicate a failure.
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"io/ioutil"
-	"net/url"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	// This blank include forces Init() and does not need to be in main.
 	_ "github.com/knousere/web-service-commons/go-sql-driver/mysql"
-	"github.com/knousere/web-service-commons/utils"
 )
 
 // DBConnection is a container for database connection parameters.
 type DBConnection struct {
-	db           *sql.DB
-	Engine       string // mysql
-	Scheme       string // https
-	Host         string // 123.123.123.123:3306
-	Schema       string // database schema
-	User         string // database user name
-	PasswordPath string // relative path of password file
+	db               *sql.DB
+	counters         sync.Map       // wrapper name to *wrapperCounters
+	Engine           string         // mysql
+	Scheme           string         // https
+	Host             string         // 123.123.123.123:3306
+	FailoverHosts    []string       // primary hosts tried in order when Host cannot be reached
+	BreakerThreshold int            // consecutive connection failures that open the breaker, 0 for no breaker
+	BreakerCooldown  time.Duration  // open breaker wait before a probe, default 5s
+	Schema           string         // database schema
+	User             string         // database user name
+	PasswordPath     string         // relative path of password file
+	Secret           SecretProvider // password source, default FileSecret at PasswordPath
+	SecretRefresh    time.Duration  // password re-read interval for new connections, 0 for never
+	TxMaxRetries     int            // WithTx retries on deadlock, default 3
+	TxBackoff        time.Duration  // WithTx base backoff, doubled each retry up to 5s, default 50ms
+	QueryTimeout     time.Duration  // default per-call timeout except GetRows/GetOneRow, 0 for none
+	MaxOpen          int            // max open connections, 0 for unlimited
+	MaxIdle          int            // max idle connections, 0 for default 2, negative for none
+	ConnMaxLifetime  time.Duration  // max connection age, 0 for unlimited
+	ConnMaxIdleTime  time.Duration  // max connection idle time, 0 for unlimited
+	Replicas         []string       // read replica hosts, same credentials as Host
+	ReplicaPolicy    ReplicaPolicy  // ReplicaRoundRobin or ReplicaLeastLatency
+	ReplicaMaxLag    time.Duration  // drop replicas lagging further behind, 0 for no lag check
+	ReplicaCheck     time.Duration  // replica health check interval, default 10s
+	StickyPrimary    time.Duration  // reads go to the primary this long after a write
+	SlowQuery        time.Duration  // log calls slower than this to Warning, 0 for none
+	replicas         *replicaSet
+	breaker          breaker
+	lastWrite        atomic.Int64 // UnixNano of the last write
+	histograms       sync.Map     // query fingerprint to *histogram
+	fingerprintCount atomic.Int64
 }
 
 // AppDb application database instance
@@ -39,13 +63,15 @@ func (dbConn *DBConnection) Open() error {
 		fmt.Println("database.Open failed on readDbPassword", err.Error())
 		return err
 	}
-	strConnection := dbConn.GetConnectionString(strPassword)
-	// utils.Info.Println(strConnection)
-	dbConn.db, err = sql.Open(dbConn.Engine, strConnection)
+	// utils.Info.Println(dbConn.GetConnectionString(strPassword))
+	connector, err := newConnector(dbConn, dbConn.Host, strPassword)
 	if err != nil {
 		fmt.Println("database.Open failed on sql.Open", err.Error())
 		return err
 	}
+	connector.hosts = dbConn.primaryHosts()
+	dbConn.db = sql.OpenDB(connector)
+	dbConn.setPool(dbConn.db)
 
 	err = dbConn.db.Ping()
 	if err != nil {
@@ -53,42 +79,56 @@ func (dbConn *DBConnection) Open() error {
 		return err
 	}
 
+	if len(dbConn.Replicas) > 0 {
+		dbConn.replicas, err = dbConn.openReplicas(strPassword)
+		if err != nil {
+			fmt.Println("database.Open failed on openReplicas", err.Error())
+			return err
+		}
+	}
 	return nil
 }
 
+// OpenDB adopts an existing pool instead of connecting, e.g. one backed by a test driver.
+// The pool settings are applied to db. Replicas are not opened.
+func (dbConn *DBConnection) OpenDB(db *sql.DB) {
+	dbConn.db = db
+	dbConn.setPool(db)
+}
+
+// Conn reserves a single connection of the primary pool for work that depends on session state,
+// e.g. GET_LOCK or user variables. The caller must Close it to return it to the pool.
+func (dbConn *DBConnection) Conn(ctx context.Context) (*sql.Conn, error) {
+	return dbConn.db.Conn(ctx)
+}
+
 // Close database connection explicitly.
 func (dbConn *DBConnection) Close() {
+	if dbConn.replicas != nil {
+		dbConn.replicas.close()
+	}
 	if dbConn.db != nil {
 		dbConn.db.Close()
 	}
 }
 
 func (dbConn *DBConnection) readDbPassword() (string, error) {
-	passBytes, err := ioutil.ReadFile(dbConn.PasswordPath)
-	var strPassword string
-	if err == nil {
-		strPassword = string(passBytes)
-		strPassword = utils.CleanPassword(strPassword)
-	}
-	return strPassword, err
+	return dbConn.secretProvider().Secret()
 }
 
 // GetConnectionString builds a database connection string.
 func (dbConn *DBConnection) GetConnectionString(strPassword string) string {
-	u := url.URL{
-		Scheme: dbConn.Scheme,
-		Host:   fmt.Sprintf("tcp(%s)", dbConn.Host),
-		Path:   dbConn.Schema,
-		User:   url.UserPassword(dbConn.User, strPassword),
-	}
-	query := u.Query()
-	query.Set("parseTime", "true")
-	query.Set("autocommit", "true")
-	query.Set("collation", "utf8mb4_unicode_ci")
-	u.RawQuery = query.Encode()
-	strConnection := u.String()
-	if strings.HasPrefix(strConnection, "//") {
-		return strConnection[2:]
+	return dbConn.hostConnectionString(dbConn.Host, strPassword)
+}
+
+// hostConnectionString builds a database connection string for strHost, e.g. a replica.
+// The driver reads the user and password literally up to the last @ before the schema,
+// so they are not escaped. A raw password may hold any character.
+func (dbConn *DBConnection) hostConnectionString(strHost, strPassword string) string {
+	strConnection := fmt.Sprintf("%s:%s@tcp(%s)/%s?autocommit=true&collation=utf8mb4_unicode_ci&parseTime=true",
+		dbConn.User, strPassword, strHost, dbConn.Schema)
+	if dbConn.Scheme != "" {
+		return dbConn.Scheme + "://" + strConnection
 	}
 	return strConnection
 }
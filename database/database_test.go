@@ -0,0 +1,23 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/knousere/web-service-commons/go-sql-driver/mysql"
+)
+
+func TestConnectionString(t *testing.T) {
+	dbConn := &DBConnection{Host: "db.example.com:3306", User: "app", Schema: "app_schema"}
+	for _, strPassword := range []string{"secret", "p@ss/w:rd#%?", "@:/#%?", "a%40b", "(x)=&y", ""} {
+		strConnection := dbConn.GetConnectionString(strPassword)
+		dsn, err := mysql.ParseDSN(strConnection)
+		if err != nil {
+			t.Errorf("%q: %s", strConnection, err.Error())
+			continue
+		}
+		expected := mysql.DSN{User: "app", Passwd: strPassword, Net: "tcp", Addr: "db.example.com:3306", DBName: "app_schema"}
+		if *dsn != expected {
+			t.Errorf("%q parsed as %+v, expected %+v", strConnection, *dsn, expected)
+		}
+	}
+}
@@ -7,40 +7,72 @@ package database
 
 // A stored procedure cannot be called using the exec function. All stored procedures must return
 // either a dataset or a result code. The clientMultiResults flag is turned on internally.
-// However, multiple return sets are not supported and will be silently discarded if found.
+// The single set wrappers silently discard any further result sets. Use CallMulti to read them.
+
+// Every wrapper has a Context variant that is bounded by the caller's context as well as
+// DBConnection.QueryTimeout. The plain wrappers use context.Background() and so are bounded
+// by QueryTimeout alone. GetRows and GetOneRow are the exception: their result is read after
+// they return, so only the caller's context bounds them.
 
 // This pachage also has embedded trace diagnostic functionality that includes a dump of the query
 // text and arguments to the log.
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/knousere/web-service-commons/utils"
 )
 
+// queryRunner is the subset of sql.DB and sql.Tx used by the wrapper functions.
+// This allows the same wrappers to serve both DBConnection and Tx.
+type queryRunner interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 // Exec is a wrapper for the sql.DB.Exec function.
 // WARNING: do no use this for a stored procedure.
 func (dbConn *DBConnection) Exec(query string, args ...interface{}) (sql.Result, error) {
-	strArgs := doTrace(query, args...)
+	return dbConn.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext is Exec bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	result, err := exec(ctx, dbConn.db, query, args...)
+	dbConn.record("Exec", startTime, err, query, args...)
+	dbConn.markWrite()
+	return result, err
+}
+
+// exec is the shared implementation of Exec.
+func exec(ctx context.Context, runner queryRunner, query string, args ...interface{}) (sql.Result, error) {
+	strArgs := doTrace(ctx, query, args...)
 
 	if strings.HasPrefix(strings.ToUpper(query), "CALL ") {
 		err := errors.New("cannot use Exec for a stored procedure call")
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 		return nil, err
 	}
 
-	result, err := dbConn.db.Exec(query, args...)
+	result, err := runner.ExecContext(ctx, query, args...)
 	if err != nil {
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 	}
 
-	if utils.GetTrace() == 1 {
+	if err == nil && traceOn(ctx) {
 		lastInsertID, _ := result.LastInsertId()
 		affectedRows, _ := result.RowsAffected()
-		utils.Trace.Printf("AffectedRows=%d, LastInsertId=%d", affectedRows, lastInsertID)
+		strRequestID, _ := TraceID(ctx)
+		traceLogger(ctx).Printf("%sAffectedRows=%d, LastInsertId=%d", requestTag(strRequestID), affectedRows, lastInsertID)
 	}
 	return result, err
 }
@@ -59,13 +91,14 @@ func argString(query string, args ...interface{}) string {
 	return strArray
 }
 
-// doTrace puts calling arguments into trace if Trace is set.
+// doTrace puts calling arguments into trace if Trace is set globally or for ctx, see WithTrace.
 // return argString, empty of trace is not set
-func doTrace(query string, args ...interface{}) string {
+func doTrace(ctx context.Context, query string, args ...interface{}) string {
 	var strArgs string
-	if utils.GetTrace() == 1 {
-		strArgs = argString(query, args...)
-		utils.Trace.Println(strArgs)
+	if traceOn(ctx) {
+		strRequestID, _ := TraceID(ctx)
+		strArgs = requestTag(strRequestID) + argString(query, args...)
+		traceLogger(ctx).Println(strArgs)
 	}
 	return strArgs
 }
@@ -79,6 +112,34 @@ func refreshTrace(strArgs, query string, args ...interface{}) string {
 	return strArgs
 }
 
+// warnError logs a failed call along with its argument dump.
+// Timeouts and cancellations are labeled so they can be told apart from server errors.
+func warnError(err error, strArgs, query string, args ...interface{}) {
+	switch {
+	case errors.Is(err, ErrDatabaseUnavailable):
+		// the breaker already warned when it opened
+		utils.Trace.Output(2, fmt.Sprintln(err.Error(), refreshTrace(strArgs, query, args...)))
+	case errors.Is(err, context.DeadlineExceeded):
+		utils.Warning.Output(2, fmt.Sprintln("query timed out", refreshTrace(strArgs, query, args...)))
+	case errors.Is(err, context.Canceled):
+		utils.Warning.Output(2, fmt.Sprintln("query canceled", refreshTrace(strArgs, query, args...)))
+	default:
+		utils.Warning.Output(2, fmt.Sprintln(err.Error(), refreshTrace(strArgs, query, args...)))
+	}
+}
+
+// withTimeout bounds ctx by the default query timeout.
+// An earlier deadline already set on ctx is left alone.
+func (dbConn *DBConnection) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if dbConn.QueryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= dbConn.QueryTimeout {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, dbConn.QueryTimeout)
+}
+
 // LogError is a wrapper for whatever logging facility is employed locally.
 // The implementation line should be modified as necessary.
 func (dbConn *DBConnection) LogError(err error, query string, args ...interface{}) {
@@ -89,14 +150,29 @@ func (dbConn *DBConnection) LogError(err error, query string, args ...interface{
 // Errors are logged here so calling code need not be cluttered.
 // Most impartantly this never returns ErrNoRows.
 func (dbConn *DBConnection) GetRows(query string, args ...interface{}) (*sql.Rows, error) {
-	strArgs := doTrace(query, args...)
+	return dbConn.GetRowsContext(context.Background(), query, args...)
+}
+
+// GetRowsContext is GetRows bounded by ctx.
+// The default query timeout is not applied since canceling it on return would close
+// the rows. Give ctx a deadline to bound the query and the caller's row loop.
+func (dbConn *DBConnection) GetRowsContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	startTime := time.Now()
+	rows, err := getRows(ctx, dbConn.reader(ctx), query, args...)
+	dbConn.record("GetRows", startTime, err, query, args...)
+	return rows, err
+}
+
+// getRows is the shared implementation of GetRows.
+func getRows(ctx context.Context, runner queryRunner, query string, args ...interface{}) (*sql.Rows, error) {
+	strArgs := doTrace(ctx, query, args...)
 
-	rows, err := dbConn.db.Query(query, args...)
+	rows, err := runner.QueryContext(ctx, query, args...)
 	switch {
 	case err == sql.ErrNoRows:
 		return rows, nil
 	case err != nil:
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 		return rows, err
 	default:
 		return rows, nil
@@ -106,20 +182,50 @@ func (dbConn *DBConnection) GetRows(query string, args ...interface{}) (*sql.Row
 // GetOneRow is functionally identical to QueryRow().
 // This isolates the get single row case for debugging purposes
 func (dbConn *DBConnection) GetOneRow(query string, args ...interface{}) *sql.Row {
-	_ = doTrace(query, args...)
-	return dbConn.db.QueryRow(query, args...)
+	return dbConn.GetOneRowContext(context.Background(), query, args...)
+}
+
+// GetOneRowContext is GetOneRow bounded by ctx.
+// As with GetRowsContext the row is scanned after return, so the default query timeout
+// is not applied.
+func (dbConn *DBConnection) GetOneRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	startTime := time.Now()
+	row := getOneRow(ctx, dbConn.reader(ctx), query, args...)
+	dbConn.record("GetOneRow", startTime, nil, query, args...)
+	return row
+}
+
+// getOneRow is the shared implementation of GetOneRow.
+func getOneRow(ctx context.Context, runner queryRunner, query string, args ...interface{}) *sql.Row {
+	_ = doTrace(ctx, query, args...)
+	return runner.QueryRowContext(ctx, query, args...)
 }
 
 // GetPositiveInt gets one row that consists of only a positive integer.
 // This is usually either a record id or a count.
 // A negative value indicates a problem.
 func (dbConn *DBConnection) GetPositiveInt(query string, args ...interface{}) (int, error) {
-	strArgs := doTrace(query, args...)
+	return dbConn.GetPositiveIntContext(context.Background(), query, args...)
+}
+
+// GetPositiveIntContext is GetPositiveInt bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) GetPositiveIntContext(ctx context.Context, query string, args ...interface{}) (int, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	intValue, err := getPositiveInt(ctx, dbConn.reader(ctx), query, args...)
+	dbConn.record("GetPositiveInt", startTime, err, query, args...)
+	return intValue, err
+}
+
+// getPositiveInt is the shared implementation of GetPositiveInt.
+func getPositiveInt(ctx context.Context, runner queryRunner, query string, args ...interface{}) (int, error) {
+	strArgs := doTrace(ctx, query, args...)
 	var intValue int
-	err := dbConn.db.QueryRow(query, args...).Scan(&intValue)
+	err := runner.QueryRowContext(ctx, query, args...).Scan(&intValue)
 	switch {
 	case err != nil:
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 	case intValue < 0:
 		utils.Warning.Printf("query returned value:%d %s", intValue, refreshTrace(strArgs, query, args...))
 	}
@@ -129,14 +235,29 @@ func (dbConn *DBConnection) GetPositiveInt(query string, args ...interface{}) (i
 // GetPositiveIntDefault gets one row that consists of only a positive integer.
 // It returns default value rather than ErrNoRows.
 func (dbConn *DBConnection) GetPositiveIntDefault(intDefault int, query string, args ...interface{}) (int, error) {
-	strArgs := doTrace(query, args...)
+	return dbConn.GetPositiveIntDefaultContext(context.Background(), intDefault, query, args...)
+}
+
+// GetPositiveIntDefaultContext is GetPositiveIntDefault bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) GetPositiveIntDefaultContext(ctx context.Context, intDefault int, query string, args ...interface{}) (int, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	intValue, err := getPositiveIntDefault(ctx, dbConn.db, intDefault, query, args...)
+	dbConn.record("GetPositiveIntDefault", startTime, err, query, args...)
+	return intValue, err
+}
+
+// getPositiveIntDefault is the shared implementation of GetPositiveIntDefault.
+func getPositiveIntDefault(ctx context.Context, runner queryRunner, intDefault int, query string, args ...interface{}) (int, error) {
+	strArgs := doTrace(ctx, query, args...)
 	var intValue int
-	err := dbConn.db.QueryRow(query, args...).Scan(&intValue)
+	err := runner.QueryRowContext(ctx, query, args...).Scan(&intValue)
 	switch {
 	case err == sql.ErrNoRows:
 		return intDefault, nil
 	case err != nil:
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 	case intValue < 0:
 		utils.Warning.Printf("query returned value:%d %s", intValue, refreshTrace(strArgs, query, args...))
 	}
@@ -145,17 +266,32 @@ func (dbConn *DBConnection) GetPositiveIntDefault(intDefault int, query string,
 
 // GetRecordID returns record Id or 0 rather than ErrNoRows.
 func (dbConn *DBConnection) GetRecordID(query string, args ...interface{}) (int, error) {
-	strArgs := doTrace(query, args...)
+	return dbConn.GetRecordIDContext(context.Background(), query, args...)
+}
+
+// GetRecordIDContext is GetRecordID bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) GetRecordIDContext(ctx context.Context, query string, args ...interface{}) (int, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	id, err := getRecordID(ctx, dbConn.db, query, args...)
+	dbConn.record("GetRecordID", startTime, err, query, args...)
+	return id, err
+}
+
+// getRecordID is the shared implementation of GetRecordID.
+func getRecordID(ctx context.Context, runner queryRunner, query string, args ...interface{}) (int, error) {
+	strArgs := doTrace(ctx, query, args...)
 	var intID int
-	err := dbConn.db.QueryRow(query, args...).Scan(&intID)
+	err := runner.QueryRowContext(ctx, query, args...).Scan(&intID)
 	switch {
 	case err == sql.ErrNoRows:
-		utils.Trace.Println("record not found", refreshTrace(strArgs, query, args...))
+		traceLogger(ctx).Println("record not found", refreshTrace(strArgs, query, args...))
 		return 0, nil
 	case err != nil:
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 	case intID == 0:
-		utils.Trace.Println("record not found", refreshTrace(strArgs, query, args...))
+		traceLogger(ctx).Println("record not found", refreshTrace(strArgs, query, args...))
 	case intID < 0:
 		utils.Warning.Printf("query returned id:%d %s", intID, refreshTrace(strArgs, query, args...))
 	}
@@ -164,12 +300,27 @@ func (dbConn *DBConnection) GetRecordID(query string, args ...interface{}) (int,
 
 // GetRecordCount returns record count. ErrNoRows is an error.
 func (dbConn *DBConnection) GetRecordCount(query string, args ...interface{}) (int, error) {
-	strArgs := doTrace(query, args...)
+	return dbConn.GetRecordCountContext(context.Background(), query, args...)
+}
+
+// GetRecordCountContext is GetRecordCount bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) GetRecordCountContext(ctx context.Context, query string, args ...interface{}) (int, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	count, err := getRecordCount(ctx, dbConn.reader(ctx), query, args...)
+	dbConn.record("GetRecordCount", startTime, err, query, args...)
+	return count, err
+}
+
+// getRecordCount is the shared implementation of GetRecordCount.
+func getRecordCount(ctx context.Context, runner queryRunner, query string, args ...interface{}) (int, error) {
+	strArgs := doTrace(ctx, query, args...)
 	var intCount int
-	err := dbConn.db.QueryRow(query, args...).Scan(&intCount)
+	err := runner.QueryRowContext(ctx, query, args...).Scan(&intCount)
 	switch {
 	case err != nil:
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 	case intCount < 0:
 		utils.Warning.Printf("query returned count:%d %s", intCount, refreshTrace(strArgs, query, args...))
 	}
@@ -179,12 +330,27 @@ func (dbConn *DBConnection) GetRecordCount(query string, args ...interface{}) (i
 // GetOneString returns one row that consists of only a string value.
 // This is usually an external key.
 func (dbConn *DBConnection) GetOneString(query string, args ...interface{}) (string, error) {
-	strArgs := doTrace(query, args...)
+	return dbConn.GetOneStringContext(context.Background(), query, args...)
+}
+
+// GetOneStringContext is GetOneString bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) GetOneStringContext(ctx context.Context, query string, args ...interface{}) (string, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	strValue, err := getOneString(ctx, dbConn.reader(ctx), query, args...)
+	dbConn.record("GetOneString", startTime, err, query, args...)
+	return strValue, err
+}
+
+// getOneString is the shared implementation of GetOneString.
+func getOneString(ctx context.Context, runner queryRunner, query string, args ...interface{}) (string, error) {
+	strArgs := doTrace(ctx, query, args...)
 	var strValue string
-	err := dbConn.db.QueryRow(query, args...).Scan(&strValue)
+	err := runner.QueryRowContext(ctx, query, args...).Scan(&strValue)
 
 	if err != nil {
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 	}
 
 	return strValue, err
@@ -194,12 +360,28 @@ func (dbConn *DBConnection) GetOneString(query string, args ...interface{}) (str
 // An ID should always be returned so all errors including ErrNoRows are an
 // indication of a server problem.
 func (dbConn *DBConnection) InsertRow(query string, args ...interface{}) (int, error) {
-	strArgs := doTrace(query, args...)
+	return dbConn.InsertRowContext(context.Background(), query, args...)
+}
+
+// InsertRowContext is InsertRow bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) InsertRowContext(ctx context.Context, query string, args ...interface{}) (int, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	id, err := insertRow(ctx, dbConn.db, query, args...)
+	dbConn.record("InsertRow", startTime, err, query, args...)
+	dbConn.markWrite()
+	return id, err
+}
+
+// insertRow is the shared implementation of InsertRow.
+func insertRow(ctx context.Context, runner queryRunner, query string, args ...interface{}) (int, error) {
+	strArgs := doTrace(ctx, query, args...)
 	var id int
-	err := dbConn.db.QueryRow(query, args...).Scan(&id)
+	err := runner.QueryRowContext(ctx, query, args...).Scan(&id)
 	switch {
 	case err != nil:
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 	case id < 1:
 		utils.Warning.Printf("query returned id:%d %s", id, refreshTrace(strArgs, query, args...))
 	}
@@ -210,12 +392,28 @@ func (dbConn *DBConnection) InsertRow(query string, args ...interface{}) (int, e
 // Result -2 typically indicates a permissions error.
 // An ID should always be returned so all errors including ErrNoRows indicate a server problem.
 func (dbConn *DBConnection) InsertRowResult(query string, args ...interface{}) (int, int, error) {
-	strArgs := doTrace(query, args...)
+	return dbConn.InsertRowResultContext(context.Background(), query, args...)
+}
+
+// InsertRowResultContext is InsertRowResult bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) InsertRowResultContext(ctx context.Context, query string, args ...interface{}) (int, int, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	result, id, err := insertRowResult(ctx, dbConn.db, query, args...)
+	dbConn.record("InsertRowResult", startTime, err, query, args...)
+	dbConn.markWrite()
+	return result, id, err
+}
+
+// insertRowResult is the shared implementation of InsertRowResult.
+func insertRowResult(ctx context.Context, runner queryRunner, query string, args ...interface{}) (int, int, error) {
+	strArgs := doTrace(ctx, query, args...)
 	var result, id int
-	err := dbConn.db.QueryRow(query, args...).Scan(&result, &id)
+	err := runner.QueryRowContext(ctx, query, args...).Scan(&result, &id)
 	switch {
 	case err != nil:
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 	case id < 1:
 		utils.Warning.Printf("query returned id:%d %s", id, refreshTrace(strArgs, query, args...))
 	}
@@ -226,12 +424,28 @@ func (dbConn *DBConnection) InsertRowResult(query string, args ...interface{}) (
 // An affected count should always be returned so all errors including ErrNoRows indicate
 // a server problem.
 func (dbConn *DBConnection) UpdateRows(query string, args ...interface{}) (int, error) {
-	strArgs := doTrace(query, args...)
+	return dbConn.UpdateRowsContext(context.Background(), query, args...)
+}
+
+// UpdateRowsContext is UpdateRows bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) UpdateRowsContext(ctx context.Context, query string, args ...interface{}) (int, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	affectedCount, err := updateRows(ctx, dbConn.db, query, args...)
+	dbConn.record("UpdateRows", startTime, err, query, args...)
+	dbConn.markWrite()
+	return affectedCount, err
+}
+
+// updateRows is the shared implementation of UpdateRows.
+func updateRows(ctx context.Context, runner queryRunner, query string, args ...interface{}) (int, error) {
+	strArgs := doTrace(ctx, query, args...)
 	var affectedCount int
-	err := dbConn.db.QueryRow(query, args...).Scan(&affectedCount)
+	err := runner.QueryRowContext(ctx, query, args...).Scan(&affectedCount)
 	switch {
 	case err != nil:
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 	case affectedCount < 0:
 		utils.Warning.Printf("query returned count:%d %s", affectedCount, refreshTrace(strArgs, query, args...))
 	}
@@ -239,28 +453,63 @@ func (dbConn *DBConnection) UpdateRows(query string, args ...interface{}) (int,
 }
 
 // UpdateRowsWithDeadlock updates row(s) and returns count of affected rows and deadlock flag.
-// The query is expected to be a stored procedure.
+// The query is expected to be a stored procedure. A deadlock or lock wait timeout error
+// of the server sets the flag as well.
 func (dbConn *DBConnection) UpdateRowsWithDeadlock(query string, args ...interface{}) (int, bool) {
-	strArgs := doTrace(query, args...)
+	return dbConn.UpdateRowsWithDeadlockContext(context.Background(), query, args...)
+}
+
+// UpdateRowsWithDeadlockContext is UpdateRowsWithDeadlock bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) UpdateRowsWithDeadlockContext(ctx context.Context, query string, args ...interface{}) (int, bool) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	affectedCount, bDeadlock, err := updateRowsWithDeadlock(ctx, dbConn.db, query, args...)
+	dbConn.recordDeadlock("UpdateRowsWithDeadlock", startTime, bDeadlock, err, query, args...)
+	dbConn.markWrite()
+	return affectedCount, bDeadlock
+}
+
+// updateRowsWithDeadlock is the shared implementation of UpdateRowsWithDeadlock.
+// A deadlock or lock wait timeout error of the server sets the deadlock flag as well.
+func updateRowsWithDeadlock(ctx context.Context, runner queryRunner, query string, args ...interface{}) (int, bool, error) {
+	strArgs := doTrace(ctx, query, args...)
 	var affectedCount int
 	var bDeadlock bool
-	err := dbConn.db.QueryRow(query, args...).Scan(&affectedCount, &bDeadlock)
+	err := runner.QueryRowContext(ctx, query, args...).Scan(&affectedCount, &bDeadlock)
 	if err != nil {
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
+		bDeadlock = IsDeadlock(err)
 	}
-	return affectedCount, bDeadlock
+	return affectedCount, bDeadlock, err
 }
 
 // UpdateRowsResult updates row(s) and returns result code and affected count if there was no error in SP.
 // A result code should always be returned so all errors including ErrNoRows indicate
 // a server problem.
 func (dbConn *DBConnection) UpdateRowsResult(query string, args ...interface{}) (int, int, error) {
-	strArgs := doTrace(query, args...)
+	return dbConn.UpdateRowsResultContext(context.Background(), query, args...)
+}
+
+// UpdateRowsResultContext is UpdateRowsResult bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) UpdateRowsResultContext(ctx context.Context, query string, args ...interface{}) (int, int, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	result, affectedCount, err := updateRowsResult(ctx, dbConn.db, query, args...)
+	dbConn.record("UpdateRowsResult", startTime, err, query, args...)
+	dbConn.markWrite()
+	return result, affectedCount, err
+}
+
+// updateRowsResult is the shared implementation of UpdateRowsResult.
+func updateRowsResult(ctx context.Context, runner queryRunner, query string, args ...interface{}) (int, int, error) {
+	strArgs := doTrace(ctx, query, args...)
 	var result, affectedCount int
-	err := dbConn.db.QueryRow(query, args...).Scan(&result, &affectedCount)
+	err := runner.QueryRowContext(ctx, query, args...).Scan(&result, &affectedCount)
 	switch {
 	case err != nil:
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 		result = -1
 	case result < 0:
 		utils.Warning.Printf("query returned result:%d %s", result, refreshTrace(strArgs, query, args...))
@@ -275,12 +524,28 @@ func (dbConn *DBConnection) UpdateRowsResult(query string, args ...interface{})
 // a server problem.
 // Yes, this looks identical to UpdateRows. It is segregated to make debugging more clear.
 func (dbConn *DBConnection) DeleteRows(query string, args ...interface{}) (int, error) {
-	strArgs := doTrace(query, args...)
+	return dbConn.DeleteRowsContext(context.Background(), query, args...)
+}
+
+// DeleteRowsContext is DeleteRows bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) DeleteRowsContext(ctx context.Context, query string, args ...interface{}) (int, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	affectedCount, err := deleteRows(ctx, dbConn.db, query, args...)
+	dbConn.record("DeleteRows", startTime, err, query, args...)
+	dbConn.markWrite()
+	return affectedCount, err
+}
+
+// deleteRows is the shared implementation of DeleteRows.
+func deleteRows(ctx context.Context, runner queryRunner, query string, args ...interface{}) (int, error) {
+	strArgs := doTrace(ctx, query, args...)
 	var affectedCount int
-	err := dbConn.db.QueryRow(query, args...).Scan(&affectedCount)
+	err := runner.QueryRowContext(ctx, query, args...).Scan(&affectedCount)
 	switch {
 	case err != nil:
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 	case affectedCount < 0:
 		utils.Warning.Printf("query returned count:%d %s", affectedCount, refreshTrace(strArgs, query, args...))
 	}
@@ -292,12 +557,28 @@ func (dbConn *DBConnection) DeleteRows(query string, args ...interface{}) (int,
 // An affected count should always be returned so all errors including ErrNoRows indicate
 // a server problem.
 func (dbConn *DBConnection) DeleteRowsResult(query string, args ...interface{}) (int, int, error) {
-	strArgs := doTrace(query, args...)
+	return dbConn.DeleteRowsResultContext(context.Background(), query, args...)
+}
+
+// DeleteRowsResultContext is DeleteRowsResult bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) DeleteRowsResultContext(ctx context.Context, query string, args ...interface{}) (int, int, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	result, affectedCount, err := deleteRowsResult(ctx, dbConn.db, query, args...)
+	dbConn.record("DeleteRowsResult", startTime, err, query, args...)
+	dbConn.markWrite()
+	return result, affectedCount, err
+}
+
+// deleteRowsResult is the shared implementation of DeleteRowsResult.
+func deleteRowsResult(ctx context.Context, runner queryRunner, query string, args ...interface{}) (int, int, error) {
+	strArgs := doTrace(ctx, query, args...)
 	var result, affectedCount int
-	err := dbConn.db.QueryRow(query, args...).Scan(&result, &affectedCount)
+	err := runner.QueryRowContext(ctx, query, args...).Scan(&result, &affectedCount)
 	switch {
 	case err != nil:
-		utils.Warning.Println(err.Error(), refreshTrace(strArgs, query, args...))
+		warnError(err, strArgs, query, args...)
 	case result < 0:
 		utils.Warning.Printf("query returned result:%d %s", result, refreshTrace(strArgs, query, args...))
 	case affectedCount < 0:
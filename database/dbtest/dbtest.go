@@ -0,0 +1,478 @@
+// Package dbtest provides a scriptable in-memory stand-in for mysql so that code which calls
+// the database wrapper functions can be unit tested without a live server.
+//
+// A Fake is a database/sql driver. Fake.DB returns a real *database.DBConnection on top of it,
+// so every wrapper, Tx and the generic Select and Get behave exactly as they do against mysql.
+// Queries are answered by rules, matched in the order they were added:
+//
+//	fake := dbtest.New()
+//	fake.OnCall("sp_user_get").Rows([]string{"user_id", "username"}, []interface{}{7, "ann"})
+//	fake.OnCall("sp_user_update").Result(0, 1)  // UpdateRowsResult: result code, affected count
+//	fake.OnCall("sp_user_insert").Result(-2, 0) // InsertRowResult: permission denied
+//	fake.OnCall("sp_vote").Deadlock(0)          // UpdateRowsWithDeadlock reports a deadlock
+//	fake.OnQuery(`^SELECT .* FROM audit`).Error(sql.ErrConnDone)
+//
+//	h := handler{db: fake.DB()}
+//	...
+//	fake.AssertCalled(t, "sp_user_update", 7, "ann")
+//	fake.AssertExpectations(t)
+//
+// An unmatched query fails with an error naming the query, and is still recorded as a call.
+// Transactions are recorded as BEGIN, COMMIT and ROLLBACK calls, which need no rule.
+// A matching OnQuery rule can make them fail, e.g. OnQuery("^COMMIT$").Error(sql.ErrConnDone).
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/knousere/web-service-commons/database"
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// Call is one query received by a Fake.
+type Call struct {
+	Query string
+	Args  []interface{}
+}
+
+// Name returns the stored procedure name of a CALL, or "" for any other query.
+func (c Call) Name() string {
+	return callName(c.Query)
+}
+
+// Fake is a scriptable database/sql driver.
+type Fake struct {
+	mutex sync.Mutex
+	rules []*Rule
+	calls []Call
+}
+
+// New returns a Fake with no rules.
+// The utils logs are initialized to discard trace and info if the test has not done so,
+// since the wrappers log through them.
+func New() *Fake {
+	if utils.Warning == nil {
+		utils.InitLog(utils.LogNil, utils.LogNil, utils.LogStderr, utils.LogStderr)
+	}
+	return &Fake{}
+}
+
+// DB returns a DBConnection backed by the Fake.
+func (f *Fake) DB() *database.DBConnection {
+	dbConn := &database.DBConnection{Engine: "dbtest", Schema: "dbtest"}
+	dbConn.OpenDB(sql.OpenDB(f))
+	return dbConn
+}
+
+// OnCall adds a rule that matches CALL of the named stored procedure. The name is not case sensitive.
+func (f *Fake) OnCall(name string) *Rule {
+	return f.addRule(&Rule{name: strings.ToLower(name)})
+}
+
+// OnQuery adds a rule that matches queries by regular expression.
+func (f *Fake) OnQuery(pattern string) *Rule {
+	return f.addRule(&Rule{re: regexp.MustCompile(pattern)})
+}
+
+func (f *Fake) addRule(rule *Rule) *Rule {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.rules = append(f.rules, rule)
+	return rule
+}
+
+// Calls returns every query received so far in order.
+func (f *Fake) Calls() []Call {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// CallsTo returns the calls of the named stored procedure.
+func (f *Fake) CallsTo(name string) []Call {
+	calls := make([]Call, 0, 5)
+	for _, call := range f.Calls() {
+		if strings.EqualFold(call.Name(), name) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the calls received so far. The rules are kept.
+func (f *Fake) Reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls = nil
+}
+
+// AssertCalled fails t unless the named stored procedure was called with args.
+// Integer arguments compare equal regardless of their int type.
+func (f *Fake) AssertCalled(t testing.TB, name string, args ...interface{}) {
+	t.Helper()
+	calls := f.CallsTo(name)
+	for _, call := range calls {
+		if argsEqual(call.Args, args) {
+			return
+		}
+	}
+	if len(calls) == 0 {
+		t.Errorf("dbtest: %s was not called", name)
+		return
+	}
+	strCalls := make([]string, len(calls))
+	for i, call := range calls {
+		strCalls[i] = fmt.Sprint(call.Args)
+	}
+	t.Errorf("dbtest: %s was not called with %v, calls were %s", name, args, strings.Join(strCalls, ", "))
+}
+
+// AssertNotCalled fails t if the named stored procedure was called.
+func (f *Fake) AssertNotCalled(t testing.TB, name string) {
+	t.Helper()
+	if calls := f.CallsTo(name); len(calls) > 0 {
+		t.Errorf("dbtest: %s was called %d times", name, len(calls))
+	}
+}
+
+// AssertExpectations fails t for every rule that never matched.
+func (f *Fake) AssertExpectations(t testing.TB) {
+	t.Helper()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, rule := range f.rules {
+		if rule.matched == 0 {
+			t.Errorf("dbtest: expected %s was not called", rule)
+		}
+	}
+}
+
+// answer records the call and returns the rule that answers it.
+func (f *Fake) answer(query string, named []driver.NamedValue) (*Rule, error) {
+	args := make([]interface{}, len(named))
+	for i, nv := range named {
+		args[i] = nv.Value
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls = append(f.calls, Call{Query: query, Args: args})
+	for _, rule := range f.rules {
+		if rule.matches(query) {
+			rule.matched++
+			return rule, rule.err
+		}
+	}
+	return nil, fmt.Errorf("dbtest: no rule matches query %q", query)
+}
+
+// answerTx records BEGIN, COMMIT or ROLLBACK and returns the error of a matching query rule.
+func (f *Fake) answerTx(statement string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls = append(f.calls, Call{Query: statement})
+	for _, rule := range f.rules {
+		if rule.re != nil && rule.matches(statement) {
+			rule.matched++
+			return rule.err
+		}
+	}
+	return nil
+}
+
+// Connect implements driver.Connector.
+func (f *Fake) Connect(ctx context.Context) (driver.Conn, error) {
+	return &conn{fake: f}, nil
+}
+
+// Driver implements driver.Connector.
+func (f *Fake) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+// Rule is a canned answer to matching queries.
+type Rule struct {
+	name    string // stored procedure name
+	re      *regexp.Regexp
+	limit   int // matches allowed, 0 for unlimited
+	matched int
+	sets    []resultSet
+	err     error
+	result  driver.Result
+}
+
+// resultSet is one canned result set.
+type resultSet struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// Rows adds a result set. Call it more than once for a stored procedure that returns
+// several result sets, e.g. for CallMulti. Values are converted as database/sql converts
+// query arguments, so int, bool, string, []byte, float64, time.Time and nil are all fine.
+func (r *Rule) Rows(columns []string, rows ...[]interface{}) *Rule {
+	set := resultSet{columns: columns, rows: make([][]driver.Value, len(rows))}
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			panic(fmt.Sprintf("dbtest: row %d has %d values for %d columns", i, len(row), len(columns)))
+		}
+		set.rows[i] = make([]driver.Value, len(row))
+		for j, v := range row {
+			value, err := driver.DefaultParameterConverter.ConvertValue(v)
+			if err != nil {
+				panic(fmt.Sprintf("dbtest: row %d column %s: %s", i, columns[j], err.Error()))
+			}
+			set.rows[i][j] = value
+		}
+	}
+	r.sets = append(r.sets, set)
+	return r
+}
+
+// Result answers with a single row holding a result code followed by values,
+// e.g. Result(0, intID) for InsertRowResult or Result(0, affectedCount) for UpdateRowsResult.
+func (r *Rule) Result(code int, values ...interface{}) *Rule {
+	columns := []string{"result"}
+	for i := range values {
+		columns = append(columns, fmt.Sprintf("value%d", i+1))
+	}
+	return r.Rows(columns, append([]interface{}{code}, values...))
+}
+
+// Value answers with a single row holding one value, e.g. for GetRecordCount or InsertRow.
+func (r *Rule) Value(value interface{}) *Rule {
+	return r.Rows([]string{"value"}, []interface{}{value})
+}
+
+// Deadlock answers UpdateRowsWithDeadlock with the affected count and a deadlock.
+func (r *Rule) Deadlock(affectedCount int) *Rule {
+	return r.Rows([]string{"affected_count", "deadlock"}, []interface{}{affectedCount, true})
+}
+
+// Error answers with err.
+func (r *Rule) Error(err error) *Rule {
+	r.err = err
+	return r
+}
+
+// ExecResult sets the result of Exec.
+func (r *Rule) ExecResult(lastInsertID, rowsAffected int64) *Rule {
+	r.result = execResult{lastInsertID: lastInsertID, rowsAffected: rowsAffected}
+	return r
+}
+
+// Times limits the rule to n matches, after which later rules are tried.
+func (r *Rule) Times(n int) *Rule {
+	r.limit = n
+	return r
+}
+
+// Once limits the rule to a single match.
+func (r *Rule) Once() *Rule {
+	return r.Times(1)
+}
+
+func (r *Rule) matches(query string) bool {
+	if r.limit > 0 && r.matched >= r.limit {
+		return false
+	}
+	if r.re != nil {
+		return r.re.MatchString(query)
+	}
+	return callName(query) == r.name
+}
+
+// String describes the rule.
+func (r *Rule) String() string {
+	if r.re != nil {
+		return "query " + r.re.String()
+	}
+	return "CALL " + r.name
+}
+
+var reCall = regexp.MustCompile("(?i)^\\s*call\\s+([\\w$.`]+)")
+
+// callName returns the lower case stored procedure name of a CALL query without quotes.
+func callName(query string) string {
+	m := reCall.FindStringSubmatch(query)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(strings.ReplaceAll(m[1], "`", ""))
+}
+
+// argsEqual compares recorded driver values with expected arguments.
+func argsEqual(actual, expected []interface{}) bool {
+	if len(actual) != len(expected) {
+		return false
+	}
+	for i, v := range expected {
+		value, err := driver.DefaultParameterConverter.ConvertValue(v)
+		if err != nil {
+			value = v
+		}
+		if !reflect.DeepEqual(actual[i], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeDriver only exists to satisfy driver.Connector. Fake.DB never calls Open.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("dbtest: use Fake.DB")
+}
+
+// conn is a connection to a Fake.
+type conn struct {
+	fake *Fake
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	if err := c.fake.answerTx("BEGIN"); err != nil {
+		return nil, err
+	}
+	return tx{fake: c.fake}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rule, err := c.fake.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{sets: rule.sets}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rule, err := c.fake.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if rule.result == nil {
+		return execResult{}, nil
+	}
+	return rule.result, nil
+}
+
+// stmt is a prepared statement on a Fake.
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+// tx is a transaction on a Fake. Nothing is rolled back.
+type tx struct {
+	fake *Fake
+}
+
+func (t tx) Commit() error {
+	return t.fake.answerTx("COMMIT")
+}
+
+func (t tx) Rollback() error {
+	return t.fake.answerTx("ROLLBACK")
+}
+
+// execResult is the canned result of Exec.
+type execResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r execResult) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+func (r execResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+// rows iterates over the canned result sets of a rule.
+type rows struct {
+	sets []resultSet
+	set  int
+	row  int
+}
+
+func (r *rows) Columns() []string {
+	if r.set >= len(r.sets) {
+		return []string{}
+	}
+	return r.sets[r.set].columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.set >= len(r.sets) || r.row >= len(r.sets[r.set].rows) {
+		return io.EOF
+	}
+	copy(dest, r.sets[r.set].rows[r.row])
+	r.row++
+	return nil
+}
+
+func (r *rows) HasNextResultSet() bool {
+	return r.set+1 < len(r.sets)
+}
+
+func (r *rows) NextResultSet() error {
+	if !r.HasNextResultSet() {
+		return io.EOF
+	}
+	r.set++
+	r.row = 0
+	return nil
+}
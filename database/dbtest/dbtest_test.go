@@ -0,0 +1,245 @@
+package dbtest_test
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/knousere/web-service-commons/database"
+	"github.com/knousere/web-service-commons/database/dbtest"
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// TestMain quiets the logs the wrappers write to, including the warnings of failing queries.
+func TestMain(m *testing.M) {
+	utils.InitLog(utils.LogNil, utils.LogNil, utils.LogNil, utils.LogNil)
+	os.Exit(m.Run())
+}
+
+// recorder collects the failures an assertion reports instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRows(t *testing.T) {
+	fake := dbtest.New()
+	fake.OnCall("SP_USER_GET").Rows([]string{"user_id", "username", "email"},
+		[]interface{}{7, "ann", nil},
+		[]interface{}{8, "bob", "bob@example.com"},
+	)
+	db := fake.DB()
+
+	type user struct {
+		ID    int            `db:"user_id"`
+		Name  string         `db:"username"`
+		Email sql.NullString `db:"email"`
+	}
+	users, err := database.Select[user](db, "CALL sp_user_get(?)", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []user{{7, "ann", sql.NullString{}}, {8, "bob", sql.NullString{String: "bob@example.com", Valid: true}}}
+	if len(users) != 2 || users[0] != expected[0] || users[1] != expected[1] {
+		t.Errorf("expected %v, got %v", expected, users)
+	}
+
+	rows, err := db.GetRows("call Sp_User_Get(?)", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	rows.Close()
+	if count != 2 {
+		t.Errorf("expected 2 rows, got %d", count)
+	}
+	fake.AssertCalled(t, "sp_user_get", 3)
+	if calls := fake.CallsTo("sp_user_get"); len(calls) != 2 {
+		t.Errorf("expected 2 calls, got %d", len(calls))
+	}
+}
+
+func TestRuleOrder(t *testing.T) {
+	fake := dbtest.New()
+	fake.OnQuery(`^SELECT COUNT\(\*\) FROM user`).Once().Value(1)
+	fake.OnQuery(`^SELECT COUNT\(\*\) FROM user`).Times(2).Value(2)
+	fake.OnQuery(`^SELECT COUNT`).Value(9)
+	db := fake.DB()
+
+	for i, expected := range []int{1, 2, 2, 9, 9} {
+		count, err := db.GetRecordCount("SELECT COUNT(*) FROM user")
+		if err != nil || count != expected {
+			t.Errorf("query %d: expected %d, got %d, %v", i, expected, count, err)
+		}
+	}
+	if count, err := db.GetRecordCount("SELECT COUNT(*) FROM team"); err != nil || count != 9 {
+		t.Errorf("expected the catch all rule, got %d, %v", count, err)
+	}
+}
+
+func TestResults(t *testing.T) {
+	fake := dbtest.New()
+	fake.OnCall("sp_user_update").Result(0, 1)
+	fake.OnCall("sp_user_insert").Result(-2, 0)
+	fake.OnCall("sp_vote").Deadlock(0)
+	fake.OnCall("sp_user_report").Result(0).
+		Rows([]string{"user_id"}, []interface{}{7}, []interface{}{8}).
+		Rows([]string{"team"}, []interface{}{"red"})
+	db := fake.DB()
+
+	if result, count, err := db.UpdateRowsResult("CALL sp_user_update(?, ?)", 7, "ann"); result != 0 || count != 1 || err != nil {
+		t.Errorf("UpdateRowsResult: %d, %d, %v", result, count, err)
+	}
+	if result, err := db.Run(database.Call("sp_user_update").In(7, "ann")); result != 0 || err != nil {
+		t.Errorf("Run: %d, %v", result, err)
+	}
+	if result, _, err := db.InsertRowResult("CALL sp_user_insert(?)", "ann"); result != -2 || err != nil {
+		t.Errorf("InsertRowResult: %d, %v", result, err)
+	}
+	if _, bDeadlock := db.UpdateRowsWithDeadlock("CALL sp_vote(?)", 7); !bDeadlock {
+		t.Error("UpdateRowsWithDeadlock did not report the deadlock")
+	}
+
+	result, sets, err := db.CallMulti("CALL sp_user_report()")
+	if err != nil || result != 0 || len(sets) != 2 {
+		t.Fatalf("CallMulti: %d, %d sets, %v", result, len(sets), err)
+	}
+	if len(sets[0].Rows) != 2 || sets[1].Columns[0] != "team" || sets[1].Rows[0][0] != "red" {
+		t.Errorf("CallMulti: unexpected sets %v", sets)
+	}
+}
+
+func TestExec(t *testing.T) {
+	fake := dbtest.New()
+	fake.OnQuery(`^INSERT INTO audit`).ExecResult(42, 1)
+	fake.OnQuery(`^DELETE FROM audit`)
+	db := fake.DB()
+
+	result, err := db.Exec("INSERT INTO audit (action) VALUES (?)", "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := result.LastInsertId(); id != 42 {
+		t.Errorf("expected insert id 42, got %d", id)
+	}
+	if count, _ := result.RowsAffected(); count != 1 {
+		t.Errorf("expected 1 row affected, got %d", count)
+	}
+
+	result, err = db.Exec("DELETE FROM audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := result.RowsAffected(); count != 0 {
+		t.Errorf("expected no rows affected without ExecResult, got %d", count)
+	}
+	calls := fake.Calls()
+	if len(calls) != 2 || calls[0].Args[0] != "login" || calls[1].Name() != "" {
+		t.Errorf("unexpected calls %v", calls)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	fake := dbtest.New()
+	fake.OnQuery(`^SELECT .* FROM audit`).Error(sql.ErrConnDone)
+	db := fake.DB()
+
+	if _, err := db.GetRows("SELECT * FROM audit"); !errors.Is(err, sql.ErrConnDone) {
+		t.Errorf("expected sql.ErrConnDone, got %v", err)
+	}
+	if _, err := db.Exec("SELECT * FROM audit"); !errors.Is(err, sql.ErrConnDone) {
+		t.Errorf("Exec: expected sql.ErrConnDone, got %v", err)
+	}
+
+	// an unmatched query fails, names the query and is still recorded
+	_, err := db.GetRecordCount("SELECT COUNT(*) FROM nowhere")
+	if err == nil || !strings.Contains(err.Error(), "SELECT COUNT(*) FROM nowhere") {
+		t.Errorf("expected an error naming the query, got %v", err)
+	}
+	if calls := fake.Calls(); len(calls) != 3 || calls[2].Query != "SELECT COUNT(*) FROM nowhere" {
+		t.Errorf("unmatched query was not recorded: %v", calls)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Rows did not panic on a short row")
+		}
+	}()
+	fake.OnCall("sp_bad").Rows([]string{"a", "b"}, []interface{}{1})
+}
+
+func TestAssertions(t *testing.T) {
+	fake := dbtest.New()
+	fake.OnCall("sp_user_update").Result(0, 1)
+	fake.OnCall("sp_user_delete").Result(0, 1)
+	fake.OnQuery(`^SELECT 1`).Value(1)
+	db := fake.DB()
+	db.UpdateRowsResult("CALL sp_user_update(?, ?)", int64(7), "ann")
+
+	// integer arguments match regardless of their type
+	r := &recorder{TB: t}
+	fake.AssertCalled(r, "sp_user_update", 7, "ann")
+	fake.AssertCalled(r, "SP_USER_UPDATE", uint8(7), "ann")
+	fake.AssertNotCalled(r, "sp_user_delete")
+	if len(r.errors) != 0 {
+		t.Errorf("unexpected failures %v", r.errors)
+	}
+
+	r = &recorder{TB: t}
+	fake.AssertCalled(r, "sp_user_update", 8, "ann")
+	fake.AssertCalled(r, "sp_user_delete", 7)
+	fake.AssertNotCalled(r, "sp_user_update")
+	fake.AssertExpectations(r)
+	expected := []string{
+		"dbtest: sp_user_update was not called with [8 ann], calls were [7 ann]",
+		"dbtest: sp_user_delete was not called",
+		"dbtest: sp_user_update was called 1 times",
+		"dbtest: expected CALL sp_user_delete was not called",
+		"dbtest: expected query ^SELECT 1 was not called",
+	}
+	if strings.Join(r.errors, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected failures\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(r.errors, "\n"))
+	}
+
+	// Reset forgets the calls but keeps the rules and what they matched
+	fake.Reset()
+	r = &recorder{TB: t}
+	fake.AssertNotCalled(r, "sp_user_update")
+	if len(fake.Calls()) != 0 || len(r.errors) != 0 {
+		t.Errorf("calls were kept after Reset: %v %v", fake.Calls(), r.errors)
+	}
+	if result, _, err := db.UpdateRowsResult("CALL sp_user_update(?, ?)", 7, "ann"); result != 0 || err != nil {
+		t.Errorf("rule was lost after Reset: %d, %v", result, err)
+	}
+}
+
+func TestTx(t *testing.T) {
+	fake := dbtest.New()
+	fake.OnCall("sp_vote").Once().Deadlock(0)
+	fake.OnCall("sp_vote").Rows([]string{"affected_count", "deadlock"}, []interface{}{1, false})
+	db := fake.DB()
+	db.TxBackoff = 1
+
+	err := db.WithTx(func(tx *database.Tx) error {
+		_, _ = tx.UpdateRowsWithDeadlock("CALL sp_vote(?)", 7)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := fake.CallsTo("sp_vote"); len(calls) != 2 {
+		t.Errorf("expected the deadlocked transaction to be retried once, got %d calls", len(calls))
+	}
+}
@@ -0,0 +1,13 @@
+package database
+
+// SetReplicas gives dbConn the pools of replicas as its read replicas and checks them once.
+// It lets the external tests route reads to dbtest fakes.
+func SetReplicas(dbConn *DBConnection, replicas ...*DBConnection) {
+	set := &replicaSet{stop: make(chan struct{})}
+	for _, r := range replicas {
+		strHost := r.Host
+		set.replicas = append(set.replicas, &replica{host: strHost, db: r.db, status: ReplicaStatus{Host: strHost}})
+	}
+	set.check(dbConn.ReplicaMaxLag)
+	dbConn.replicas = set
+}
@@ -0,0 +1,290 @@
+package database
+
+// A HealthChecker checks database connections in the background and reports the results over
+// http for Kubernetes probes. Each check pings the pool, runs ProbeQuery if it is set, and looks
+// at pool saturation. The handlers only read the last results, so a probe never waits for mysql.
+//
+//  health := database.NewHealthChecker()
+//  health.ProbeQuery = "SELECT 1 FROM dual"
+//  health.Start()
+//  defer health.Stop()
+//  http.Handle("/readyz", health.Handler())
+//  http.Handle("/livez", health.LiveHandler())
+//
+// Handler answers 503 while any connection is unhealthy, so that the pod is taken out of the
+// load balancer. LiveHandler only answers 503 if the checks themselves have stalled, since
+// restarting the pod does not bring mysql back.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// These are the health check defaults.
+const (
+	defaultHealthInterval   = 10 * time.Second
+	defaultHealthTimeout    = 5 * time.Second
+	defaultSaturationRatio  = 0.9
+	healthStaleCheckPeriods = 3 // LiveHandler fails after this many missed checks
+)
+
+// HealthStatus is the result of the last check of one connection.
+type HealthStatus struct {
+	Name        string     `json:"name"`
+	Healthy     bool       `json:"healthy"`
+	LatencyMs   float64    `json:"latency_ms"` // ping and probe query time of the last check
+	CheckedAt   time.Time  `json:"checked_at"`
+	LastError   string     `json:"last_error,omitempty"` // kept after recovery
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	InUse       int        `json:"in_use"`
+	Open        int        `json:"open"`
+	MaxOpen     int        `json:"max_open"`
+	WaitCount   int64      `json:"wait_count"`
+	Available   bool       `json:"available"` // false while the circuit breaker is open
+}
+
+// HealthChecker checks named connections every Interval.
+type HealthChecker struct {
+	Connections     map[string]*DBConnection // keyed by the name reported
+	Interval        time.Duration            // check interval, default 10s
+	Timeout         time.Duration            // per connection check timeout, default 5s
+	ProbeQuery      string                   // run after the ping, "" for ping only
+	SaturationRatio float64                  // in use share of MaxOpen that counts as unhealthy, default 0.9
+
+	mutex     sync.RWMutex
+	statuses  map[string]HealthStatus
+	lastCheck time.Time
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewHealthChecker returns a HealthChecker for AppDb and LogDb, whichever are set.
+func NewHealthChecker() *HealthChecker {
+	connections := make(map[string]*DBConnection)
+	if AppDb != nil {
+		connections["appdb"] = AppDb
+	}
+	if LogDb != nil {
+		connections["logdb"] = LogDb
+	}
+	return &HealthChecker{Connections: connections}
+}
+
+// Start runs a first check and then checks every Interval until Stop.
+func (h *HealthChecker) Start() {
+	h.Check(context.Background())
+
+	interval := h.interval()
+	h.stop = make(chan struct{})
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				h.Check(context.Background())
+			}
+		}
+	}()
+}
+
+// Stop stops the background checks.
+func (h *HealthChecker) Stop() {
+	if h.stop != nil {
+		close(h.stop)
+		h.wg.Wait()
+		h.stop = nil
+	}
+}
+
+// Check checks every connection now, concurrently, and returns the results.
+func (h *HealthChecker) Check(ctx context.Context) []HealthStatus {
+	var wg sync.WaitGroup
+	for strName, dbConn := range h.Connections {
+		wg.Add(1)
+		go func(strName string, dbConn *DBConnection) {
+			defer wg.Done()
+			h.checkOne(ctx, strName, dbConn)
+		}(strName, dbConn)
+	}
+	wg.Wait()
+
+	h.mutex.Lock()
+	h.lastCheck = time.Now()
+	h.mutex.Unlock()
+	return h.Status()
+}
+
+// checkOne checks one connection and stores the result.
+func (h *HealthChecker) checkOne(ctx context.Context, strName string, dbConn *DBConnection) {
+	ctx, cancel := context.WithTimeout(ctx, h.checkTimeout())
+	defer cancel()
+
+	status := HealthStatus{Name: strName, CheckedAt: time.Now()}
+	// the pool is read before the ping, which takes a connection itself and waits for one
+	// while the pool is saturated
+	stats := dbConn.Stats()
+	status.InUse = stats.InUse
+	status.Open = stats.OpenConnections
+	status.MaxOpen = stats.MaxOpenConnections
+	status.WaitCount = stats.WaitCount
+	status.Available = dbConn.Available()
+
+	var err error
+	startTime := time.Now()
+	if dbConn.db == nil {
+		err = fmt.Errorf("%s is not open", strName)
+	} else if h.saturated(status) {
+		err = fmt.Errorf("pool saturated, %d of %d connections in use", status.InUse, status.MaxOpen)
+	} else if err = dbConn.db.PingContext(ctx); err == nil && h.ProbeQuery != "" {
+		err = probe(ctx, dbConn, h.ProbeQuery)
+	}
+	status.LatencyMs = float64(time.Since(startTime)) / float64(time.Millisecond)
+	status.Healthy = err == nil
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	previous, ok := h.statuses[strName]
+	if err != nil {
+		status.LastError = err.Error()
+		status.LastErrorAt = &status.CheckedAt
+	} else {
+		status.LastError = previous.LastError
+		status.LastErrorAt = previous.LastErrorAt
+	}
+	switch {
+	case err != nil && (!ok || previous.Healthy):
+		utils.Warning.Println("database health check failed", strName, err.Error())
+	case err == nil && ok && !previous.Healthy:
+		utils.Info.Println("database health check passed", strName)
+	}
+	if h.statuses == nil {
+		h.statuses = make(map[string]HealthStatus)
+	}
+	h.statuses[strName] = status
+}
+
+// saturated reports whether the connections in use reach SaturationRatio of MaxOpen.
+func (h *HealthChecker) saturated(status HealthStatus) bool {
+	if status.MaxOpen <= 0 {
+		return false
+	}
+	ratio := h.SaturationRatio
+	if ratio <= 0 {
+		ratio = defaultSaturationRatio
+	}
+	return float64(status.InUse) >= ratio*float64(status.MaxOpen)
+}
+
+// probe runs the probe query and reads its result.
+func probe(ctx context.Context, dbConn *DBConnection, query string) error {
+	rows, err := dbConn.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// Status returns the results of the last check, ordered by name.
+func (h *HealthChecker) Status() []HealthStatus {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	statuses := make([]HealthStatus, 0, len(h.statuses))
+	for _, status := range h.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Ready reports whether every connection passed its last check.
+func (h *HealthChecker) Ready() bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if len(h.statuses) < len(h.Connections) {
+		return false
+	}
+	for _, status := range h.statuses {
+		if !status.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// Live reports whether the checks are still running on schedule.
+func (h *HealthChecker) Live() bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return time.Since(h.lastCheck) < healthStaleCheckPeriods*h.interval()+h.checkTimeout()
+}
+
+// interval returns Interval or its default.
+func (h *HealthChecker) interval() time.Duration {
+	if h.Interval <= 0 {
+		return defaultHealthInterval
+	}
+	return h.Interval
+}
+
+// checkTimeout returns Timeout or its default.
+func (h *HealthChecker) checkTimeout() time.Duration {
+	if h.Timeout <= 0 {
+		return defaultHealthTimeout
+	}
+	return h.Timeout
+}
+
+// healthReport is the body written by the handlers.
+type healthReport struct {
+	Status      string         `json:"status"` // ok or unavailable
+	Connections []HealthStatus `json:"connections"`
+}
+
+// Handler returns a readiness handler: 200 if every connection is healthy, otherwise 503.
+func (h *HealthChecker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, h.Ready(), h.Status())
+	})
+}
+
+// LiveHandler returns a liveness handler: 200 unless the checks have stalled.
+// The connection results are reported but do not affect the status code.
+func (h *HealthChecker) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, h.Live(), h.Status())
+	})
+}
+
+// writeHealth writes the report as JSON.
+func writeHealth(w http.ResponseWriter, bOK bool, statuses []HealthStatus) {
+	report := healthReport{Status: "ok", Connections: statuses}
+	code := http.StatusOK
+	if !bOK {
+		report.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(body)
+}
@@ -0,0 +1,139 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knousere/web-service-commons/database"
+	"github.com/knousere/web-service-commons/database/dbtest"
+)
+
+// healthReport is the body the health handlers write.
+type healthReport struct {
+	Status      string                  `json:"status"`
+	Connections []database.HealthStatus `json:"connections"`
+}
+
+// serveHealth calls handler and decodes its report.
+func serveHealth(t *testing.T, handler http.Handler) (int, healthReport) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	var report healthReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report %q: %v", recorder.Body.String(), err)
+	}
+	if recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected content type %q", recorder.Header().Get("Content-Type"))
+	}
+	return recorder.Code, report
+}
+
+func TestHealthHandlers(t *testing.T) {
+	fake := dbtest.New()
+	fake.OnQuery(`^SELECT 1 FROM dual$`).Once().Value(1)
+	fake.OnQuery(`^SELECT 1 FROM dual$`).Once().Error(sql.ErrConnDone)
+	fake.OnQuery(`^SELECT 1 FROM dual$`).Value(1)
+	health := &database.HealthChecker{
+		Connections: map[string]*database.DBConnection{"appdb": fake.DB()},
+		ProbeQuery:  "SELECT 1 FROM dual",
+	}
+
+	// not checked yet
+	if code, _ := serveHealth(t, health.Handler()); code != http.StatusServiceUnavailable {
+		t.Errorf("readiness before the first check: expected 503, got %d", code)
+	}
+	if code, _ := serveHealth(t, health.LiveHandler()); code != http.StatusServiceUnavailable {
+		t.Errorf("liveness before the first check: expected 503, got %d", code)
+	}
+
+	health.Check(context.Background())
+	code, report := serveHealth(t, health.Handler())
+	if code != http.StatusOK || report.Status != "ok" || len(report.Connections) != 1 || !report.Connections[0].Healthy {
+		t.Errorf("readiness: expected 200 ok, got %d %+v", code, report)
+	}
+	if calls := fake.Calls(); len(calls) != 1 || calls[0].Query != "SELECT 1 FROM dual" {
+		t.Errorf("expected the probe query to run once, got %v", calls)
+	}
+
+	// a failing probe takes the pod out of the load balancer but does not restart it
+	health.Check(context.Background())
+	code, report = serveHealth(t, health.Handler())
+	if code != http.StatusServiceUnavailable || report.Status != "unavailable" || report.Connections[0].Healthy {
+		t.Errorf("readiness after a failed probe: expected 503 unavailable, got %d %+v", code, report)
+	}
+	if code, _ = serveHealth(t, health.LiveHandler()); code != http.StatusOK {
+		t.Errorf("liveness after a failed probe: expected 200, got %d", code)
+	}
+
+	// recovery keeps the last error
+	health.Check(context.Background())
+	code, report = serveHealth(t, health.Handler())
+	if code != http.StatusOK || report.Connections[0].LastError != sql.ErrConnDone.Error() || report.Connections[0].LastErrorAt == nil {
+		t.Errorf("readiness after recovery: expected 200 with the last error, got %d %+v", code, report)
+	}
+}
+
+func TestHealthStale(t *testing.T) {
+	fake := dbtest.New()
+	health := &database.HealthChecker{
+		Connections: map[string]*database.DBConnection{"appdb": fake.DB()},
+		Interval:    time.Millisecond,
+		Timeout:     time.Millisecond,
+	}
+	health.Check(context.Background())
+	time.Sleep(10 * time.Millisecond)
+	if code, _ := serveHealth(t, health.LiveHandler()); code != http.StatusServiceUnavailable {
+		t.Errorf("liveness after the checks stalled: expected 503, got %d", code)
+	}
+	if code, _ := serveHealth(t, health.Handler()); code != http.StatusOK {
+		t.Errorf("readiness reports the last check: expected 200, got %d", code)
+	}
+}
+
+func TestHealthSaturated(t *testing.T) {
+	fake := dbtest.New()
+	db := fake.DB()
+	db.MaxOpen = 2
+	db.OpenDB(sql.OpenDB(fake))
+	health := &database.HealthChecker{
+		Connections: map[string]*database.DBConnection{"appdb": db},
+		Timeout:     time.Second,
+	}
+
+	var conns []*sql.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+
+	// the check reports the saturation instead of waiting out the timeout for a connection to ping
+	startTime := time.Now()
+	health.Check(context.Background())
+	if elapsed := time.Since(startTime); elapsed >= time.Second {
+		t.Errorf("check waited %v for a connection", elapsed)
+	}
+	code, report := serveHealth(t, health.Handler())
+	status := report.Connections[0]
+	if code != http.StatusServiceUnavailable || status.InUse != 2 || status.MaxOpen != 2 ||
+		!strings.HasPrefix(status.LastError, "pool saturated, 2 of 2") {
+		t.Errorf("expected 503 for a saturated pool, got %d %+v", code, status)
+	}
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	health.Check(context.Background())
+	if code, _ = serveHealth(t, health.Handler()); code != http.StatusOK {
+		t.Errorf("expected 200 once connections are free, got %d", code)
+	}
+}
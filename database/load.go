@@ -0,0 +1,278 @@
+package database
+
+// LoadRows imports rows with LOAD DATA LOCAL INFILE, which is much faster than INSERT for
+// large imports. The rows are pulled from an iterator and streamed to the server as tab
+// separated values, so they never have to be held in memory at once.
+//
+//  r := csv.NewReader(file)
+//  count, warnings, err := database.AppDb.LoadRows("user_tag", []string{"user_id", "tag"},
+//  	func() ([]interface{}, error) {
+//  		record, err := r.Read() // io.EOF at the end
+//  		if err != nil {
+//  			return nil, err
+//  		}
+//  		return []interface{}{record[0], record[1]}, nil
+//  	})
+//
+// The server must allow it with local_infile=ON. LOAD DATA does not stop on data conversion
+// problems, it reports them as warnings and carries on, so check the warnings returned.
+// mysql keeps at most max_error_count of them.
+//
+// The load runs in a transaction, so if the iterator fails nothing is loaded. Tables that do not
+// support transactions, e.g. MyISAM, keep the rows streamed before the failure.
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/knousere/web-service-commons/go-sql-driver/mysql"
+)
+
+// RowIterator returns the next row on every call, and io.EOF after the last one.
+type RowIterator func() ([]interface{}, error)
+
+// SliceRows iterates over rows held in memory.
+func SliceRows(rows [][]interface{}) RowIterator {
+	i := 0
+	return func() ([]interface{}, error) {
+		if i >= len(rows) {
+			return nil, io.EOF
+		}
+		i++
+		return rows[i-1], nil
+	}
+}
+
+// loadHandlerCount makes the reader handler name of every LoadRows call unique.
+var loadHandlerCount atomic.Uint64
+
+// LoadRows streams the rows of next into strTable and returns the number of rows loaded
+// along with the warnings reported by mysql. On error nothing is loaded and the count is 0.
+func (dbConn *DBConnection) LoadRows(strTable string, columns []string, next RowIterator) (int, []mysql.MySQLWarning, error) {
+	return dbConn.LoadRowsContext(context.Background(), strTable, columns, next)
+}
+
+// LoadRowsContext is LoadRows bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) LoadRowsContext(ctx context.Context, strTable string, columns []string, next RowIterator) (int, []mysql.MySQLWarning, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+
+	strHandler := fmt.Sprintf("database.LoadRows.%d", loadHandlerCount.Add(1))
+	query, err := loadStatement(strHandler, strTable, columns)
+	if err != nil {
+		return 0, nil, err
+	}
+	reader := &tsvReader{next: next, columnCount: len(columns)}
+	mysql.RegisterReaderHandler(strHandler, func() io.Reader { return reader })
+	defer mysql.DeregisterReaderHandler(strHandler)
+
+	count, warnings, err := loadRows(ctx, dbConn, query, reader)
+	dbConn.record("LoadRows", startTime, err, query)
+	dbConn.markWrite()
+	return count, warnings, err
+}
+
+// loadRows is the shared implementation of LoadRows.
+// When the reader fails the driver still ends the stream normally and the server keeps the rows
+// sent so far, so the load runs in a transaction that is rolled back on any error.
+// The warnings are session state, so they are read inside the transaction before the commit.
+func loadRows(ctx context.Context, dbConn *DBConnection, query string, reader *tsvReader) (int, []mysql.MySQLWarning, error) {
+	strArgs := doTrace(ctx, query)
+	tx, err := dbConn.db.BeginTx(ctx, nil)
+	if err != nil {
+		warnError(err, strArgs, query)
+		return 0, nil, err
+	}
+	defer tx.Rollback() // no effect after Commit
+
+	result, err := tx.ExecContext(ctx, query)
+	if reader.err != nil {
+		// the iterator or the encoding failed, not the server
+		err = reader.err
+	}
+	if err != nil {
+		warnError(err, strArgs, query)
+		return 0, nil, err
+	}
+	affectedCount, _ := result.RowsAffected()
+
+	warnings, err := loadWarnings(ctx, tx)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		warnError(err, strArgs, query)
+		return 0, nil, err
+	}
+	return int(affectedCount), warnings, nil
+}
+
+// loadWarnings reads the warnings of the load just run in tx.
+func loadWarnings(ctx context.Context, tx *sql.Tx) ([]mysql.MySQLWarning, error) {
+	rows, err := tx.QueryContext(ctx, "SHOW WARNINGS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var warnings []mysql.MySQLWarning
+	for rows.Next() {
+		var warning mysql.MySQLWarning
+		if err = rows.Scan(&warning.Level, &warning.Code, &warning.Message); err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings, rows.Err()
+}
+
+// loadStatement builds the LOAD DATA statement for the reader handler strHandler.
+// The format clauses are spelled out so that server defaults cannot change them.
+func loadStatement(strHandler, strTable string, columns []string) (string, error) {
+	if len(columns) == 0 {
+		return "", errors.New("load needs at least one column")
+	}
+	table, err := quoteIdent(strTable)
+	if err != nil {
+		return "", err
+	}
+	var columnList bytes.Buffer
+	for i, column := range columns {
+		quoted, err := quoteIdent(column)
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			columnList.WriteByte(',')
+		}
+		columnList.WriteString(quoted)
+	}
+	return "LOAD DATA LOCAL INFILE 'Reader::" + strHandler + "' INTO TABLE " + table +
+		` CHARACTER SET utf8mb4 FIELDS TERMINATED BY '\t' ESCAPED BY '\\' LINES TERMINATED BY '\n' (` +
+		columnList.String() + ")", nil
+}
+
+// tsvReader encodes the rows of an iterator as LOAD DATA input.
+type tsvReader struct {
+	next        RowIterator
+	columnCount int
+	buf         bytes.Buffer
+	rowCount    int
+	bDone       bool
+	err         error // iterator or encoding error, reported instead of the server's
+}
+
+// tsvBufferSize is how much encoded input tsvReader keeps ahead of the driver.
+const tsvBufferSize = 64 << 10
+
+// Read implements io.Reader. Once it has failed it keeps returning the error.
+func (r *tsvReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	for !r.bDone && r.buf.Len() < len(p) && r.buf.Len() < tsvBufferSize {
+		row, err := r.next()
+		if err == io.EOF {
+			r.bDone = true
+			break
+		}
+		if err == nil {
+			err = r.encode(row)
+		}
+		if err != nil {
+			r.bDone = true
+			r.err = err
+			return 0, err
+		}
+		r.rowCount++
+	}
+	if r.buf.Len() == 0 && r.bDone {
+		return 0, io.EOF
+	}
+	return r.buf.Read(p)
+}
+
+// encode appends one row to the buffer.
+func (r *tsvReader) encode(row []interface{}) error {
+	if len(row) != r.columnCount {
+		return fmt.Errorf("load row %d has %d values for %d columns", r.rowCount, len(row), r.columnCount)
+	}
+	for i, v := range row {
+		if i > 0 {
+			r.buf.WriteByte('\t')
+		}
+		if err := writeTSVValue(&r.buf, v); err != nil {
+			return fmt.Errorf("load row %d column %d: %s", r.rowCount, i, err.Error())
+		}
+	}
+	r.buf.WriteByte('\n')
+	return nil
+}
+
+// writeTSVValue writes one value escaped for the ESCAPED BY '\\' format. NULL is \N.
+func writeTSVValue(buf *bytes.Buffer, v interface{}) error {
+	if valuer, ok := v.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil {
+			return err
+		}
+		v = value
+	}
+	switch value := v.(type) {
+	case nil:
+		buf.WriteString(`\N`)
+	case string:
+		writeTSVEscaped(buf, value)
+	case []byte:
+		writeTSVEscaped(buf, string(value))
+	case bool:
+		if value {
+			buf.WriteByte('1')
+		} else {
+			buf.WriteByte('0')
+		}
+	case int:
+		buf.WriteString(strconv.Itoa(value))
+	case int8, int16, int32, int64:
+		buf.WriteString(fmt.Sprintf("%d", value))
+	case uint, uint8, uint16, uint32, uint64:
+		buf.WriteString(fmt.Sprintf("%d", value))
+	case float32:
+		buf.WriteString(strconv.FormatFloat(float64(value), 'g', -1, 32))
+	case float64:
+		buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	case time.Time:
+		buf.WriteString(value.UTC().Format("2006-01-02 15:04:05.999999"))
+	default:
+		return fmt.Errorf("unsupported type %T", v)
+	}
+	return nil
+}
+
+// writeTSVEscaped escapes the characters that LOAD DATA would otherwise interpret.
+func writeTSVEscaped(buf *bytes.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case 0:
+			buf.WriteString(`\0`)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadDriver stands in for the mysql driver in LoadRows tests. Exec reads the whole reader the
+// way the driver streams LOAD DATA input, and the transaction outcome is recorded.
+type loadDriver struct {
+	reader    io.Reader
+	loaded    []byte
+	committed bool
+	rolled    bool
+	commitErr error
+}
+
+func (d *loadDriver) Connect(ctx context.Context) (driver.Conn, error) { return d, nil }
+func (d *loadDriver) Driver() driver.Driver                            { return nil }
+func (d *loadDriver) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("loadDriver: prepare not supported")
+}
+func (d *loadDriver) Close() error              { return nil }
+func (d *loadDriver) Begin() (driver.Tx, error) { return d, nil }
+func (d *loadDriver) Commit() error             { d.committed = d.commitErr == nil; return d.commitErr }
+func (d *loadDriver) Rollback() error           { d.rolled = true; return nil }
+
+func (d *loadDriver) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	// small reads, so that rows are sent before a later one fails
+	p := make([]byte, 4)
+	for {
+		n, err := d.reader.Read(p)
+		d.loaded = append(d.loaded, p[:n]...)
+		if err == io.EOF {
+			return driver.RowsAffected(len(d.loaded)), nil
+		}
+		if err != nil {
+			// the driver ends the stream and returns the read error, but the server keeps the rows
+			return nil, err
+		}
+	}
+}
+
+func (d *loadDriver) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return noRows{}, nil
+}
+
+// noRows answers SHOW WARNINGS.
+type noRows struct{}
+
+func (noRows) Columns() []string              { return []string{"Level", "Code", "Message"} }
+func (noRows) Close() error                   { return nil }
+func (noRows) Next(dest []driver.Value) error { return io.EOF }
+
+func TestLoadRowsRollback(t *testing.T) {
+	errRead := errors.New("bad input")
+	rows := [][]interface{}{{1, "a"}, {2, "b"}}
+
+	tests := []struct {
+		name      string
+		next      RowIterator
+		err       error
+		committed bool
+	}{
+		{"complete", SliceRows(rows), nil, true},
+		{"iterator fails", failAfter(rows, errRead), errRead, false},
+		{"wrong column count", SliceRows([][]interface{}{{1, "a"}, {2}}), nil, false},
+	}
+	for _, test := range tests {
+		drv := &loadDriver{}
+		dbConn := &DBConnection{}
+		dbConn.OpenDB(sql.OpenDB(drv))
+		reader := &tsvReader{next: test.next, columnCount: 2}
+		drv.reader = reader
+
+		count, _, err := loadRows(context.Background(), dbConn, "LOAD DATA", reader)
+		dbConn.db.Close()
+		if test.committed {
+			if err != nil || count == 0 || !drv.committed {
+				t.Errorf("%s: expected a committed load, got count %d, %v", test.name, count, err)
+			}
+			continue
+		}
+		if err == nil || (test.err != nil && err != test.err) {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+		}
+		if count != 0 || drv.committed || !drv.rolled {
+			t.Errorf("%s: partial load was kept: count %d, committed %v, rolled back %v",
+				test.name, count, drv.committed, drv.rolled)
+		}
+		if len(drv.loaded) == 0 {
+			t.Errorf("%s: no rows were streamed before the failure", test.name)
+		}
+	}
+}
+
+// failAfter returns the rows and then err.
+func failAfter(rows [][]interface{}, err error) RowIterator {
+	next := SliceRows(rows)
+	return func() ([]interface{}, error) {
+		row, rowErr := next()
+		if rowErr == io.EOF {
+			return nil, err
+		}
+		return row, rowErr
+	}
+}
+
+func TestWriteTSVEscaped(t *testing.T) {
+	tests := []struct {
+		value   string
+		escaped string
+	}{
+		{"plain", "plain"},
+		{"", ""},
+		{`back\slash`, `back\\slash`},
+		{"tab\there", `tab\there`},
+		{"new\nline", `new\nline`},
+		{"carriage\rreturn", `carriage\rreturn`},
+		{"nul\x00byte", `nul\0byte`},
+		{`\N`, `\\N`},
+		{"\\\t\n\x00", `\\\t\n\0`},
+		{"naïve 日本", "naïve 日本"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		writeTSVEscaped(&buf, test.value)
+		if buf.String() != test.escaped {
+			t.Errorf("writeTSVEscaped(%q) = %q, expected %q", test.value, buf.String(), test.escaped)
+		}
+	}
+}
+
+func TestWriteTSVValue(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		encoded string
+	}{
+		{nil, `\N`},
+		{"a\tb", `a\tb`},
+		{[]byte("a\nb"), `a\nb`},
+		{true, "1"},
+		{false, "0"},
+		{-42, "-42"},
+		{int8(-8), "-8"},
+		{int64(1) << 40, "1099511627776"},
+		{uint8(255), "255"},
+		{uint64(1) << 63, "9223372036854775808"},
+		{float32(1.5), "1.5"},
+		{0.1, "0.1"},
+		{time.Date(2024, 2, 29, 13, 4, 5, 123000000, time.FixedZone("", 3600)), "2024-02-29 12:04:05.123"},
+		{sql.NullString{}, `\N`},
+		{sql.NullString{String: "x\\y", Valid: true}, `x\\y`},
+		{sql.NullInt64{Int64: 7, Valid: true}, "7"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := writeTSVValue(&buf, test.value); err != nil || buf.String() != test.encoded {
+			t.Errorf("writeTSVValue(%#v) = %q, %v, expected %q", test.value, buf.String(), err, test.encoded)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeTSVValue(&buf, struct{}{}); err == nil {
+		t.Error("unsupported type was encoded")
+	}
+}
+
+func TestTSVReader(t *testing.T) {
+	rows := [][]interface{}{
+		{1, "one", nil},
+		{2, "two\tcolumns", 2.5},
+		{3, "line\nbreak", false},
+	}
+	expected := "1\tone\t\\N\n2\ttwo\\tcolumns\t2.5\n3\tline\\nbreak\t0\n"
+
+	// the driver reads in packet sized pieces, so any read size must give the same stream
+	for _, size := range []int{1, 3, 16, 4096} {
+		reader := &tsvReader{next: SliceRows(rows), columnCount: 3}
+		var out bytes.Buffer
+		p := make([]byte, size)
+		for {
+			n, err := reader.Read(p)
+			out.Write(p[:n])
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("read size %d: %s", size, err.Error())
+			}
+		}
+		if out.String() != expected {
+			t.Errorf("read size %d: expected %q, got %q", size, expected, out.String())
+		}
+		if reader.rowCount != len(rows) || reader.err != nil {
+			t.Errorf("read size %d: rowCount %d, err %v", size, reader.rowCount, reader.err)
+		}
+	}
+
+	// an encoding error stops the reader and is kept for LoadRows to report
+	reader := &tsvReader{next: SliceRows([][]interface{}{{1, "a"}, {2, struct{}{}}}), columnCount: 2}
+	if _, err := ioutil.ReadAll(reader); err == nil || reader.err != err {
+		t.Errorf("expected the encoding error to be kept, got %v and %v", err, reader.err)
+	} else if !strings.Contains(err.Error(), "load row 1 column 1") {
+		t.Errorf("error does not name the row and column: %s", err.Error())
+	}
+	if n, err := reader.Read(make([]byte, 16)); n != 0 || err != reader.err {
+		t.Errorf("reader continued after an error: %d, %v", n, err)
+	}
+}
+
+func TestLoadStatement(t *testing.T) {
+	query, err := loadStatement("database.LoadRows.1", "app.user_tag", []string{"user_id", "tag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "LOAD DATA LOCAL INFILE 'Reader::database.LoadRows.1' INTO TABLE `app`.`user_tag`" +
+		` CHARACTER SET utf8mb4 FIELDS TERMINATED BY '\t' ESCAPED BY '\\' LINES TERMINATED BY '\n' (` +
+		"`user_id`,`tag`)"
+	if query != expected {
+		t.Errorf("expected %q, got %q", expected, query)
+	}
+
+	for _, columns := range [][]string{nil, {"tag`"}} {
+		if _, err := loadStatement("h", "user_tag", columns); err == nil {
+			t.Errorf("columns %q: expected an error", columns)
+		}
+	}
+	if _, err := loadStatement("h", "user_tag'", []string{"tag"}); err != nil {
+		t.Errorf("quote in a table name was rejected: %v", err)
+	}
+}
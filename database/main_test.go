@@ -0,0 +1,14 @@
+package database
+
+import (
+	"os"
+	"testing"
+
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// TestMain quiets the logs the wrappers write to.
+func TestMain(m *testing.M) {
+	utils.InitLog(utils.LogNil, utils.LogNil, utils.LogNil, utils.LogNil)
+	os.Exit(m.Run())
+}
@@ -0,0 +1,196 @@
+package database
+
+// Every wrapper records its latency. Calls slower than DBConnection.SlowQuery are written
+// to the Warning stream with their argument dump and the file and line of the caller,
+// whether or not trace is on.
+// Arguments wrapped in Sensitive are passed to the server unchanged but logged as [REDACTED].
+//
+//  database.AppDb.SlowQuery = 250 * time.Millisecond
+//  id, err := database.AppDb.InsertRow("CALL sp_user_insert(?,?)", strEmail, database.Sensitive(strHash))
+//
+// Latencies are also kept in a histogram per query fingerprint, i.e. the query text with
+// literals replaced by ? and white space collapsed. QueryHistograms exports them.
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// QueryBuckets are the upper bounds of the histogram buckets.
+// A final bucket counts the calls slower than the last bound.
+var QueryBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// maxFingerprints bounds the number of histograms kept per DBConnection.
+// Further fingerprints are folded into otherFingerprint.
+const (
+	maxFingerprints  = 1000
+	otherFingerprint = "(other)"
+)
+
+// SensitiveArg is a query argument that is never written to the log.
+type SensitiveArg struct {
+	value interface{}
+}
+
+// Sensitive marks a query argument, e.g. a password hash or a token, as sensitive.
+func Sensitive(value interface{}) SensitiveArg {
+	return SensitiveArg{value: value}
+}
+
+// Value implements driver.Valuer so the wrapped value reaches the server unchanged.
+func (s SensitiveArg) Value() (driver.Value, error) {
+	return driver.DefaultParameterConverter.ConvertValue(s.value)
+}
+
+// String hides the wrapped value from fmt.
+func (s SensitiveArg) String() string {
+	return "[REDACTED]"
+}
+
+// QueryHistogram is a snapshot of the latency histogram of one query fingerprint.
+type QueryHistogram struct {
+	Fingerprint string
+	Count       int64
+	Errors      int64
+	Duration    time.Duration // total latency
+	Max         time.Duration
+	Buckets     []int64 // call counts per QueryBuckets bound plus the overflow bucket
+}
+
+// histogram is the live version of QueryHistogram.
+type histogram struct {
+	mutex sync.Mutex
+	QueryHistogram
+}
+
+// record records one call of the named wrapper that started at startTime.
+func (dbConn *DBConnection) record(name string, startTime time.Time, err error, query string, args ...interface{}) {
+	elapsed := time.Since(startTime)
+	dbConn.count(name, elapsed, err)
+	dbConn.observe(elapsed, err != nil, query, args...)
+	dbConn.observeConn(err)
+}
+
+// recordDeadlock records one call of a wrapper that reports deadlock as a flag.
+// err is the error the wrapper does not return, it is observed the way record observes it.
+func (dbConn *DBConnection) recordDeadlock(name string, startTime time.Time, bDeadlock bool, err error, query string, args ...interface{}) {
+	elapsed := time.Since(startTime)
+	dbConn.countDeadlock(name, elapsed, bDeadlock)
+	dbConn.observe(elapsed, err != nil, query, args...)
+	dbConn.observeConn(err)
+}
+
+// observe adds a call to the histogram of its fingerprint and logs it if it is slow.
+func (dbConn *DBConnection) observe(elapsed time.Duration, bError bool, query string, args ...interface{}) {
+	if dbConn.SlowQuery > 0 && elapsed >= dbConn.SlowQuery {
+		utils.Warning.Output(callerDepth(), fmt.Sprintln("slow query", elapsed, argString(query, args...)))
+	}
+
+	h := dbConn.histogram(Fingerprint(query))
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.Count++
+	if bError {
+		h.Errors++
+	}
+	h.Duration += elapsed
+	if elapsed > h.Max {
+		h.Max = elapsed
+	}
+	i := sort.Search(len(QueryBuckets), func(i int) bool { return elapsed <= QueryBuckets[i] })
+	h.Buckets[i]++
+}
+
+// packagePrefix begins the function names of this package in a stack trace.
+var packagePrefix = reflect.TypeOf((*DBConnection)(nil)).Elem().PkgPath() + "."
+
+// callerDepth returns the log call depth of the first caller outside this package, as seen from
+// the function calling callerDepth. The wrappers call each other, so the depth is not fixed.
+func callerDepth() int {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for depth := 1; ; depth++ {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePrefix) || !more {
+			return depth
+		}
+	}
+}
+
+// histogram returns the live histogram of fingerprint.
+func (dbConn *DBConnection) histogram(fingerprint string) *histogram {
+	if h, ok := dbConn.histograms.Load(fingerprint); ok {
+		return h.(*histogram)
+	}
+	if dbConn.fingerprintCount.Load() >= maxFingerprints {
+		fingerprint = otherFingerprint
+	}
+	h := &histogram{}
+	h.Fingerprint = fingerprint
+	h.Buckets = make([]int64, len(QueryBuckets)+1)
+	actual, loaded := dbConn.histograms.LoadOrStore(fingerprint, h)
+	if !loaded {
+		dbConn.fingerprintCount.Add(1)
+	}
+	return actual.(*histogram)
+}
+
+// QueryHistograms returns the latency histogram of every query fingerprint,
+// ordered by total latency so that the most expensive queries come first.
+func (dbConn *DBConnection) QueryHistograms() []QueryHistogram {
+	histograms := make([]QueryHistogram, 0, 50)
+	dbConn.histograms.Range(func(key, value interface{}) bool {
+		h := value.(*histogram)
+		h.mutex.Lock()
+		snapshot := h.QueryHistogram
+		snapshot.Buckets = append([]int64(nil), h.Buckets...)
+		h.mutex.Unlock()
+		histograms = append(histograms, snapshot)
+		return true
+	})
+	sort.Slice(histograms, func(i, j int) bool {
+		return histograms[i].Duration > histograms[j].Duration
+	})
+	return histograms
+}
+
+// These patterns reduce a query to its fingerprint.
+var (
+	reQuoted   = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.|"")*"`)
+	reNumber   = regexp.MustCompile(`\b-?\d+(?:\.\d+)?\b`)
+	reSpace    = regexp.MustCompile(`\s+`)
+	reArgList  = regexp.MustCompile(`\?(?:\s*,\s*\?)+`)
+	reCallName = regexp.MustCompile(`(?i)^call\s+`)
+)
+
+// Fingerprint reduces query to a form that is shared by every call of the same statement:
+// literals become ?, lists of ? become ?+ and white space is collapsed.
+func Fingerprint(query string) string {
+	fingerprint := reQuoted.ReplaceAllString(query, "?")
+	fingerprint = reNumber.ReplaceAllString(fingerprint, "?")
+	fingerprint = strings.TrimSpace(reSpace.ReplaceAllString(fingerprint, " "))
+	fingerprint = reArgList.ReplaceAllString(fingerprint, "?+")
+	return reCallName.ReplaceAllString(fingerprint, "CALL ")
+}
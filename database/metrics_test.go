@@ -0,0 +1,98 @@
+package database
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knousere/web-service-commons/utils"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		query       string
+		fingerprint string
+	}{
+		{"CALL sp_user_get(?)", "CALL sp_user_get(?)"},
+		{"call  sp_user_update(?, ?,?)\n", "CALL sp_user_update(?+)"},
+		{"CALL sp_user_get(7, 'ann')", "CALL sp_user_get(?+)"},
+		{"SELECT * FROM user WHERE id = 42 AND name = \"o\\\"x\"", "SELECT * FROM user WHERE id = ? AND name = ?"},
+		{"SELECT 'it''s', 3.5", "SELECT ?+"},
+		{"SELECT t1.id FROM team2 t1\n\tWHERE t1.score > 10", "SELECT t1.id FROM team2 t1 WHERE t1.score > ?"},
+	}
+	for _, test := range tests {
+		if fingerprint := Fingerprint(test.query); fingerprint != test.fingerprint {
+			t.Errorf("Fingerprint(%q) expected %q, got %q", test.query, test.fingerprint, fingerprint)
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	dbConn := &DBConnection{}
+	ms := time.Millisecond
+	for _, elapsed := range []time.Duration{ms / 2, ms, 3 * ms / 2, 5 * ms, 20 * time.Second} {
+		dbConn.observe(elapsed, false, "CALL sp_item_get(?)", 7)
+	}
+	dbConn.observe(2*ms, true, "call sp_item_get(8)")
+
+	histograms := dbConn.QueryHistograms()
+	if len(histograms) != 1 {
+		t.Fatalf("expected one fingerprint, got %v", histograms)
+	}
+	h := histograms[0]
+	expected := make([]int64, len(QueryBuckets)+1)
+	expected[0] = 2                 // up to 1ms, the bound is inclusive
+	expected[1] = 3                 // up to 5ms
+	expected[len(QueryBuckets)] = 1 // beyond the last bound
+	if h.Fingerprint != "CALL sp_item_get(?)" || h.Count != 6 || h.Errors != 1 || h.Max != 20*time.Second {
+		t.Errorf("unexpected histogram %+v", h)
+	}
+	for i := range expected {
+		if h.Buckets[i] != expected[i] {
+			t.Errorf("expected buckets %v, got %v", expected, h.Buckets)
+			break
+		}
+	}
+}
+
+func TestSlowQuery(t *testing.T) {
+	var buffer bytes.Buffer
+	defer func(warning *log.Logger) { utils.Warning = warning }(utils.Warning)
+	utils.Warning = log.New(&buffer, "", 0)
+
+	dbConn := &DBConnection{SlowQuery: 10 * time.Millisecond}
+	tests := []struct {
+		elapsed time.Duration
+		logged  bool
+	}{
+		{9 * time.Millisecond, false},
+		{10 * time.Millisecond, true},
+		{time.Second, true},
+	}
+	for _, test := range tests {
+		buffer.Reset()
+		dbConn.observe(test.elapsed, false, "CALL sp_user_login(?, ?)", "ann", Sensitive("s3cret"))
+		line := buffer.String()
+		if !test.logged {
+			if line != "" {
+				t.Errorf("%v: logged below the threshold: %q", test.elapsed, line)
+			}
+			continue
+		}
+		if expected := "slow query " + test.elapsed.String() + " CALL sp_user_login(?, ?), ann, [REDACTED]\n"; line != expected {
+			t.Errorf("%v: expected %q, got %q", test.elapsed, expected, line)
+		}
+		if strings.Contains(line, "s3cret") {
+			t.Errorf("%v: sensitive argument logged: %q", test.elapsed, line)
+		}
+	}
+
+	// no threshold, no log
+	buffer.Reset()
+	(&DBConnection{}).observe(time.Hour, false, "CALL sp_user_login(?, ?)")
+	if buffer.Len() != 0 {
+		t.Errorf("logged without a threshold: %q", buffer.String())
+	}
+}
@@ -0,0 +1,424 @@
+// Package migrate applies versioned SQL files to a DBConnection.
+// Every migration is a pair of files in the source directory:
+//
+//	0001_create_user.up.sql     applied by Up
+//	0001_create_user.down.sql   applied by Down, optional
+//
+// Versions are applied in numeric order and recorded with the checksum of their up file
+// in a bookkeeping table. Up and Down refuse to run if an applied up file has changed since.
+// Both take a named lock with GET_LOCK first, so when several instances of a service start
+// at once one of them migrates and the others wait for it and then find nothing to do.
+//
+//	m := migrate.New(database.AppDb, os.DirFS("migrations"))
+//	intApplied, err := m.Up(ctx)
+//
+// mysql commits DDL implicitly, so a migration is not atomic. If a statement fails the
+// migration is not recorded and the statements before the failure must be undone by hand.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knousere/web-service-commons/database"
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// These are the Migrator defaults.
+const (
+	defaultTable       = "schema_migrations"
+	defaultLockTimeout = 60 * time.Second
+)
+
+// These errors are returned by Up and Down before anything is run.
+var (
+	ErrChanged = errors.New("applied migration has changed")
+	ErrMissing = errors.New("applied migration is missing from the source")
+	ErrNoDown  = errors.New("migration has no down file")
+	ErrLocked  = errors.New("migration lock is held by another session")
+)
+
+var (
+	reFile  = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+	reIdent = regexp.MustCompile(`^[A-Za-z0-9_$]+$`)
+)
+
+// Migration is one version read from the source.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string // empty if there is no down file
+	Checksum string // hex sha256 of Up
+}
+
+// Status is the state of one version.
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Changed   bool // applied with a different checksum
+	Missing   bool // applied but no longer in the source
+}
+
+// Migrator applies the migrations in Source to Db.
+type Migrator struct {
+	Db          *database.DBConnection
+	Source      fs.FS
+	Table       string        // bookkeeping table, default schema_migrations
+	LockName    string        // GET_LOCK name, default <Schema>.<Table>
+	LockTimeout time.Duration // wait for the lock, default 60s
+	DryRun      bool          // write the statements to Out instead of running them
+	Out         io.Writer     // dry run output, default os.Stdout
+}
+
+// applied is one row of the bookkeeping table.
+type applied struct {
+	version   int64
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// New returns a Migrator with the default settings.
+func New(dbConn *database.DBConnection, source fs.FS) *Migrator {
+	return &Migrator{Db: dbConn, Source: source}
+}
+
+// Load reads the migrations in the top directory of source ordered by version.
+// Files that are not named like a migration are ignored.
+func Load(source fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(source, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := reFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", entry.Name(), err.Error())
+		}
+		content, err := fs.ReadFile(source, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("version %d is used by %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("version %d %s has no up file", migration.Version, migration.Name)
+		}
+		migration.Checksum = checksum(migration.Up)
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// checksum ignores line endings so a checkout with CRLF does not count as a change.
+func checksum(script string) string {
+	sum := sha256.Sum256([]byte(strings.ReplaceAll(script, "\r\n", "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Status returns the state of every version in the source or in the bookkeeping table.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	migrations, err := Load(m.Source)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := m.Db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	done, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(migrations))
+	for _, migration := range migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if row, ok := done[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = row.appliedAt
+			status.Changed = row.checksum != migration.Checksum
+			delete(done, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, row := range done {
+		statuses = append(statuses, Status{
+			Version:   row.version,
+			Name:      row.name,
+			Applied:   true,
+			AppliedAt: row.appliedAt,
+			Missing:   true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Up applies every pending migration in version order and returns how many it applied.
+// A pending version below an applied one is applied as well.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	migrations, conn, done, release, err := m.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	intApplied := 0
+	for _, migration := range migrations {
+		if _, ok := done[migration.Version]; ok {
+			continue
+		}
+		if err = m.run(ctx, conn, migration, "up", migration.Up); err != nil {
+			return intApplied, err
+		}
+		if !m.DryRun {
+			_, err = conn.ExecContext(ctx, "INSERT INTO "+m.table()+" (version, name, checksum) VALUES (?,?,?)",
+				migration.Version, migration.Name, migration.Checksum)
+			if err != nil {
+				return intApplied, err
+			}
+		}
+		intApplied++
+	}
+	return intApplied, nil
+}
+
+// Down reverts the intSteps most recently applied versions and returns how many it reverted.
+func (m *Migrator) Down(ctx context.Context, intSteps int) (int, error) {
+	if intSteps <= 0 {
+		return 0, nil
+	}
+	migrations, conn, done, release, err := m.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	bySource := make(map[int64]Migration, len(migrations))
+	for _, migration := range migrations {
+		bySource[migration.Version] = migration
+	}
+	versions := make([]int64, 0, len(done))
+	for version := range done {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	if intSteps < len(versions) {
+		versions = versions[:intSteps]
+	}
+
+	// check every step before running any of them
+	for _, version := range versions {
+		migration, ok := bySource[version]
+		if !ok {
+			return 0, fmt.Errorf("%w: version %d %s", ErrMissing, version, done[version].name)
+		}
+		if migration.Down == "" {
+			return 0, fmt.Errorf("%w: version %d %s", ErrNoDown, version, migration.Name)
+		}
+	}
+
+	intReverted := 0
+	for _, version := range versions {
+		migration := bySource[version]
+		if err = m.run(ctx, conn, migration, "down", migration.Down); err != nil {
+			return intReverted, err
+		}
+		if !m.DryRun {
+			if _, err = conn.ExecContext(ctx, "DELETE FROM "+m.table()+" WHERE version = ?", version); err != nil {
+				return intReverted, err
+			}
+		}
+		intReverted++
+	}
+	return intReverted, nil
+}
+
+// begin reserves a connection, takes the lock, makes sure the bookkeeping table exists
+// and checks that no applied migration has changed. A dry run neither locks nor creates the table.
+func (m *Migrator) begin(ctx context.Context) ([]Migration, *sql.Conn, map[int64]applied, func(), error) {
+	migrations, err := Load(m.Source)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if !reIdent.MatchString(m.tableName()) {
+		return nil, nil, nil, nil, fmt.Errorf("invalid bookkeeping table name %q", m.tableName())
+	}
+	conn, err := m.Db.Conn(ctx)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	release := func() { conn.Close() }
+
+	if !m.DryRun {
+		if err = m.lock(ctx, conn); err != nil {
+			release()
+			return nil, nil, nil, nil, err
+		}
+		release = func() {
+			// the lock belongs to the session, release it before the connection goes back to the pool
+			if _, err := conn.ExecContext(context.Background(), "DO RELEASE_LOCK(?)", m.lockName()); err != nil {
+				utils.Warning.Println("migrate RELEASE_LOCK failed", err.Error())
+			}
+			conn.Close()
+		}
+		_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+m.table()+` (
+			version BIGINT NOT NULL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+		if err != nil {
+			release()
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	done, err := m.applied(ctx, conn)
+	if err != nil {
+		release()
+		return nil, nil, nil, nil, err
+	}
+	var changed []string
+	for _, migration := range migrations {
+		if row, ok := done[migration.Version]; ok && row.checksum != migration.Checksum {
+			changed = append(changed, fmt.Sprintf("%d %s", migration.Version, migration.Name))
+		}
+	}
+	if len(changed) > 0 {
+		release()
+		return nil, nil, nil, nil, fmt.Errorf("%w: %s", ErrChanged, strings.Join(changed, ", "))
+	}
+	return migrations, conn, done, release, nil
+}
+
+// lock waits up to LockTimeout for the migration lock.
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) error {
+	timeout := m.LockTimeout
+	if timeout <= 0 {
+		timeout = defaultLockTimeout
+	}
+	var result sql.NullInt64
+	err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", m.lockName(), int(timeout/time.Second)).Scan(&result)
+	if err != nil {
+		return err
+	}
+	if !result.Valid {
+		return errors.New("GET_LOCK failed")
+	}
+	if result.Int64 != 1 {
+		return fmt.Errorf("%w: %s", ErrLocked, m.lockName())
+	}
+	return nil
+}
+
+// applied reads the bookkeeping table. A missing table means nothing has been applied.
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]applied, error) {
+	done := make(map[int64]applied)
+	var count int
+	err := conn.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?",
+		m.tableName()).Scan(&count)
+	if err != nil || count == 0 {
+		return done, err
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM "+m.table())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row applied
+		if err = rows.Scan(&row.version, &row.name, &row.checksum, &row.appliedAt); err != nil {
+			return nil, err
+		}
+		done[row.version] = row
+	}
+	return done, rows.Err()
+}
+
+// run runs the statements of one direction of a migration, or writes them to Out on a dry run.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, strDirection, script string) error {
+	statements, err := Split(script)
+	if err != nil {
+		return fmt.Errorf("version %d %s %s: %s", migration.Version, migration.Name, strDirection, err.Error())
+	}
+
+	if m.DryRun {
+		out := m.Out
+		if out == nil {
+			out = os.Stdout
+		}
+		fmt.Fprintf(out, "-- %d %s %s\n", migration.Version, migration.Name, strDirection)
+		for _, stmt := range statements {
+			fmt.Fprintf(out, "%s;\n\n", stmt)
+		}
+		return nil
+	}
+
+	startTime := time.Now()
+	for i, stmt := range statements {
+		if _, err = conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("version %d %s %s statement %d: %w", migration.Version, migration.Name, strDirection, i+1, err)
+		}
+	}
+	utils.Info.Println("migrate", strDirection, migration.Version, migration.Name, time.Since(startTime))
+	return nil
+}
+
+func (m *Migrator) tableName() string {
+	if m.Table == "" {
+		return defaultTable
+	}
+	return m.Table
+}
+
+func (m *Migrator) table() string {
+	return utils.BQ(m.tableName())
+}
+
+func (m *Migrator) lockName() string {
+	if m.LockName != "" {
+		return m.LockName
+	}
+	return m.Db.Schema + "." + m.tableName()
+}
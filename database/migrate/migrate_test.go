@@ -0,0 +1,286 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/knousere/web-service-commons/database/dbtest"
+)
+
+var testSource = fstest.MapFS{
+	"0001_create_user.up.sql":   {Data: []byte("CREATE TABLE user (id INT);\n")},
+	"0001_create_user.down.sql": {Data: []byte("DROP TABLE user;\n")},
+	"0002_create_team.up.sql":   {Data: []byte("CREATE TABLE team (id INT);\nCREATE TABLE team_user (team_id INT, user_id INT);\n")},
+	"0002_create_team.down.sql": {Data: []byte("DROP TABLE team_user;\nDROP TABLE team;\n")},
+	"0003_add_email.up.sql":     {Data: []byte("ALTER TABLE user ADD email VARCHAR(255);\n")},
+	"README.md":                 {Data: []byte("not a migration")},
+}
+
+var appliedAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// newFake returns a fake whose bookkeeping table holds the given versions, each with the
+// checksum of its up file unless it is listed in changed. A nil done means there is no table.
+func newFake(done []int64, changed ...int64) *dbtest.Fake {
+	fake := dbtest.New()
+	fake.OnQuery(`^SELECT GET_LOCK`).Value(1)
+	fake.OnQuery(`^DO RELEASE_LOCK`)
+	fake.OnQuery(`^CREATE TABLE IF NOT EXISTS`)
+	if done == nil {
+		fake.OnQuery(`information_schema\.tables`).Value(0)
+	} else {
+		fake.OnQuery(`information_schema\.tables`).Value(1)
+	}
+
+	migrations, _ := Load(testSource)
+	names := make(map[int64]Migration)
+	for _, migration := range migrations {
+		names[migration.Version] = migration
+	}
+	rows := make([][]interface{}, 0, len(done))
+	for _, version := range done {
+		name, sum := "gone", "0"
+		if migration, ok := names[version]; ok {
+			name, sum = migration.Name, migration.Checksum
+		}
+		for _, v := range changed {
+			if v == version {
+				sum = "0"
+			}
+		}
+		rows = append(rows, []interface{}{version, name, sum, appliedAt})
+	}
+	fake.OnQuery(`^SELECT version, name, checksum, applied_at FROM`).Rows(
+		[]string{"version", "name", "checksum", "applied_at"}, rows...)
+
+	fake.OnQuery(`^(CREATE|DROP|ALTER) TABLE `)
+	fake.OnQuery("^INSERT INTO `schema_migrations`")
+	fake.OnQuery("^DELETE FROM `schema_migrations`")
+	return fake
+}
+
+// statements returns the queries sent to the fake that change something.
+func statements(fake *dbtest.Fake) []string {
+	reChange := regexp.MustCompile(`^(CREATE|DROP|ALTER|INSERT|DELETE) `)
+	var queries []string
+	for _, call := range fake.Calls() {
+		if reChange.MatchString(call.Query) {
+			queries = append(queries, call.Query)
+		}
+	}
+	return queries
+}
+
+func TestUp(t *testing.T) {
+	fake := newFake([]int64{1})
+	m := New(fake.DB(), testSource)
+	intApplied, err := m.Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intApplied != 2 {
+		t.Errorf("expected 2 applied, got %d", intApplied)
+	}
+
+	expected := []string{
+		"CREATE TABLE team (id INT)",
+		"CREATE TABLE team_user (team_id INT, user_id INT)",
+		"INSERT INTO `schema_migrations` (version, name, checksum) VALUES (?,?,?)",
+		"ALTER TABLE user ADD email VARCHAR(255)",
+		"INSERT INTO `schema_migrations` (version, name, checksum) VALUES (?,?,?)",
+	}
+	queries := statements(fake)
+	if !strings.HasPrefix(queries[0], "CREATE TABLE IF NOT EXISTS `schema_migrations` (") {
+		t.Errorf("expected the bookkeeping table to be created first, got %q", queries[0])
+	}
+	if !reflect.DeepEqual(queries[1:], expected) {
+		t.Errorf("expected\n%q\ngot\n%q", expected, queries[1:])
+	}
+	for _, call := range fake.Calls() {
+		if call.Query == expected[2] {
+			if call.Args[0] != int64(2) || call.Args[1] != "create_team" || call.Args[2] != checksum(string(testSource["0002_create_team.up.sql"].Data)) {
+				t.Errorf("unexpected bookkeeping args %v", call.Args)
+			}
+			break
+		}
+	}
+
+	calls := fake.Calls()
+	if first, last := calls[0].Query, calls[len(calls)-1]; first != "SELECT GET_LOCK(?, ?)" ||
+		last.Query != "DO RELEASE_LOCK(?)" || last.Args[0] != "dbtest.schema_migrations" {
+		t.Errorf("expected the migration to hold the lock, got %q and %q", first, last.Query)
+	}
+}
+
+func TestUpNothingToDo(t *testing.T) {
+	fake := newFake([]int64{1, 2, 3})
+	intApplied, err := New(fake.DB(), testSource).Up(context.Background())
+	if err != nil || intApplied != 0 {
+		t.Errorf("expected nothing applied, got %d, %v", intApplied, err)
+	}
+	if queries := statements(fake); len(queries) != 1 {
+		t.Errorf("expected only the bookkeeping table to be created, got %q", queries)
+	}
+}
+
+func TestUpStatementError(t *testing.T) {
+	// the failing rule has to come before the catch all rules of newFake
+	fake := dbtest.New()
+	fake.OnQuery(`^CREATE TABLE team_user`).Error(errors.New("table exists"))
+	for _, pattern := range []string{`^DO RELEASE_LOCK`, `^CREATE TABLE`, "^INSERT INTO `schema_migrations`"} {
+		fake.OnQuery(pattern)
+	}
+	fake.OnQuery(`^SELECT GET_LOCK`).Value(1)
+	fake.OnQuery(`information_schema\.tables`).Value(0)
+
+	intApplied, err := New(fake.DB(), testSource).Up(context.Background())
+	if err == nil || err.Error() != "version 2 create_team up statement 2: table exists" {
+		t.Errorf("expected the failing statement, got %v", err)
+	}
+	if intApplied != 1 {
+		t.Errorf("expected 1 applied, got %d", intApplied)
+	}
+	inserts := 0
+	for _, query := range statements(fake) {
+		if query == "INSERT INTO `schema_migrations` (version, name, checksum) VALUES (?,?,?)" {
+			inserts++
+		}
+	}
+	if inserts != 1 {
+		t.Errorf("expected only version 1 to be recorded, got %d", inserts)
+	}
+	if calls := fake.Calls(); calls[len(calls)-1].Query != "DO RELEASE_LOCK(?)" {
+		t.Error("lock was not released after the failure")
+	}
+}
+
+func TestUpRefused(t *testing.T) {
+	fake := newFake([]int64{1, 2}, 2)
+	_, err := New(fake.DB(), testSource).Up(context.Background())
+	if !errors.Is(err, ErrChanged) || err.Error() != "applied migration has changed: 2 create_team" {
+		t.Errorf("expected ErrChanged, got %v", err)
+	}
+	if queries := statements(fake); len(queries) != 1 {
+		t.Errorf("expected no migration to run, got %q", queries)
+	}
+
+	fake = dbtest.New()
+	fake.OnQuery(`^SELECT GET_LOCK`).Value(0)
+	if _, err = New(fake.DB(), testSource).Up(context.Background()); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+
+	m := New(newFake(nil).DB(), testSource)
+	m.Table = "schema_migrations; DROP TABLE user"
+	if _, err = m.Up(context.Background()); err == nil {
+		t.Error("expected an invalid table name to be refused")
+	}
+}
+
+func TestDown(t *testing.T) {
+	fake := newFake([]int64{1, 2})
+	intReverted, err := New(fake.DB(), testSource).Down(context.Background(), 1)
+	if err != nil || intReverted != 1 {
+		t.Fatalf("expected 1 reverted, got %d, %v", intReverted, err)
+	}
+	expected := []string{
+		"DROP TABLE team_user",
+		"DROP TABLE team",
+		"DELETE FROM `schema_migrations` WHERE version = ?",
+	}
+	if queries := statements(fake)[1:]; !reflect.DeepEqual(queries, expected) {
+		t.Errorf("expected\n%q\ngot\n%q", expected, queries)
+	}
+	for _, call := range fake.Calls() {
+		if call.Query == expected[2] && call.Args[0] != int64(2) {
+			t.Errorf("expected version 2 to be deleted, got %v", call.Args)
+		}
+	}
+
+	// every step is checked before any of them runs
+	fake = newFake([]int64{1, 2, 3})
+	if _, err = New(fake.DB(), testSource).Down(context.Background(), 2); !errors.Is(err, ErrNoDown) {
+		t.Errorf("expected ErrNoDown, got %v", err)
+	}
+	fake = newFake([]int64{1, 2, 9})
+	if _, err = New(fake.DB(), testSource).Down(context.Background(), 5); !errors.Is(err, ErrMissing) {
+		t.Errorf("expected ErrMissing, got %v", err)
+	}
+	if queries := statements(fake); len(queries) != 1 {
+		t.Errorf("expected no migration to run, got %q", queries)
+	}
+
+	fake = newFake([]int64{1})
+	if intReverted, err = New(fake.DB(), testSource).Down(context.Background(), 0); err != nil || intReverted != 0 || len(fake.Calls()) != 0 {
+		t.Errorf("expected Down(0) to do nothing, got %d, %v, %d calls", intReverted, err, len(fake.Calls()))
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	fake := newFake(nil)
+	var out bytes.Buffer
+	m := New(fake.DB(), testSource)
+	m.DryRun = true
+	m.Out = &out
+	intApplied, err := m.Up(context.Background())
+	if err != nil || intApplied != 3 {
+		t.Fatalf("expected 3 applied, got %d, %v", intApplied, err)
+	}
+	expected := "-- 1 create_user up\nCREATE TABLE user (id INT);\n\n" +
+		"-- 2 create_team up\nCREATE TABLE team (id INT);\n\nCREATE TABLE team_user (team_id INT, user_id INT);\n\n" +
+		"-- 3 add_email up\nALTER TABLE user ADD email VARCHAR(255);\n\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+	for _, call := range fake.Calls() {
+		if call.Query == "SELECT GET_LOCK(?, ?)" || call.Query == "DO RELEASE_LOCK(?)" {
+			t.Errorf("dry run took the lock: %q", call.Query)
+		}
+	}
+	if queries := statements(fake); len(queries) != 0 {
+		t.Errorf("dry run changed the database: %q", queries)
+	}
+
+	fake = newFake([]int64{1, 2, 3})
+	out.Reset()
+	m = New(fake.DB(), testSource)
+	m.DryRun = true
+	m.Out = &out
+	if intReverted, err := m.Down(context.Background(), 1); err == nil || !errors.Is(err, ErrNoDown) || intReverted != 0 {
+		t.Errorf("expected ErrNoDown, got %d, %v", intReverted, err)
+	}
+	if intReverted, err := m.Down(context.Background(), 0); err != nil || intReverted != 0 {
+		t.Errorf("expected nothing reverted, got %d, %v", intReverted, err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	fake := newFake([]int64{1, 2, 9}, 2)
+	statuses, err := New(fake.DB(), testSource).Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Status{
+		{Version: 1, Name: "create_user", Applied: true, AppliedAt: appliedAt},
+		{Version: 2, Name: "create_team", Applied: true, AppliedAt: appliedAt, Changed: true},
+		{Version: 3, Name: "add_email"},
+		{Version: 9, Name: "gone", Applied: true, AppliedAt: appliedAt, Missing: true},
+	}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected\n%+v\ngot\n%+v", expected, statuses)
+	}
+	if queries := statements(fake); len(queries) != 0 {
+		t.Errorf("Status changed the database: %q", queries)
+	}
+
+	statuses, err = New(newFake(nil).DB(), testSource).Status(context.Background())
+	if err != nil || len(statuses) != 3 || statuses[0].Applied {
+		t.Errorf("expected 3 pending versions without a table, got %+v, %v", statuses, err)
+	}
+}
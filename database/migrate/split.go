@@ -0,0 +1,140 @@
+package migrate
+
+// Split breaks a script into statements the way the mysql command line client does,
+// so a migration can be developed and tested with mysql < file.sql and then applied here.
+// Stored program bodies contain ; so they are wrapped in DELIMITER commands:
+//
+//  DELIMITER $$
+//  CREATE PROCEDURE sp_user_count()
+//  BEGIN
+//      SELECT COUNT(*) FROM user;
+//  END $$
+//  DELIMITER ;
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Split returns the statements of script without their delimiters.
+// Delimiters inside quotes and comments are ignored. Statements that only hold comments are dropped.
+func Split(script string) ([]string, error) {
+	s := strings.ReplaceAll(script, "\r\n", "\n")
+	var statements []string
+	var stmt strings.Builder
+	bContent := false // stmt holds more than white space and comments
+	delimiter := ";"
+	bLineStart := true
+
+	flush := func() {
+		if bContent {
+			statements = append(statements, strings.TrimSpace(stmt.String()))
+		}
+		stmt.Reset()
+		bContent = false
+	}
+	lineAt := func(i int) int {
+		return strings.Count(s[:i], "\n") + 1
+	}
+
+	for i := 0; i < len(s); {
+		if bLineStart {
+			bLineStart = false
+			line := s[i:]
+			if end := strings.IndexByte(line, '\n'); end >= 0 {
+				line = line[:end]
+			}
+			fields := strings.Fields(line)
+			if len(fields) > 0 && strings.EqualFold(fields[0], "DELIMITER") {
+				if len(fields) != 2 {
+					return nil, fmt.Errorf("line %d: DELIMITER needs exactly one argument", lineAt(i))
+				}
+				flush()
+				delimiter = fields[1]
+				i += len(line) + 1
+				bLineStart = true
+				continue
+			}
+		}
+
+		c := s[i]
+		switch {
+		case strings.HasPrefix(s[i:], delimiter):
+			flush()
+			i += len(delimiter)
+			continue
+
+		case c == '\'' || c == '"' || c == '`':
+			end := quoteEnd(s, i)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated %c quote", lineAt(i), c)
+			}
+			stmt.WriteString(s[i:end])
+			bContent = true
+			i = end
+			continue
+
+		case c == '#' || isDashComment(s[i:]):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				end = len(s) - i
+			}
+			stmt.WriteString(s[i : i+end])
+			i += end
+			continue
+
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", lineAt(i))
+			}
+			end += i + 4
+			stmt.WriteString(s[i:end])
+			if strings.HasPrefix(s[i:], "/*!") {
+				// executable comment
+				bContent = true
+			}
+			i = end
+			continue
+
+		case c == '\n':
+			bLineStart = true
+
+		case c != ' ' && c != '\t' && c != '\r':
+			bContent = true
+		}
+		stmt.WriteByte(c)
+		i++
+	}
+	flush()
+	return statements, nil
+}
+
+// quoteEnd returns the index after the quote that closes the one at s[start] or -1.
+// A quote is escaped by doubling it. Backslash escapes apply to ' and " but not to `.
+func quoteEnd(s string, start int) int {
+	quote := s[start]
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// isDashComment reports whether s starts with a -- comment, which needs white space after the dashes.
+func isDashComment(s string) bool {
+	if !strings.HasPrefix(s, "--") {
+		return false
+	}
+	return len(s) == 2 || s[2] == ' ' || s[2] == '\t' || s[2] == '\n' || s[2] == '\r'
+}
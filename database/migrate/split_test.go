@@ -0,0 +1,119 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		statements []string
+	}{
+		{
+			"statements",
+			"CREATE TABLE a (id INT);\nINSERT INTO a VALUES (1);\n",
+			[]string{"CREATE TABLE a (id INT)", "INSERT INTO a VALUES (1)"},
+		},
+		{
+			"trailing statement without a delimiter",
+			"SELECT 1;\nSELECT 2\n",
+			[]string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			"delimiters in quotes",
+			"INSERT INTO a VALUES ('x;y', \"a;b\", 'it''s;', 'back\\';slash');\nSELECT `c;d` FROM a;",
+			[]string{"INSERT INTO a VALUES ('x;y', \"a;b\", 'it''s;', 'back\\';slash')", "SELECT `c;d` FROM a"},
+		},
+		{
+			"backslash does not escape a backtick",
+			"SELECT `a\\`;SELECT 2;",
+			[]string{"SELECT `a\\`", "SELECT 2"},
+		},
+		{
+			"delimiters in comments",
+			"-- first; statement\nSELECT 1; # trailing; comment\n/* block; comment */ SELECT 2;",
+			[]string{"-- first; statement\nSELECT 1", "# trailing; comment\n/* block; comment */ SELECT 2"},
+		},
+		{
+			"dashes without a space are not a comment",
+			"SELECT 1 --1;SELECT 2;",
+			[]string{"SELECT 1 --1", "SELECT 2"},
+		},
+		{
+			"statements of only comments are dropped",
+			"SELECT 1;\n-- the end;\n/* really */;\n",
+			[]string{"SELECT 1"},
+		},
+		{
+			"executable comment is kept",
+			"/*!40101 SET NAMES utf8mb4 */;\nSELECT 1;",
+			[]string{"/*!40101 SET NAMES utf8mb4 */", "SELECT 1"},
+		},
+		{
+			"DELIMITER changes",
+			"DROP PROCEDURE IF EXISTS sp_user_count;\n" +
+				"DELIMITER $$\n" +
+				"CREATE PROCEDURE sp_user_count()\nBEGIN\n    SELECT COUNT(*) FROM user;\nEND $$\n" +
+				"delimiter //\n" +
+				"CREATE TRIGGER tr BEFORE INSERT ON user FOR EACH ROW BEGIN SET NEW.id = 1; END//\n" +
+				"DELIMITER ;\n" +
+				"SELECT 1;",
+			[]string{
+				"DROP PROCEDURE IF EXISTS sp_user_count",
+				"CREATE PROCEDURE sp_user_count()\nBEGIN\n    SELECT COUNT(*) FROM user;\nEND",
+				"CREATE TRIGGER tr BEFORE INSERT ON user FOR EACH ROW BEGIN SET NEW.id = 1; END",
+				"SELECT 1",
+			},
+		},
+		{
+			"DELIMITER ends an unterminated statement",
+			"SELECT 1\nDELIMITER $$\nSELECT 2$$",
+			[]string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			"DELIMITER only counts at the start of a line",
+			"SELECT 'DELIMITER $$' AS d;",
+			[]string{"SELECT 'DELIMITER $$' AS d"},
+		},
+		{
+			"CRLF line endings",
+			"SELECT 1;\r\nSELECT\r\n2;\r\n",
+			[]string{"SELECT 1", "SELECT\n2"},
+		},
+		{
+			"empty",
+			" \n-- nothing\n",
+			nil,
+		},
+	}
+	for _, test := range tests {
+		statements, err := Split(test.script)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(statements, test.statements) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.statements, statements)
+		}
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		err    string
+	}{
+		{"SELECT 1;\nSELECT 'x;\n", "line 2: unterminated ' quote"},
+		{"SELECT `a;", "line 1: unterminated ` quote"},
+		{"SELECT 1;\n\n/* no end;", "line 3: unterminated comment"},
+		{"SELECT 1;\nDELIMITER\n", "line 2: DELIMITER needs exactly one argument"},
+		{"DELIMITER $$ ;\n", "line 1: DELIMITER needs exactly one argument"},
+	}
+	for _, test := range tests {
+		if _, err := Split(test.script); err == nil || err.Error() != test.err {
+			t.Errorf("Split(%q): expected error %q, got %v", test.script, test.err, err)
+		}
+	}
+}
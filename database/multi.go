@@ -0,0 +1,140 @@
+package database
+
+// Stored procedures often return a result code row followed by one or more data sets.
+// CallMulti reads the result code and every following result set in one call.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// ResultSet is a container for one result set returned by a stored procedure.
+// Text values are returned as string rather than []byte.
+type ResultSet struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// CallMulti calls a stored procedure that returns a result code row followed by data sets.
+// The result code is the first column of the first row.
+func (dbConn *DBConnection) CallMulti(query string, args ...interface{}) (int, []ResultSet, error) {
+	return dbConn.CallMultiContext(context.Background(), query, args...)
+}
+
+// CallMultiContext is CallMulti bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) CallMultiContext(ctx context.Context, query string, args ...interface{}) (int, []ResultSet, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	result, sets, err := callMulti(ctx, dbConn.db, query, args...)
+	dbConn.record("CallMulti", startTime, err, query, args...)
+	dbConn.markWrite()
+	return result, sets, err
+}
+
+// CallMulti calls a stored procedure that returns a result code row followed by data sets.
+func (tx *Tx) CallMulti(query string, args ...interface{}) (int, []ResultSet, error) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	result, sets, err := callMulti(ctx, tx.tx, query, args...)
+	tx.dbConn.record("CallMulti", startTime, err, query, args...)
+	return result, sets, err
+}
+
+// callMulti is the shared implementation of CallMulti.
+func callMulti(ctx context.Context, runner queryRunner, query string, args ...interface{}) (int, []ResultSet, error) {
+	strArgs := doTrace(ctx, query, args...)
+	result := -1
+
+	rows, err := runner.QueryContext(ctx, query, args...)
+	if err != nil {
+		warnError(err, strArgs, query, args...)
+		return result, nil, err
+	}
+	defer rows.Close()
+
+	// result code
+	codeSet, err := readResultSet(rows)
+	switch {
+	case err != nil:
+		warnError(err, strArgs, query, args...)
+		return result, nil, err
+	case len(codeSet.Rows) == 0 || len(codeSet.Rows[0]) == 0:
+		err = errors.New("stored procedure returned no result code")
+		warnError(err, strArgs, query, args...)
+		return result, nil, err
+	}
+	result, ok := asInt(codeSet.Rows[0][0])
+	if !ok {
+		err = errors.New("stored procedure result code is not an integer")
+		warnError(err, strArgs, query, args...)
+		return -1, nil, err
+	}
+	if result < 0 {
+		utils.Warning.Printf("query returned result:%d %s", result, refreshTrace(strArgs, query, args...))
+	}
+
+	// data sets
+	sets := make([]ResultSet, 0, 2)
+	for rows.NextResultSet() {
+		set, err := readResultSet(rows)
+		if err != nil {
+			warnError(err, strArgs, query, args...)
+			return result, sets, err
+		}
+		sets = append(sets, set)
+	}
+	if err = rows.Err(); err != nil {
+		warnError(err, strArgs, query, args...)
+	}
+	return result, sets, err
+}
+
+// readResultSet reads the remaining rows of the current result set.
+func readResultSet(rows *sql.Rows) (ResultSet, error) {
+	var set ResultSet
+	columns, err := rows.Columns()
+	if err != nil {
+		return set, err
+	}
+	set.Columns = columns
+
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err = rows.Scan(ptrs...); err != nil {
+			return set, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		set.Rows = append(set.Rows, values)
+	}
+	return set, rows.Err()
+}
+
+// asInt converts a driver value into an int.
+func asInt(v interface{}) (int, bool) {
+	switch value := v.(type) {
+	case int64:
+		return int(value), true
+	case int:
+		return value, true
+	case string:
+		intValue, err := strconv.Atoi(value)
+		return intValue, err == nil
+	default:
+		return 0, false
+	}
+}
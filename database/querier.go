@@ -0,0 +1,67 @@
+package database
+
+// Querier is the set of wrapper functions offered by DBConnection.
+// Code that depends on Querier rather than on *DBConnection can be unit tested
+// against the scriptable fake in the dbtest package instead of a live mysql.
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/knousere/web-service-commons/go-sql-driver/mysql"
+)
+
+// Querier is implemented by *DBConnection.
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	GetRows(query string, args ...interface{}) (*sql.Rows, error)
+	GetRowsContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	GetOneRow(query string, args ...interface{}) *sql.Row
+	GetOneRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	GetPositiveInt(query string, args ...interface{}) (int, error)
+	GetPositiveIntContext(ctx context.Context, query string, args ...interface{}) (int, error)
+	GetPositiveIntDefault(intDefault int, query string, args ...interface{}) (int, error)
+	GetPositiveIntDefaultContext(ctx context.Context, intDefault int, query string, args ...interface{}) (int, error)
+	GetRecordID(query string, args ...interface{}) (int, error)
+	GetRecordIDContext(ctx context.Context, query string, args ...interface{}) (int, error)
+	GetRecordCount(query string, args ...interface{}) (int, error)
+	GetRecordCountContext(ctx context.Context, query string, args ...interface{}) (int, error)
+	GetOneString(query string, args ...interface{}) (string, error)
+	GetOneStringContext(ctx context.Context, query string, args ...interface{}) (string, error)
+	InsertRow(query string, args ...interface{}) (int, error)
+	InsertRowContext(ctx context.Context, query string, args ...interface{}) (int, error)
+	InsertRowResult(query string, args ...interface{}) (int, int, error)
+	InsertRowResultContext(ctx context.Context, query string, args ...interface{}) (int, int, error)
+	UpdateRows(query string, args ...interface{}) (int, error)
+	UpdateRowsContext(ctx context.Context, query string, args ...interface{}) (int, error)
+	UpdateRowsWithDeadlock(query string, args ...interface{}) (int, bool)
+	UpdateRowsWithDeadlockContext(ctx context.Context, query string, args ...interface{}) (int, bool)
+	UpdateRowsResult(query string, args ...interface{}) (int, int, error)
+	UpdateRowsResultContext(ctx context.Context, query string, args ...interface{}) (int, int, error)
+	DeleteRows(query string, args ...interface{}) (int, error)
+	DeleteRowsContext(ctx context.Context, query string, args ...interface{}) (int, error)
+	DeleteRowsResult(query string, args ...interface{}) (int, int, error)
+	DeleteRowsResultContext(ctx context.Context, query string, args ...interface{}) (int, int, error)
+	CallMulti(query string, args ...interface{}) (int, []ResultSet, error)
+	CallMultiContext(ctx context.Context, query string, args ...interface{}) (int, []ResultSet, error)
+	InsertRowChecked(query string, args ...interface{}) (int, error)
+	InsertRowCheckedContext(ctx context.Context, query string, args ...interface{}) (int, error)
+	UpdateRowsChecked(query string, args ...interface{}) (int, error)
+	UpdateRowsCheckedContext(ctx context.Context, query string, args ...interface{}) (int, error)
+	DeleteRowsChecked(query string, args ...interface{}) (int, error)
+	DeleteRowsCheckedContext(ctx context.Context, query string, args ...interface{}) (int, error)
+	Run(call *ProcCall) (int, error)
+	RunContext(ctx context.Context, call *ProcCall) (int, error)
+	RunChecked(call *ProcCall) error
+	RunCheckedContext(ctx context.Context, call *ProcCall) error
+	BulkInsert(strTable string, columns []string, rows [][]interface{}, opts *BulkOptions) ([]int, error)
+	BulkInsertContext(ctx context.Context, strTable string, columns []string, rows [][]interface{}, opts *BulkOptions) ([]int, error)
+	LoadRows(strTable string, columns []string, next RowIterator) (int, []mysql.MySQLWarning, error)
+	LoadRowsContext(ctx context.Context, strTable string, columns []string, next RowIterator) (int, []mysql.MySQLWarning, error)
+	WithTx(fn func(tx *Tx) error) error
+	WithTxContext(ctx context.Context, fn func(tx *Tx) error) error
+}
+
+// compile time check
+var _ Querier = (*DBConnection)(nil)
@@ -0,0 +1,131 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knousere/web-service-commons/database"
+	"github.com/knousere/web-service-commons/database/dbtest"
+)
+
+// newReplica returns a fake replica named strHost that answers the count query with value.
+func newReplica(strHost string, value int) (*dbtest.Fake, *database.DBConnection) {
+	fake := dbtest.New()
+	fake.OnCall("sp_cart_count").Value(value)
+	db := fake.DB()
+	db.Host = strHost
+	return fake, db
+}
+
+func TestReplicationLag(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  func(fake *dbtest.Fake)
+		healthy bool
+		lag     time.Duration
+		err     string
+	}{
+		{
+			"within the lag",
+			func(fake *dbtest.Fake) {
+				fake.OnQuery(`^SHOW REPLICA STATUS$`).Rows([]string{"Replica_IO_State", "Seconds_Behind_Source"}, []interface{}{"Waiting", 3})
+			},
+			true, 3 * time.Second, "",
+		},
+		{
+			"lagging",
+			func(fake *dbtest.Fake) {
+				fake.OnQuery(`^SHOW REPLICA STATUS$`).Rows([]string{"Seconds_Behind_Source"}, []interface{}{30})
+			},
+			false, 30 * time.Second, "replication lag 30s exceeds 10s",
+		},
+		{
+			"server before 8.0.22",
+			func(fake *dbtest.Fake) {
+				fake.OnQuery(`^SHOW REPLICA STATUS$`).Error(sql.ErrConnDone)
+				fake.OnQuery(`^SHOW SLAVE STATUS$`).Rows([]string{"Seconds_Behind_Master"}, []interface{}{"4"})
+			},
+			true, 4 * time.Second, "",
+		},
+		{
+			"replication stopped",
+			func(fake *dbtest.Fake) {
+				fake.OnQuery(`^SHOW REPLICA STATUS$`).Rows([]string{"Seconds_Behind_Source"}, []interface{}{nil})
+			},
+			false, 0, "replication is not running",
+		},
+		{
+			"not a replica",
+			func(fake *dbtest.Fake) {
+				fake.OnQuery(`^SHOW REPLICA STATUS$`).Rows([]string{"Seconds_Behind_Source"})
+			},
+			true, 0, "",
+		},
+	}
+	for _, test := range tests {
+		fake, replica := newReplica("replica1", 1)
+		test.status(fake)
+		db := dbtest.New().DB()
+		db.ReplicaMaxLag = 10 * time.Second
+		database.SetReplicas(db, replica)
+
+		status := db.ReplicaStatus()[0]
+		if status.Healthy != test.healthy || status.Lag != test.lag {
+			t.Errorf("%s: expected healthy %v with lag %v, got %+v", test.name, test.healthy, test.lag, status)
+		}
+		if test.err == "" && status.LastError != nil || test.err != "" && (status.LastError == nil || !strings.Contains(status.LastError.Error(), test.err)) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, status.LastError)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	primary := dbtest.New()
+	primary.OnCall("sp_cart_count").Value(0)
+	primary.OnCall("sp_cart_add").Value(12)
+	db := primary.DB()
+	db.ReplicaMaxLag = 10 * time.Second
+	db.StickyPrimary = time.Hour
+
+	var replicas []*database.DBConnection
+	for i, lag := range []int{0, 0, 60} {
+		fake, replica := newReplica(fmt.Sprintf("replica%d", i+1), i+1)
+		fake.OnQuery(`^SHOW REPLICA STATUS$`).Rows([]string{"Seconds_Behind_Source"}, []interface{}{lag})
+		replicas = append(replicas, replica)
+	}
+	database.SetReplicas(db, replicas...)
+
+	// reads are the value of the server that answered, 0 for the primary
+	read := func(ctx context.Context) int {
+		count, err := db.GetRecordCountContext(ctx, "CALL sp_cart_count(?)", 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	// round robin skips the lagging replica3
+	reads := []int{read(context.Background()), read(context.Background()), read(context.Background())}
+	if reads[0] != 2 || reads[1] != 1 || reads[2] != 1 {
+		t.Errorf("expected reads from replica2, replica1, replica1, got %v", reads)
+	}
+	if count := read(database.WithPrimary(context.Background())); count != 0 {
+		t.Errorf("WithPrimary: expected a read from the primary, got replica%d", count)
+	}
+
+	// after a write reads stay on the primary for StickyPrimary
+	if _, err := db.InsertRow("CALL sp_cart_add(?)", 7); err != nil {
+		t.Fatal(err)
+	}
+	if count := read(context.Background()); count != 0 {
+		t.Errorf("StickyPrimary: expected a read from the primary after a write, got replica%d", count)
+	}
+	primary.AssertCalled(t, "sp_cart_add", 7)
+	if calls := primary.CallsTo("sp_cart_count"); len(calls) != 2 {
+		t.Errorf("expected 2 reads on the primary, got %d", len(calls))
+	}
+}
@@ -0,0 +1,282 @@
+package database
+
+// Read replicas take load off the primary. If DBConnection.Replicas is set, the read only wrappers
+// GetRows, GetOneRow, GetRecordCount, GetOneString, GetPositiveInt, Select and Get are sent to
+// a healthy replica.
+// Every other wrapper, and every wrapper inside a transaction, stays on the primary.
+//
+// Replicas are pinged every ReplicaCheck and dropped while they are unreachable or while their
+// replication lag exceeds ReplicaMaxLag. If no replica is healthy, reads fall back to the primary.
+//
+// Replication is asynchronous, so a read just after a write may not see it on a replica.
+// StickyPrimary sends all reads to the primary for a while after any write through this DBConnection.
+// WithPrimary does the same for the reads of a single request.
+//
+//  ctx = database.WithPrimary(ctx)
+//  count, err := database.AppDb.GetRecordCountContext(ctx, "CALL sp_cart_count(?)", intUserID)
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// ReplicaPolicy selects a healthy replica for a read.
+type ReplicaPolicy int
+
+// These are the replica selection policies.
+const (
+	ReplicaRoundRobin   ReplicaPolicy = 0 // rotate through the healthy replicas
+	ReplicaLeastLatency ReplicaPolicy = 1 // the healthy replica with the lowest ping time
+)
+
+// These are the health check defaults.
+const (
+	defaultReplicaCheck = 10 * time.Second
+	replicaCheckTimeout = 5 * time.Second
+)
+
+// ReplicaStatus is a snapshot of the health of one replica.
+type ReplicaStatus struct {
+	Host      string
+	Healthy   bool
+	Latency   time.Duration // ping time of the last check
+	Lag       time.Duration // replication lag of the last check
+	LastError error
+}
+
+// primaryKey is the context key set by WithPrimary.
+type primaryKey struct{}
+
+// WithPrimary returns a context that sends reads to the primary.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// replica is one read replica and the result of its last health check.
+type replica struct {
+	host   string
+	db     *sql.DB
+	mutex  sync.RWMutex
+	status ReplicaStatus
+}
+
+// replicaSet is the replicas of one DBConnection.
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint32
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// openReplicas opens every replica and starts the health checks.
+// An unreachable replica is not an error, it stays out of rotation until it recovers.
+func (dbConn *DBConnection) openReplicas(strPassword string) (*replicaSet, error) {
+	set := &replicaSet{stop: make(chan struct{})}
+	for _, strHost := range dbConn.Replicas {
+		connector, err := newConnector(dbConn, strHost, strPassword)
+		if err != nil {
+			set.close()
+			return nil, err
+		}
+		r := &replica{host: strHost, db: sql.OpenDB(connector)}
+		r.status.Host = strHost
+		dbConn.setPool(r.db)
+		set.replicas = append(set.replicas, r)
+	}
+
+	set.check(dbConn.ReplicaMaxLag)
+
+	interval := dbConn.ReplicaCheck
+	if interval <= 0 {
+		interval = defaultReplicaCheck
+	}
+	set.wg.Add(1)
+	go func() {
+		defer set.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-set.stop:
+				return
+			case <-ticker.C:
+				set.check(dbConn.ReplicaMaxLag)
+			}
+		}
+	}()
+	return set, nil
+}
+
+// close stops the health checks and closes every replica.
+func (set *replicaSet) close() {
+	select {
+	case <-set.stop:
+		return
+	default:
+		close(set.stop)
+	}
+	set.wg.Wait()
+	for _, r := range set.replicas {
+		r.db.Close()
+	}
+}
+
+// check runs the health check of every replica concurrently.
+func (set *replicaSet) check(maxLag time.Duration) {
+	var wg sync.WaitGroup
+	for _, r := range set.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			r.check(maxLag)
+		}(r)
+	}
+	wg.Wait()
+}
+
+// pick returns a healthy replica according to policy or nil if there is none.
+func (set *replicaSet) pick(policy ReplicaPolicy) *replica {
+	n := len(set.replicas)
+	switch policy {
+	case ReplicaLeastLatency:
+		var best *replica
+		var bestLatency time.Duration
+		for _, r := range set.replicas {
+			r.mutex.RLock()
+			healthy, latency := r.status.Healthy, r.status.Latency
+			r.mutex.RUnlock()
+			if healthy && (best == nil || latency < bestLatency) {
+				best, bestLatency = r, latency
+			}
+		}
+		return best
+	default:
+		start := int(set.next.Add(1))
+		for i := 0; i < n; i++ {
+			r := set.replicas[(start+i)%n]
+			r.mutex.RLock()
+			healthy := r.status.Healthy
+			r.mutex.RUnlock()
+			if healthy {
+				return r
+			}
+		}
+		return nil
+	}
+}
+
+// check pings the replica and measures its replication lag.
+func (r *replica) check(maxLag time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+	defer cancel()
+
+	startTime := time.Now()
+	err := r.db.PingContext(ctx)
+	latency := time.Since(startTime)
+
+	var lag time.Duration
+	if err == nil && maxLag > 0 {
+		lag, err = replicationLag(ctx, r.db)
+		if err == nil && lag > maxLag {
+			err = fmt.Errorf("replication lag %s exceeds %s", lag, maxLag)
+		}
+	}
+
+	r.mutex.Lock()
+	wasHealthy := r.status.Healthy
+	r.status.Healthy = err == nil
+	r.status.Latency = latency
+	r.status.Lag = lag
+	r.status.LastError = err
+	r.mutex.Unlock()
+
+	switch {
+	case err != nil && wasHealthy:
+		utils.Warning.Println("replica dropped", r.host, err.Error())
+	case err != nil:
+		utils.Trace.Println("replica unhealthy", r.host, err.Error())
+	case !wasHealthy:
+		utils.Info.Println("replica in rotation", r.host)
+	}
+}
+
+// replicationLag reads Seconds_Behind_Source from the replica status.
+// A server that is not a replica has no lag.
+func replicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		// servers before 8.0.22
+		rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS")
+		if err != nil {
+			return 0, err
+		}
+	}
+	defer rows.Close()
+
+	set, err := readResultSet(rows)
+	if err != nil || len(set.Rows) == 0 {
+		return 0, err
+	}
+	for i, column := range set.Columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		if set.Rows[0][i] == nil {
+			return 0, errors.New("replication is not running")
+		}
+		seconds, ok := asInt(set.Rows[0][i])
+		if !ok {
+			return 0, fmt.Errorf("unexpected %s %v", column, set.Rows[0][i])
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, errors.New("replica status has no Seconds_Behind_Source")
+}
+
+// reader returns where a read only wrapper should run: a healthy replica,
+// or the primary if there is none, reads are pinned, or there are no replicas.
+func (dbConn *DBConnection) reader(ctx context.Context) queryRunner {
+	if dbConn.replicas == nil {
+		return dbConn.db
+	}
+	if pinned, _ := ctx.Value(primaryKey{}).(bool); pinned {
+		return dbConn.db
+	}
+	if dbConn.StickyPrimary > 0 {
+		if time.Since(time.Unix(0, dbConn.lastWrite.Load())) < dbConn.StickyPrimary {
+			return dbConn.db
+		}
+	}
+	if r := dbConn.replicas.pick(dbConn.ReplicaPolicy); r != nil {
+		return r.db
+	}
+	return dbConn.db
+}
+
+// markWrite starts the StickyPrimary window.
+func (dbConn *DBConnection) markWrite() {
+	if dbConn.StickyPrimary > 0 {
+		dbConn.lastWrite.Store(time.Now().UnixNano())
+	}
+}
+
+// ReplicaStatus returns the health of every replica.
+func (dbConn *DBConnection) ReplicaStatus() []ReplicaStatus {
+	if dbConn.replicas == nil {
+		return nil
+	}
+	statuses := make([]ReplicaStatus, 0, len(dbConn.replicas.replicas))
+	for _, r := range dbConn.replicas.replicas {
+		r.mutex.RLock()
+		statuses = append(statuses, r.status)
+		r.mutex.RUnlock()
+	}
+	return statuses
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// replicaStates returns a replica set whose replicas have the given health and latency.
+func replicaStates(healthy []bool, latencies []time.Duration) *replicaSet {
+	set := &replicaSet{}
+	for i := range healthy {
+		r := &replica{host: string(rune('a' + i))}
+		r.status.Healthy = healthy[i]
+		r.status.Latency = latencies[i]
+		set.replicas = append(set.replicas, r)
+	}
+	return set
+}
+
+func TestPick(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name      string
+		healthy   []bool
+		latencies []time.Duration
+		policy    ReplicaPolicy
+		picks     string // hosts of successive picks, "-" for none
+	}{
+		{"round robin", []bool{true, true, true}, []time.Duration{3 * ms, 2 * ms, 1 * ms}, ReplicaRoundRobin, "bcabca"},
+		{"round robin skips unhealthy", []bool{true, false, true}, []time.Duration{ms, ms, ms}, ReplicaRoundRobin, "ccacca"},
+		{"round robin none healthy", []bool{false, false}, []time.Duration{ms, ms}, ReplicaRoundRobin, "--"},
+		{"least latency", []bool{true, true, true}, []time.Duration{3 * ms, ms, 2 * ms}, ReplicaLeastLatency, "bbb"},
+		{"least latency skips unhealthy", []bool{true, false, true}, []time.Duration{3 * ms, ms, 2 * ms}, ReplicaLeastLatency, "ccc"},
+		{"least latency none healthy", []bool{false}, []time.Duration{ms}, ReplicaLeastLatency, "-"},
+	}
+	for _, test := range tests {
+		set := replicaStates(test.healthy, test.latencies)
+		picks := ""
+		for range test.picks {
+			if r := set.pick(test.policy); r != nil {
+				picks += r.host
+			} else {
+				picks += "-"
+			}
+		}
+		if picks != test.picks {
+			t.Errorf("%s: expected picks %q, got %q", test.name, test.picks, picks)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package database
+
+// Stored procedures report failures as negative result codes rather than raw errors.
+// This registry maps those result codes onto typed errors so that callers can test them
+// with errors.Is and errors.As rather than decoding magic numbers.
+//
+//  func init() {
+//  	database.RegisterResultCode(-3, database.ErrNotFound)
+//  	database.RegisterResultCode(-4, database.ErrConflict)
+//  }
+//
+//  id, err := database.AppDb.InsertRowChecked("CALL sp_item_insert(?,?)", intUserID, strName)
+//  switch {
+//  case errors.Is(err, database.ErrPermissionDenied):
+//  	// 403
+//  case errors.Is(err, database.ErrNotFound):
+//  	// 404
+//  }
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// These are the typed errors that result codes are commonly mapped onto.
+var (
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrNotFound         = errors.New("not found")
+	ErrConflict         = errors.New("conflict")
+	ErrResultCode       = errors.New("stored procedure failed") // unregistered negative result code
+)
+
+// ResultError is the error returned for a failing result code.
+// Err is the registered error, or ErrResultCode if the code is not registered.
+type ResultError struct {
+	Code int
+	Err  error
+}
+
+// Error describes the result code.
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("%s (result code %d)", e.Err.Error(), e.Code)
+}
+
+// Unwrap supports errors.Is and errors.As on the registered error.
+func (e *ResultError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	resultCodeMutex sync.RWMutex
+	resultCodes     = map[int]error{
+		-2: ErrPermissionDenied,
+	}
+)
+
+// RegisterResultCode maps a stored procedure result code onto an error.
+// Registration is typically done in init(). A nil err removes the mapping.
+func RegisterResultCode(code int, err error) {
+	resultCodeMutex.Lock()
+	defer resultCodeMutex.Unlock()
+	if err == nil {
+		delete(resultCodes, code)
+		return
+	}
+	resultCodes[code] = err
+}
+
+// ResultCodeError returns the error for a result code.
+// Registered codes return a ResultError wrapping the registered error.
+// Other negative codes return a ResultError wrapping ErrResultCode.
+// Other codes indicate success and return nil.
+func ResultCodeError(code int) error {
+	resultCodeMutex.RLock()
+	err, ok := resultCodes[code]
+	resultCodeMutex.RUnlock()
+	switch {
+	case ok:
+		return &ResultError{Code: code, Err: err}
+	case code < 0:
+		return &ResultError{Code: code, Err: ErrResultCode}
+	}
+	return nil
+}
+
+// checkResult folds a result code into the error returned by a wrapper.
+// A server error takes precedence over the result code.
+func checkResult(result int, err error) error {
+	if err != nil {
+		return err
+	}
+	return ResultCodeError(result)
+}
+
+// InsertRowChecked is InsertRowResult with the result code returned as a typed error.
+func (dbConn *DBConnection) InsertRowChecked(query string, args ...interface{}) (int, error) {
+	return dbConn.InsertRowCheckedContext(context.Background(), query, args...)
+}
+
+// InsertRowCheckedContext is InsertRowChecked bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) InsertRowCheckedContext(ctx context.Context, query string, args ...interface{}) (int, error) {
+	result, id, err := dbConn.InsertRowResultContext(ctx, query, args...)
+	return id, checkResult(result, err)
+}
+
+// UpdateRowsChecked is UpdateRowsResult with the result code returned as a typed error.
+func (dbConn *DBConnection) UpdateRowsChecked(query string, args ...interface{}) (int, error) {
+	return dbConn.UpdateRowsCheckedContext(context.Background(), query, args...)
+}
+
+// UpdateRowsCheckedContext is UpdateRowsChecked bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) UpdateRowsCheckedContext(ctx context.Context, query string, args ...interface{}) (int, error) {
+	result, affectedCount, err := dbConn.UpdateRowsResultContext(ctx, query, args...)
+	return affectedCount, checkResult(result, err)
+}
+
+// DeleteRowsChecked is DeleteRowsResult with the result code returned as a typed error.
+func (dbConn *DBConnection) DeleteRowsChecked(query string, args ...interface{}) (int, error) {
+	return dbConn.DeleteRowsCheckedContext(context.Background(), query, args...)
+}
+
+// DeleteRowsCheckedContext is DeleteRowsChecked bounded by ctx and the default query timeout.
+func (dbConn *DBConnection) DeleteRowsCheckedContext(ctx context.Context, query string, args ...interface{}) (int, error) {
+	result, affectedCount, err := dbConn.DeleteRowsResultContext(ctx, query, args...)
+	return affectedCount, checkResult(result, err)
+}
+
+// InsertRowChecked is InsertRowResult with the result code returned as a typed error.
+func (tx *Tx) InsertRowChecked(query string, args ...interface{}) (int, error) {
+	result, id, err := tx.InsertRowResult(query, args...)
+	return id, checkResult(result, err)
+}
+
+// UpdateRowsChecked is UpdateRowsResult with the result code returned as a typed error.
+func (tx *Tx) UpdateRowsChecked(query string, args ...interface{}) (int, error) {
+	result, affectedCount, err := tx.UpdateRowsResult(query, args...)
+	return affectedCount, checkResult(result, err)
+}
+
+// DeleteRowsChecked is DeleteRowsResult with the result code returned as a typed error.
+func (tx *Tx) DeleteRowsChecked(query string, args ...interface{}) (int, error) {
+	result, affectedCount, err := tx.DeleteRowsResult(query, args...)
+	return affectedCount, checkResult(result, err)
+}
@@ -0,0 +1,138 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/knousere/web-service-commons/database"
+	"github.com/knousere/web-service-commons/database/dbtest"
+)
+
+// quotaError is a registered error type that carries data, for errors.As.
+type quotaError struct {
+	limit int
+}
+
+func (e *quotaError) Error() string {
+	return "quota exceeded"
+}
+
+func TestResultCodeError(t *testing.T) {
+	database.RegisterResultCode(-3, database.ErrNotFound)
+	database.RegisterResultCode(-9, &quotaError{limit: 5})
+	defer database.RegisterResultCode(-3, nil)
+	defer database.RegisterResultCode(-9, nil)
+
+	tests := []struct {
+		code int
+		err  error // nil for success
+		text string
+	}{
+		{0, nil, ""},
+		{1, nil, ""},
+		{-2, database.ErrPermissionDenied, "permission denied (result code -2)"},
+		{-3, database.ErrNotFound, "not found (result code -3)"},
+		{-4, database.ErrResultCode, "stored procedure failed (result code -4)"},
+	}
+	for _, test := range tests {
+		err := database.ResultCodeError(test.code)
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("ResultCodeError(%d) = %v, expected nil", test.code, err)
+			}
+			continue
+		}
+		var resultErr *database.ResultError
+		if !errors.Is(err, test.err) || !errors.As(err, &resultErr) || resultErr.Code != test.code {
+			t.Errorf("ResultCodeError(%d) = %#v, expected a ResultError wrapping %v", test.code, err, test.err)
+		}
+		if err.Error() != test.text {
+			t.Errorf("ResultCodeError(%d) says %q, expected %q", test.code, err.Error(), test.text)
+		}
+	}
+
+	var quota *quotaError
+	if err := database.ResultCodeError(-9); !errors.As(err, &quota) || quota.limit != 5 {
+		t.Errorf("errors.As did not reach the registered error: %v", err)
+	}
+
+	// a nil error removes the mapping
+	database.RegisterResultCode(-3, nil)
+	if err := database.ResultCodeError(-3); !errors.Is(err, database.ErrResultCode) || errors.Is(err, database.ErrNotFound) {
+		t.Errorf("expected the mapping to be removed, got %v", err)
+	}
+}
+
+func TestCheckedWrappers(t *testing.T) {
+	database.RegisterResultCode(-3, database.ErrNotFound)
+	defer database.RegisterResultCode(-3, nil)
+
+	fake := dbtest.New()
+	fake.OnCall("sp_ok").Result(0, 4)
+	fake.OnCall("sp_denied").Result(-2, 0)
+	fake.OnCall("sp_missing").Result(-3, 0)
+	fake.OnCall("sp_other").Result(-7, 0)
+	fake.OnCall("sp_broken").Error(sql.ErrConnDone)
+	db := fake.DB()
+
+	// each wrapper calls the procedure strProc and returns its count, RunChecked has none so it passes 4
+	inTx := func(fn func(tx *database.Tx, strProc string) (int, error)) func(string) (int, error) {
+		return func(strProc string) (int, error) {
+			var n int
+			var err error
+			db.WithTx(func(tx *database.Tx) error {
+				n, err = fn(tx, strProc)
+				return nil
+			})
+			return n, err
+		}
+	}
+	wrappers := map[string]func(strProc string) (int, error){
+		"InsertRowChecked":  func(strProc string) (int, error) { return db.InsertRowChecked("CALL " + strProc + "()") },
+		"UpdateRowsChecked": func(strProc string) (int, error) { return db.UpdateRowsChecked("CALL " + strProc + "()") },
+		"DeleteRowsChecked": func(strProc string) (int, error) { return db.DeleteRowsChecked("CALL " + strProc + "()") },
+		"RunChecked":        func(strProc string) (int, error) { return 4, db.RunChecked(database.Call(strProc)) },
+		"Tx.InsertRowChecked": inTx(func(tx *database.Tx, strProc string) (int, error) {
+			return tx.InsertRowChecked("CALL " + strProc + "()")
+		}),
+		"Tx.UpdateRowsChecked": inTx(func(tx *database.Tx, strProc string) (int, error) {
+			return tx.UpdateRowsChecked("CALL " + strProc + "()")
+		}),
+		"Tx.DeleteRowsChecked": inTx(func(tx *database.Tx, strProc string) (int, error) {
+			return tx.DeleteRowsChecked("CALL " + strProc + "()")
+		}),
+		"Tx.RunChecked": inTx(func(tx *database.Tx, strProc string) (int, error) {
+			return 4, tx.RunChecked(database.Call(strProc))
+		}),
+	}
+
+	tests := []struct {
+		proc string
+		err  error
+	}{
+		{"sp_ok", nil},
+		{"sp_denied", database.ErrPermissionDenied},
+		{"sp_missing", database.ErrNotFound},
+		{"sp_other", database.ErrResultCode},
+		{"sp_broken", sql.ErrConnDone},
+	}
+	for name, wrapper := range wrappers {
+		for _, test := range tests {
+			n, err := wrapper(test.proc)
+			switch {
+			case test.err == nil && err != nil:
+				t.Errorf("%s %s: unexpected error %v", name, test.proc, err)
+			case test.err == nil && n != 4:
+				t.Errorf("%s %s: expected 4, got %d", name, test.proc, n)
+			case test.err != nil && !errors.Is(err, test.err):
+				t.Errorf("%s %s: expected %v, got %v", name, test.proc, test.err, err)
+			}
+			// a server error is not mistaken for a result code
+			var resultErr *database.ResultError
+			if test.err == sql.ErrConnDone && errors.As(err, &resultErr) {
+				t.Errorf("%s %s: server error returned as %v", name, test.proc, resultErr)
+			}
+		}
+	}
+}
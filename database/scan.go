@@ -0,0 +1,192 @@
+package database
+
+// These helpers map result columns onto struct fields by `db:"col"` tags so that callers
+// need not hand-write rows.Next/Scan loops. Any field type that database/sql can scan into
+// may be tagged, including sql.NullString and friends as well as mysql.NullTime.
+//
+//  type user struct {
+//  	ID      int            `db:"user_id"`
+//  	Name    string         `db:"username"`
+//  	Email   sql.NullString `db:"email"`
+//  	Created mysql.NullTime `db:"created"`
+//  }
+//  users, err := database.Select[user](database.AppDb, "CALL sp_users_list(?)", intTeamID)
+//
+// Untagged fields and fields tagged `db:"-"` are ignored. Embedded structs are searched for tags.
+// Every column must map onto a field and every tagged field must have a column,
+// otherwise an error naming the offending columns is returned.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fieldMaps caches the column to field index map of each struct type.
+var fieldMaps sync.Map
+
+// Select runs query and returns every row mapped onto a T. Empty is OK.
+func Select[T any](dbConn *DBConnection, query string, args ...interface{}) ([]T, error) {
+	return SelectContext[T](context.Background(), dbConn, query, args...)
+}
+
+// SelectContext is Select bounded by ctx and the default query timeout.
+func SelectContext[T any](ctx context.Context, dbConn *DBConnection, query string, args ...interface{}) ([]T, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+	items, _, err := scanQuery[T](ctx, dbConn.reader(ctx), -1, query, args...)
+	dbConn.record("Select", startTime, err, query, args...)
+	return items, err
+}
+
+// Get runs query and returns the first row mapped onto a T.
+// It returns sql.ErrNoRows if there is no row.
+func Get[T any](dbConn *DBConnection, query string, args ...interface{}) (T, error) {
+	return GetContext[T](context.Background(), dbConn, query, args...)
+}
+
+// GetContext is Get bounded by ctx and the default query timeout.
+func GetContext[T any](ctx context.Context, dbConn *DBConnection, query string, args ...interface{}) (T, error) {
+	ctx, cancel := dbConn.withTimeout(ctx)
+	defer cancel()
+	startTime := time.Now()
+
+	var item T
+	items, strArgs, err := scanQuery[T](ctx, dbConn.reader(ctx), 1, query, args...)
+	dbConn.record("Get", startTime, err, query, args...)
+	switch {
+	case err != nil:
+		return item, err
+	case len(items) == 0:
+		traceLogger(ctx).Println("record not found", refreshTrace(strArgs, query, args...))
+		return item, sql.ErrNoRows
+	}
+	return items[0], nil
+}
+
+// scanQuery runs query and maps up to limit rows onto T. A negative limit reads all rows.
+// It returns the trace string of doTrace along with the rows.
+func scanQuery[T any](ctx context.Context, runner queryRunner, limit int, query string, args ...interface{}) ([]T, string, error) {
+	strArgs := doTrace(ctx, query, args...)
+
+	rows, err := runner.QueryContext(ctx, query, args...)
+	if err != nil {
+		warnError(err, strArgs, query, args...)
+		return nil, strArgs, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		warnError(err, strArgs, query, args...)
+		return nil, strArgs, err
+	}
+
+	var zero T
+	indexes, err := columnIndexes(reflect.TypeOf(zero), columns)
+	if err != nil {
+		warnError(err, strArgs, query, args...)
+		return nil, strArgs, err
+	}
+
+	items := make([]T, 0, 10)
+	dest := make([]interface{}, len(indexes))
+	for (limit < 0 || len(items) < limit) && rows.Next() {
+		var item T
+		v := reflect.ValueOf(&item).Elem()
+		for i, index := range indexes {
+			dest[i] = v.FieldByIndex(index).Addr().Interface()
+		}
+		if err = rows.Scan(dest...); err != nil {
+			warnError(err, strArgs, query, args...)
+			return items, strArgs, err
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		warnError(err, strArgs, query, args...)
+	}
+	return items, strArgs, err
+}
+
+// columnIndexes returns the struct field index of each column.
+// Unmapped columns and tagged fields without a column are reported together.
+func columnIndexes(t reflect.Type, columns []string) ([][]int, error) {
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot scan into %v: not a struct", t)
+	}
+	fields := fieldMap(t)
+
+	indexes := make([][]int, len(columns))
+	found := make(map[string]bool, len(columns))
+	unmapped := make([]string, 0, 5)
+	for i, column := range columns {
+		index, ok := fields[column]
+		if !ok {
+			unmapped = append(unmapped, column)
+			continue
+		}
+		indexes[i] = index
+		found[column] = true
+	}
+
+	missing := make([]string, 0, 5)
+	for column := range fields {
+		if !found[column] {
+			missing = append(missing, column)
+		}
+	}
+	sort.Strings(missing)
+
+	switch {
+	case len(unmapped) > 0 && len(missing) > 0:
+		return nil, fmt.Errorf("%v: unmapped columns [%s], missing columns [%s]", t,
+			strings.Join(unmapped, ", "), strings.Join(missing, ", "))
+	case len(unmapped) > 0:
+		return nil, fmt.Errorf("%v: unmapped columns [%s]", t, strings.Join(unmapped, ", "))
+	case len(missing) > 0:
+		return nil, fmt.Errorf("%v: missing columns [%s]", t, strings.Join(missing, ", "))
+	}
+	return indexes, nil
+}
+
+// fieldMap returns the cached column to field index map of struct type t.
+func fieldMap(t reflect.Type) map[string][]int {
+	if fields, ok := fieldMaps.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+	fields := make(map[string][]int)
+	addFields(fields, t, nil)
+	fieldMaps.Store(t, fields)
+	return fields
+}
+
+// addFields adds the tagged fields of t to fields, descending into embedded structs.
+func addFields(fields map[string][]int, t reflect.Type, parent []int) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		index := make([]int, len(parent)+1)
+		copy(index, parent)
+		index[len(parent)] = i
+
+		tag := field.Tag.Get("db")
+		switch {
+		case tag == "-":
+			continue
+		case tag == "" && field.Anonymous && field.Type.Kind() == reflect.Struct:
+			addFields(fields, field.Type, index)
+		case tag == "" || field.PkgPath != "":
+			// untagged or unexported
+		default:
+			if _, ok := fields[tag]; !ok {
+				fields[tag] = index
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+type scanBase struct {
+	ID      int    `db:"id"`
+	Created string `db:"created"`
+}
+
+type scanUser struct {
+	scanBase
+	Name     string         `db:"username"`
+	Email    sql.NullString `db:"email"`
+	Password string         `db:"-"`
+	Note     string
+	secret   string `db:"secret"`
+}
+
+func TestColumnIndexes(t *testing.T) {
+	userType := reflect.TypeOf(scanUser{})
+	tests := []struct {
+		name    string
+		t       reflect.Type
+		columns []string
+		indexes [][]int
+		err     string
+	}{
+		{
+			"all columns in any order",
+			userType,
+			[]string{"email", "id", "username", "created"},
+			[][]int{{2}, {0, 0}, {1}, {0, 1}},
+			"",
+		},
+		{
+			"unmapped column",
+			userType,
+			[]string{"id", "created", "username", "email", "password"},
+			nil,
+			"database.scanUser: unmapped columns [password]",
+		},
+		{
+			"missing columns sorted",
+			userType,
+			[]string{"username", "id"},
+			nil,
+			"database.scanUser: missing columns [created, email]",
+		},
+		{
+			"unmapped and missing",
+			userType,
+			[]string{"id", "created", "username", "secret", "Note"},
+			nil,
+			"database.scanUser: unmapped columns [secret, Note], missing columns [email]",
+		},
+		{
+			"not a struct",
+			reflect.TypeOf(0),
+			[]string{"id"},
+			nil,
+			"cannot scan into int: not a struct",
+		},
+		{
+			"nil type",
+			nil,
+			[]string{"id"},
+			nil,
+			"cannot scan into <nil>: not a struct",
+		},
+	}
+	for _, test := range tests {
+		indexes, err := columnIndexes(test.t, test.columns)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(indexes, test.indexes) {
+			t.Errorf("%s: expected indexes %v, got %v", test.name, test.indexes, indexes)
+		}
+	}
+}
+
+func TestFieldMap(t *testing.T) {
+	fields := fieldMap(reflect.TypeOf(scanUser{}))
+	expected := map[string][]int{"id": {0, 0}, "created": {0, 1}, "username": {1}, "email": {2}}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %v, got %v", expected, fields)
+	}
+	if cached := fieldMap(reflect.TypeOf(scanUser{})); reflect.ValueOf(cached).Pointer() != reflect.ValueOf(fields).Pointer() {
+		t.Error("field map was not cached")
+	}
+}
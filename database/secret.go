@@ -0,0 +1,171 @@
+package database
+
+// A SecretProvider supplies the database password. Passwords are cleaned with
+// utils.CleanPassword unless the provider is marked Raw, which keeps strong passwords intact.
+// If DBConnection.SecretRefresh is set, the password is re-read at that interval
+// so that rotated credentials are used by new pool connections without a restart.
+//
+//  database.AppDb.Secret = &database.ExecSecret{Command: "vault", Args: []string{"read", "-field=password", "secret/appdb"}, Raw: true}
+//  database.AppDb.SecretRefresh = 5 * time.Minute
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// SecretProvider supplies a database password.
+type SecretProvider interface {
+	Secret() (string, error)
+}
+
+// FileSecret reads the password from a file.
+type FileSecret struct {
+	Path string // relative path of password file
+	Raw  bool   // skip utils.CleanPassword
+}
+
+// Secret reads the password file.
+func (s *FileSecret) Secret() (string, error) {
+	passBytes, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return "", err
+	}
+	return cleanSecret(string(passBytes), s.Raw), nil
+}
+
+// EnvSecret reads the password from an environment variable.
+type EnvSecret struct {
+	Name string // environment variable name
+	Raw  bool   // skip utils.CleanPassword
+}
+
+// Secret reads the environment variable. An unset variable is an error.
+func (s *EnvSecret) Secret() (string, error) {
+	strPassword, ok := os.LookupEnv(s.Name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", s.Name)
+	}
+	return cleanSecret(strPassword, s.Raw), nil
+}
+
+// ExecSecret runs a command and reads the password from its standard output.
+type ExecSecret struct {
+	Command string
+	Args    []string
+	Timeout time.Duration // default 10s
+	Raw     bool          // skip utils.CleanPassword
+}
+
+// Secret runs the command.
+func (s *ExecSecret) Secret() (string, error) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	out, err := osexec.CommandContext(ctx, s.Command, s.Args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("secret command %s failed: %v", s.Command, err)
+	}
+	return cleanSecret(string(out), s.Raw), nil
+}
+
+// cleanSecret strips the trailing line break of a raw password or cleans it.
+func cleanSecret(strPassword string, raw bool) string {
+	if raw {
+		return strings.TrimRight(strPassword, "\r\n")
+	}
+	return utils.CleanPassword(strPassword)
+}
+
+// secretProvider returns Secret, defaulting to the file at PasswordPath.
+func (dbConn *DBConnection) secretProvider() SecretProvider {
+	if dbConn.Secret != nil {
+		return dbConn.Secret
+	}
+	return &FileSecret{Path: dbConn.PasswordPath}
+}
+
+// connector builds each new pool connection with the current password.
+// The primary connector has hosts set and dials through the breaker.
+type connector struct {
+	dbConn      *DBConnection
+	strHost     string
+	hosts       []string // Host and FailoverHosts
+	hostIndex   int      // hosts index of the last host that answered
+	driver      driver.Driver
+	mutex       sync.Mutex
+	strPassword string
+	readTime    time.Time
+}
+
+// newConnector returns a connector to strHost for dbConn.Engine seeded with strPassword.
+func newConnector(dbConn *DBConnection, strHost, strPassword string) (*connector, error) {
+	// sql.Open does not connect, it only looks up the registered driver.
+	db, err := sql.Open(dbConn.Engine, "")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return &connector{
+		dbConn:      dbConn,
+		strHost:     strHost,
+		driver:      db.Driver(),
+		strPassword: strPassword,
+		readTime:    time.Now(),
+	}, nil
+}
+
+// Connect implements driver.Connector.
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.hosts != nil {
+		return c.connectPrimary(ctx)
+	}
+	return c.dial(ctx, c.strHost, false)
+}
+
+// Driver implements driver.Connector.
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// password returns the cached password, re-reading it once SecretRefresh has elapsed.
+// A failed re-read keeps the previous password. The provider runs outside the lock, since
+// ExecSecret may take seconds, and dials meanwhile use the previous password.
+func (c *connector) password() string {
+	c.mutex.Lock()
+	strPrevious := c.strPassword
+	refresh := c.dbConn.SecretRefresh
+	if refresh <= 0 || time.Since(c.readTime) < refresh {
+		c.mutex.Unlock()
+		return strPrevious
+	}
+	// claim the re-read so that one dial runs it
+	c.readTime = time.Now()
+	c.mutex.Unlock()
+
+	strPassword, err := c.dbConn.readDbPassword()
+	if err != nil {
+		utils.Warning.Println("database password refresh failed, keeping previous password", err.Error())
+		return strPrevious
+	}
+	c.mutex.Lock()
+	c.strPassword = strPassword
+	c.mutex.Unlock()
+	return strPassword
+}
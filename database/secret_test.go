@@ -0,0 +1,124 @@
+package database
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleanSecret(t *testing.T) {
+	tests := []struct {
+		password string
+		raw      bool
+		cleaned  string
+	}{
+		{"s3cret\n", false, "s3cret"},
+		{" p@ss w:rd!-_\r\n", false, "psswrd!-_"},
+		{"p@ss w:rd#%?\r\n", true, "p@ss w:rd#%?"},
+		{" keep spaces \n\n", true, " keep spaces "},
+		{"", true, ""},
+	}
+	for _, test := range tests {
+		if cleaned := cleanSecret(test.password, test.raw); cleaned != test.cleaned {
+			t.Errorf("cleanSecret(%q, %v) expected %q, got %q", test.password, test.raw, test.cleaned, cleaned)
+		}
+	}
+}
+
+func TestSecretProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	strPath := filepath.Join(dir, "password")
+	if err = ioutil.WriteFile(strPath, []byte("p@ss:w0rd\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("DBTEST_SECRET", "env p@ss\n")
+	defer os.Unsetenv("DBTEST_SECRET")
+	os.Unsetenv("DBTEST_SECRET_UNSET")
+
+	tests := []struct {
+		name     string
+		provider SecretProvider
+		password string
+		err      string
+	}{
+		{"file", &FileSecret{Path: strPath}, "pssw0rd", ""},
+		{"raw file", &FileSecret{Path: strPath, Raw: true}, "p@ss:w0rd", ""},
+		{"missing file", &FileSecret{Path: filepath.Join(dir, "missing")}, "", "no such file"},
+		{"env", &EnvSecret{Name: "DBTEST_SECRET"}, "envpss", ""},
+		{"raw env", &EnvSecret{Name: "DBTEST_SECRET", Raw: true}, "env p@ss", ""},
+		{"unset env", &EnvSecret{Name: "DBTEST_SECRET_UNSET"}, "", "environment variable DBTEST_SECRET_UNSET is not set"},
+		{"exec", &ExecSecret{Command: "echo", Args: []string{"p@ss:w0rd"}}, "pssw0rd", ""},
+		{"raw exec", &ExecSecret{Command: "echo", Args: []string{"p@ss:w0rd"}, Raw: true}, "p@ss:w0rd", ""},
+		{"failed exec", &ExecSecret{Command: "false"}, "", "secret command false failed"},
+		{"exec timeout", &ExecSecret{Command: "sleep", Args: []string{"5"}, Timeout: 50 * time.Millisecond}, "", "secret command sleep failed"},
+	}
+	for _, test := range tests {
+		startTime := time.Now()
+		strPassword, err := test.provider.Secret()
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		case strPassword != test.password:
+			t.Errorf("%s: expected %q, got %q", test.name, test.password, strPassword)
+		}
+		if elapsed := time.Since(startTime); elapsed > 2*time.Second {
+			t.Errorf("%s: took %v", test.name, elapsed)
+		}
+	}
+}
+
+// slowSecret is a provider that answers once release is closed.
+type slowSecret struct {
+	started chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func (s *slowSecret) Secret() (string, error) {
+	close(s.started)
+	<-s.release
+	return "rotated", s.err
+}
+
+func TestPasswordRefresh(t *testing.T) {
+	for _, errSecret := range []error{nil, errors.New("vault sealed")} {
+		secret := &slowSecret{started: make(chan struct{}), release: make(chan struct{}), err: errSecret}
+		dbConn := &DBConnection{Secret: secret, SecretRefresh: time.Minute}
+		c := &connector{dbConn: dbConn, strPassword: "initial", readTime: time.Now().Add(-time.Minute)}
+
+		refreshed := make(chan string)
+		go func() { refreshed <- c.password() }()
+		<-secret.started
+
+		// other dials go ahead with the cached password while the provider runs
+		done := make(chan string)
+		go func() { done <- c.password() }()
+		select {
+		case strPassword := <-done:
+			if strPassword != "initial" {
+				t.Errorf("expected the cached password during the refresh, got %q", strPassword)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("password blocked while the provider was running")
+		}
+
+		close(secret.release)
+		expected := "rotated"
+		if errSecret != nil {
+			expected = "initial"
+		}
+		if strPassword := <-refreshed; strPassword != expected || c.password() != expected {
+			t.Errorf("provider error %v: expected %q, got %q", errSecret, expected, strPassword)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package database_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/knousere/web-service-commons/database"
+	"github.com/knousere/web-service-commons/database/dbtest"
+	"github.com/knousere/web-service-commons/go-sql-driver/mysql"
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// member has a field of every nullable type the helpers are expected to scan.
+type member struct {
+	ID      int            `db:"user_id"`
+	Name    string         `db:"username"`
+	Email   sql.NullString `db:"email"`
+	Score   sql.NullInt64  `db:"score"`
+	Created mysql.NullTime `db:"created"`
+}
+
+// memberID is the key of a member.
+type memberID struct {
+	ID int `db:"user_id"`
+}
+
+func TestSelectGet(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	columns := []string{"user_id", "username", "email", "score", "created"}
+	fake := dbtest.New()
+	fake.OnCall("sp_team_members").Rows(columns,
+		[]interface{}{7, "ann", "ann@example.com", 42, created},
+		[]interface{}{8, "bob", nil, nil, nil},
+	)
+	fake.OnCall("sp_member_get").Rows(columns)
+	db := fake.DB()
+
+	expected := []member{
+		{7, "ann", sql.NullString{String: "ann@example.com", Valid: true}, sql.NullInt64{Int64: 42, Valid: true}, mysql.NullTime{Time: created, Valid: true}},
+		{8, "bob", sql.NullString{}, sql.NullInt64{}, mysql.NullTime{}},
+	}
+	members, err := database.Select[member](db, "CALL sp_team_members(?)", 3)
+	if err != nil || !reflect.DeepEqual(members, expected) {
+		t.Errorf("Select: expected %v, got %v, %v", expected, members, err)
+	}
+
+	// Get reads the first row only
+	first, err := database.Get[member](db, "CALL sp_team_members(?)", 3)
+	if err != nil || !reflect.DeepEqual(first, expected[0]) {
+		t.Errorf("Get: expected %v, got %v, %v", expected[0], first, err)
+	}
+
+	// no rows is an empty slice for Select and sql.ErrNoRows for Get
+	members, err = database.Select[member](db, "CALL sp_member_get(?)", 9)
+	if err != nil || members == nil || len(members) != 0 {
+		t.Errorf("Select without rows: expected an empty slice, got %#v, %v", members, err)
+	}
+	if _, err = database.Get[member](db, "CALL sp_member_get(?)", 9); err != sql.ErrNoRows {
+		t.Errorf("Get without rows: expected sql.ErrNoRows, got %v", err)
+	}
+	if stats := db.Stats().Wrappers; stats["Select"].Calls != 2 || stats["Get"].Calls != 2 || stats["Get"].Errors != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestGetTrace(t *testing.T) {
+	var buf bytes.Buffer
+	oldTrace := utils.Trace
+	utils.Trace = log.New(utils.MyWriter{Writer: &buf}, "TRACE: ", 0)
+	defer func() { utils.Trace = oldTrace }()
+
+	fake := dbtest.New()
+	fake.OnCall("sp_member_get").Rows([]string{"user_id"})
+	ctx := database.WithTrace(context.Background(), "req-7")
+	if _, err := database.GetContext[memberID](ctx, fake.DB(), "CALL sp_member_get(?)", 9); err != sql.ErrNoRows {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+	expected := "TRACE: request=req-7 CALL sp_member_get(?), 9\nTRACE: record not found request=req-7 CALL sp_member_get(?), 9\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestSelectReplica(t *testing.T) {
+	primary := dbtest.New()
+	primary.OnCall("sp_member_get").Rows([]string{"user_id"}, []interface{}{1})
+	replica := dbtest.New()
+	replica.OnCall("sp_member_get").Rows([]string{"user_id"}, []interface{}{2})
+	db := primary.DB()
+	database.SetReplicas(db, replica.DB())
+
+	if got, err := database.Get[memberID](db, "CALL sp_member_get(?)", 9); err != nil || got.ID != 2 {
+		t.Errorf("Get: expected a read from the replica, got %v, %v", got, err)
+	}
+	if got, err := database.Select[memberID](db, "CALL sp_member_get(?)", 9); err != nil || len(got) != 1 || got[0].ID != 2 {
+		t.Errorf("Select: expected a read from the replica, got %v, %v", got, err)
+	}
+	ctx := database.WithPrimary(context.Background())
+	if got, err := database.GetContext[memberID](ctx, db, "CALL sp_member_get(?)", 9); err != nil || got.ID != 1 {
+		t.Errorf("WithPrimary: expected a read from the primary, got %v, %v", got, err)
+	}
+}
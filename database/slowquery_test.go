@@ -0,0 +1,51 @@
+package database_test
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/knousere/web-service-commons/database"
+	"github.com/knousere/web-service-commons/database/dbtest"
+	"github.com/knousere/web-service-commons/utils"
+)
+
+func TestSlowQueryCaller(t *testing.T) {
+	var buffer bytes.Buffer
+	defer func(warning *log.Logger) { utils.Warning = warning }(utils.Warning)
+	utils.Warning = log.New(&buffer, "", log.Lshortfile)
+
+	fake := dbtest.New()
+	fake.OnCall("sp_user_login").Value(7)
+	fake.OnCall("sp_user_touch").Rows([]string{"affected_count", "deadlock"}, []interface{}{1, false})
+	db := fake.DB()
+	db.SlowQuery = 1 // every call is slow
+
+	// the line is the caller's, however deep the wrapper
+	calls := []func(){
+		func() { db.InsertRow("CALL sp_user_login(?, ?)", "ann", database.Sensitive("s3cret")) },
+		func() { db.GetRecordCount("CALL sp_user_login(?, ?)", "ann", database.Sensitive("s3cret")) },
+		func() {
+			db.WithTx(func(tx *database.Tx) error {
+				tx.UpdateRowsWithDeadlock("CALL sp_user_touch(?)", 7)
+				return nil
+			})
+		},
+	}
+	for i, call := range calls {
+		buffer.Reset()
+		call()
+		if line := buffer.String(); !strings.HasPrefix(line, "slowquery_test.go:") || strings.Count(line, "slow query") != 1 {
+			t.Errorf("call %d: expected the slow query logged at the caller, got %q", i, line)
+		}
+		if strings.Contains(buffer.String(), "s3cret") {
+			t.Errorf("call %d: sensitive argument logged: %q", i, buffer.String())
+		}
+	}
+
+	// the server still gets the value
+	if args := fake.CallsTo("sp_user_login")[0].Args; args[1] != "s3cret" {
+		t.Errorf("expected the sensitive argument to reach the server, got %v", args)
+	}
+}
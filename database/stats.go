@@ -0,0 +1,151 @@
+package database
+
+// Stats combines the sql.DBStats of the connection pool with counters kept by the wrapper functions.
+// A rising WaitCount or WaitDuration means that the pool is running dry.
+// Pool settings may be read from the parameter file, e.g. for ReadPoolParams("appdb"):
+//
+//  appdb_max_open: 50
+//  appdb_max_idle: 10
+//  appdb_conn_max_lifetime: 30m
+//  appdb_conn_max_idle_time: 5m
+
+import (
+	"database/sql"
+	"sync/atomic"
+	"time"
+
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// WrapperStats counts the calls of one wrapper function.
+// DBConnection and Tx calls of the same wrapper are counted together.
+type WrapperStats struct {
+	Calls     int64
+	Errors    int64
+	Deadlocks int64
+	Duration  time.Duration // total latency
+	Max       time.Duration // max latency
+}
+
+// Stats is a snapshot of pool and wrapper statistics.
+type Stats struct {
+	sql.DBStats
+	Wrappers map[string]WrapperStats // keyed by wrapper name, e.g. GetRows
+}
+
+// wrapperCounters is the live version of WrapperStats.
+type wrapperCounters struct {
+	calls     atomic.Int64
+	errors    atomic.Int64
+	deadlocks atomic.Int64
+	duration  atomic.Int64
+	max       atomic.Int64
+}
+
+// Stats returns pool and wrapper statistics.
+func (dbConn *DBConnection) Stats() Stats {
+	var stats Stats
+	if dbConn.db != nil {
+		stats.DBStats = dbConn.db.Stats()
+	}
+	stats.Wrappers = make(map[string]WrapperStats)
+	dbConn.counters.Range(func(key, value interface{}) bool {
+		counters := value.(*wrapperCounters)
+		stats.Wrappers[key.(string)] = WrapperStats{
+			Calls:     counters.calls.Load(),
+			Errors:    counters.errors.Load(),
+			Deadlocks: counters.deadlocks.Load(),
+			Duration:  time.Duration(counters.duration.Load()),
+			Max:       time.Duration(counters.max.Load()),
+		}
+		return true
+	})
+	return stats
+}
+
+// wrapperCounters returns the counters of the named wrapper.
+func (dbConn *DBConnection) wrapperCounters(name string) *wrapperCounters {
+	if counters, ok := dbConn.counters.Load(name); ok {
+		return counters.(*wrapperCounters)
+	}
+	counters, _ := dbConn.counters.LoadOrStore(name, &wrapperCounters{})
+	return counters.(*wrapperCounters)
+}
+
+// count records one call of the named wrapper, its latency and its outcome.
+func (dbConn *DBConnection) count(name string, elapsed time.Duration, err error) {
+	counters := dbConn.wrapperCounters(name)
+	counters.add(elapsed)
+	if err != nil {
+		counters.errors.Add(1)
+		if IsDeadlock(err) {
+			counters.deadlocks.Add(1)
+		}
+	}
+}
+
+// countDeadlock records one call of a wrapper that reports deadlock as a flag.
+func (dbConn *DBConnection) countDeadlock(name string, elapsed time.Duration, bDeadlock bool) {
+	counters := dbConn.wrapperCounters(name)
+	counters.add(elapsed)
+	if bDeadlock {
+		counters.deadlocks.Add(1)
+	}
+}
+
+// add counts one call and its latency.
+func (counters *wrapperCounters) add(elapsed time.Duration) {
+	counters.calls.Add(1)
+	counters.duration.Add(int64(elapsed))
+	for {
+		max := counters.max.Load()
+		if int64(elapsed) <= max || counters.max.CompareAndSwap(max, int64(elapsed)) {
+			return
+		}
+	}
+}
+
+// setPool applies the pool settings to db. Zero values keep the database/sql defaults.
+func (dbConn *DBConnection) setPool(db *sql.DB) {
+	if dbConn.MaxOpen != 0 {
+		db.SetMaxOpenConns(dbConn.MaxOpen)
+	}
+	if dbConn.MaxIdle != 0 {
+		db.SetMaxIdleConns(dbConn.MaxIdle)
+	}
+	if dbConn.ConnMaxLifetime != 0 {
+		db.SetConnMaxLifetime(dbConn.ConnMaxLifetime)
+	}
+	if dbConn.ConnMaxIdleTime != 0 {
+		db.SetConnMaxIdleTime(dbConn.ConnMaxIdleTime)
+	}
+}
+
+// ReadPoolParams sets the pool settings from utils.Params keys beginning with strPrefix.
+// The keys are <prefix>_max_open, <prefix>_max_idle, <prefix>_conn_max_lifetime
+// and <prefix>_conn_max_idle_time. Absent keys leave the settings unchanged.
+// Call this before Open.
+func (dbConn *DBConnection) ReadPoolParams(strPrefix string) {
+	if strValue, ok := utils.Params[strPrefix+"_max_open"]; ok {
+		dbConn.MaxOpen = utils.ParamAsInt(strValue)
+	}
+	if strValue, ok := utils.Params[strPrefix+"_max_idle"]; ok {
+		dbConn.MaxIdle = utils.ParamAsInt(strValue)
+	}
+	readDurationParam(strPrefix+"_conn_max_lifetime", &dbConn.ConnMaxLifetime)
+	readDurationParam(strPrefix+"_conn_max_idle_time", &dbConn.ConnMaxIdleTime)
+}
+
+// readDurationParam parses a duration such as 30m from utils.Params into d.
+func readDurationParam(strKey string, d *time.Duration) {
+	strValue, ok := utils.Params[strKey]
+	if !ok {
+		return
+	}
+	value, err := time.ParseDuration(strValue)
+	if err != nil {
+		utils.Warning.Println("invalid duration param", strKey, err.Error())
+		return
+	}
+	*d = value
+}
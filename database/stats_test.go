@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/knousere/web-service-commons/utils"
+)
+
+func TestSetPool(t *testing.T) {
+	// zero values keep the database/sql defaults: no open limit and 2 idle connections
+	tests := []struct {
+		maxOpen, maxIdle int
+		open, idle       int
+	}{
+		{0, 0, 0, 2},
+		{5, 1, 5, 1},
+	}
+	for _, test := range tests {
+		dbConn := &DBConnection{MaxOpen: test.maxOpen, MaxIdle: test.maxIdle}
+		dbConn.OpenDB(sql.OpenDB(&loadDriver{}))
+
+		var conns []*sql.Conn
+		for i := 0; i < 4; i++ {
+			conn, err := dbConn.Conn(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+		stats := dbConn.Stats()
+		if stats.MaxOpenConnections != test.open || stats.Idle != test.idle {
+			t.Errorf("MaxOpen %d, MaxIdle %d: expected max open %d and %d idle, got %d and %d",
+				test.maxOpen, test.maxIdle, test.open, test.idle, stats.MaxOpenConnections, stats.Idle)
+		}
+		dbConn.db.Close()
+	}
+}
+
+func TestReadPoolParams(t *testing.T) {
+	defer func(params map[string]string) { utils.Params = params }(utils.Params)
+	utils.Params = map[string]string{
+		"appdb_max_open":           "50",
+		"appdb_conn_max_lifetime":  "30m",
+		"appdb_conn_max_idle_time": "five minutes",
+		"logdb_max_idle":           "3",
+	}
+
+	dbConn := &DBConnection{MaxIdle: 10, ConnMaxIdleTime: time.Minute}
+	dbConn.ReadPoolParams("appdb")
+	if dbConn.MaxOpen != 50 || dbConn.ConnMaxLifetime != 30*time.Minute {
+		t.Errorf("expected the params, got max open %d, lifetime %v", dbConn.MaxOpen, dbConn.ConnMaxLifetime)
+	}
+	// absent and invalid params leave the settings unchanged
+	if dbConn.MaxIdle != 10 || dbConn.ConnMaxIdleTime != time.Minute {
+		t.Errorf("expected the previous settings, got max idle %d, idle time %v", dbConn.MaxIdle, dbConn.ConnMaxIdleTime)
+	}
+
+	dbConn = &DBConnection{}
+	dbConn.ReadPoolParams("logdb")
+	if dbConn.MaxIdle != 3 || dbConn.MaxOpen != 0 || dbConn.ConnMaxLifetime != 0 || dbConn.ConnMaxIdleTime != 0 {
+		t.Errorf("expected only max idle set, got %+v", dbConn)
+	}
+}
+
+func TestStatsCounters(t *testing.T) {
+	dbConn := &DBConnection{}
+	errQuery := errors.New("query failed")
+	ms := time.Millisecond
+
+	dbConn.record("GetRows", time.Now().Add(-2*ms), nil, "CALL sp_item_list()")
+	dbConn.record("GetRows", time.Now().Add(-5*ms), errQuery, "CALL sp_item_list()")
+	dbConn.recordDeadlock("UpdateRowsWithDeadlock", time.Now(), true, nil, "CALL sp_vote(?)", 7)
+	dbConn.recordDeadlock("UpdateRowsWithDeadlock", time.Now(), false, nil, "CALL sp_vote(?)", 7)
+
+	stats := dbConn.Stats()
+	if len(stats.Wrappers) != 2 {
+		t.Fatalf("expected 2 wrappers, got %v", stats.Wrappers)
+	}
+	getRows := stats.Wrappers["GetRows"]
+	if getRows.Calls != 2 || getRows.Errors != 1 || getRows.Deadlocks != 0 {
+		t.Errorf("GetRows: unexpected counts %+v", getRows)
+	}
+	if getRows.Max < 5*ms || getRows.Duration < 7*ms || getRows.Duration < getRows.Max {
+		t.Errorf("GetRows: unexpected latency %+v", getRows)
+	}
+	if deadlock := stats.Wrappers["UpdateRowsWithDeadlock"]; deadlock.Calls != 2 || deadlock.Deadlocks != 1 || deadlock.Errors != 0 {
+		t.Errorf("UpdateRowsWithDeadlock: unexpected counts %+v", deadlock)
+	}
+	if stats.OpenConnections != 0 {
+		t.Errorf("expected no pool stats without a pool, got %+v", stats.DBStats)
+	}
+}
@@ -0,0 +1,64 @@
+package database
+
+// Trace is normally switched on for the whole process with utils.SetTrace, which logs every
+// wrapper call of every request. WithTrace switches it on for the calls made with one context
+// instead, and tags their trace lines with a request ID so that they can be picked out of the log.
+// Only the Context variants of the wrappers, and the Tx wrappers of WithTxContext, see the context.
+// Trace lines go to the writer of utils.Trace, so that stream must not be LogNil. A LogTrace
+// stream drops lines while the global trace flag is off, except those of a context traced by WithTrace.
+//
+//  ctx := r.Context()
+//  if strRequestID := r.Header.Get("X-Trace-Request"); strRequestID != "" && bStaff {
+//  	ctx = database.WithTrace(ctx, strRequestID)
+//  }
+//  rows, err := database.AppDb.GetRowsContext(ctx, "CALL sp_cart_list(?)", intUserID)
+//
+// A JWT claim works the same way, e.g. WithTrace(ctx, claims.Id) for a token that carries a debug claim.
+
+import (
+	"context"
+	"log"
+
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// traceKey is the context key set by WithTrace.
+type traceKey struct{}
+
+// WithTrace returns a context that traces the wrapper calls made with it, tagged with strRequestID.
+func WithTrace(ctx context.Context, strRequestID string) context.Context {
+	return context.WithValue(ctx, traceKey{}, strRequestID)
+}
+
+// TraceID returns the request ID set by WithTrace and whether ctx is traced.
+func TraceID(ctx context.Context) (string, bool) {
+	strRequestID, ok := ctx.Value(traceKey{}).(string)
+	return strRequestID, ok
+}
+
+// traceOn reports whether a call made with ctx is traced, for the request or globally.
+func traceOn(ctx context.Context) bool {
+	if _, ok := TraceID(ctx); ok {
+		return true
+	}
+	return utils.GetTrace() == 1
+}
+
+// traceLogger returns the logger for the trace lines of a call made with ctx. The lines of a context
+// traced by WithTrace bypass the global trace flag that a LogTrace stream checks.
+func traceLogger(ctx context.Context) *log.Logger {
+	if _, ok := TraceID(ctx); ok {
+		if w, ok := utils.Trace.Writer().(utils.MyWriter); ok {
+			return log.New(w.Writer, utils.Trace.Prefix(), utils.Trace.Flags())
+		}
+	}
+	return utils.Trace
+}
+
+// requestTag prefixes trace lines with the request ID, if there is one.
+func requestTag(strRequestID string) string {
+	if strRequestID == "" {
+		return ""
+	}
+	return "request=" + strRequestID + " "
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"testing"
+
+	"github.com/knousere/web-service-commons/utils"
+)
+
+func TestTraceContext(t *testing.T) {
+	// a LogTrace stream, which drops lines while the global trace flag is off
+	var buf bytes.Buffer
+	oldTrace, oldFlag := utils.Trace, utils.GetTrace()
+	utils.Trace = log.New(utils.MyWriter{Writer: &buf}, "TRACE: ", 0)
+	defer func() {
+		utils.Trace = oldTrace
+		utils.SetTrace(oldFlag)
+	}()
+	utils.SetTrace(0)
+
+	traced := WithTrace(context.Background(), "req-7")
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		bTrace int
+		line   string
+	}{
+		{"traced context", traced, 0, "TRACE: request=req-7 CALL sp_x(?), 7\n"},
+		{"untraced context", context.Background(), 0, ""},
+		{"global trace", context.Background(), 1, "TRACE: CALL sp_x(?), 7\n"},
+		{"traced context and global trace", traced, 1, "TRACE: request=req-7 CALL sp_x(?), 7\n"},
+	}
+	for _, test := range tests {
+		buf.Reset()
+		utils.SetTrace(test.bTrace)
+		strArgs := doTrace(test.ctx, "CALL sp_x(?)", 7)
+		if buf.String() != test.line {
+			t.Errorf("%s: expected %q, got %q", test.name, test.line, buf.String())
+		}
+		if bTraced := strArgs != ""; bTraced != (test.line != "") {
+			t.Errorf("%s: doTrace returned %q", test.name, strArgs)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package database
+
+// Tx exposes the dbcall.go family of wrapper functions inside a transaction.
+// WithTx runs a function inside a transaction and retries the whole function
+// when mysql reports a deadlock or a lock wait timeout. The function must therefore
+// be safe to run more than once, i.e. it should not have side effects outside the database.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/knousere/web-service-commons/go-sql-driver/mysql"
+	"github.com/knousere/web-service-commons/utils"
+)
+
+// These are the mysql error numbers that cause WithTx to retry.
+const (
+	errLockWaitTimeout uint16 = 1205
+	errDeadlock        uint16 = 1213
+)
+
+// These are the retry defaults used when DBConnection leaves them unset.
+const (
+	defaultTxMaxRetries = 3
+	defaultTxBackoff    = 50 * time.Millisecond
+	maxTxBackoff        = 5 * time.Second // doubling stops here unless TxBackoff is larger
+)
+
+// ErrDeadlock is returned when a stored procedure called through
+// Tx.UpdateRowsWithDeadlock reports a deadlock and the retries are exhausted.
+var ErrDeadlock = errors.New("stored procedure reported a deadlock")
+
+// Tx is a container for a transaction in progress.
+type Tx struct {
+	tx        *sql.Tx
+	ctx       context.Context
+	dbConn    *DBConnection
+	bDeadlock bool  // a stored procedure reported a deadlock
+	err       error // first error of a wrapper that has no error result
+}
+
+// WithTx runs fn inside a transaction. The transaction is committed if fn returns nil
+// and rolled back otherwise. The whole of fn is retried with backoff on deadlock (1213)
+// and lock wait timeout (1205).
+func (dbConn *DBConnection) WithTx(fn func(tx *Tx) error) error {
+	return dbConn.WithTxContext(context.Background(), fn)
+}
+
+// WithTxContext is WithTx bounded by ctx. The transaction is rolled back if ctx is done
+// and no further retries are attempted.
+func (dbConn *DBConnection) WithTxContext(ctx context.Context, fn func(tx *Tx) error) error {
+	maxRetries := dbConn.TxMaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultTxMaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := dbConn.runTx(ctx, fn)
+		if err == nil || !IsDeadlock(err) {
+			return err
+		}
+		if attempt >= maxRetries {
+			utils.Warning.Printf("transaction failed after %d retries: %s", attempt, err.Error())
+			return err
+		}
+		backoff := dbConn.txBackoff(attempt)
+		utils.Trace.Printf("transaction retry %d in %v: %s", attempt+1, backoff, err.Error())
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// runTx runs one attempt of fn inside a transaction.
+func (dbConn *DBConnection) runTx(ctx context.Context, fn func(tx *Tx) error) (err error) {
+	sqlTx, err := dbConn.db.BeginTx(ctx, nil)
+	if err != nil {
+		utils.Warning.Println("database.WithTx failed on Begin", err.Error())
+		return err
+	}
+	tx := &Tx{
+		tx:     sqlTx,
+		ctx:    ctx,
+		dbConn: dbConn,
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn(tx)
+	if err == nil && tx.err != nil {
+		// mysql has rolled back on a deadlock already, so nothing after it may be committed
+		err = tx.err
+	}
+	if err == nil && tx.bDeadlock {
+		err = ErrDeadlock
+	}
+	if err != nil {
+		if rbErr := sqlTx.Rollback(); rbErr != nil && rbErr != sql.ErrTxDone {
+			utils.Warning.Println("database.WithTx failed on Rollback", rbErr.Error())
+		}
+		return err
+	}
+
+	err = sqlTx.Commit()
+	if err != nil {
+		utils.Warning.Println("database.WithTx failed on Commit", err.Error())
+		return err
+	}
+	dbConn.markWrite()
+	return nil
+}
+
+// txBackoff returns the wait before the next retry.
+// The base backoff doubles with each attempt up to maxTxBackoff and is jittered
+// to keep competing transactions from colliding again.
+func (dbConn *DBConnection) txBackoff(attempt int) time.Duration {
+	backoff := dbConn.TxBackoff
+	if backoff <= 0 {
+		backoff = defaultTxBackoff
+	}
+	ceiling := maxTxBackoff
+	if backoff > ceiling {
+		ceiling = backoff
+	}
+	// doubling one step at a time cannot overflow however large attempt gets
+	for i := 0; i < attempt && backoff < ceiling; i++ {
+		backoff *= 2
+	}
+	if backoff > ceiling {
+		backoff = ceiling
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// IsDeadlock returns true if err is a mysql deadlock or lock wait timeout,
+// or a deadlock reported by a stored procedure, including when err wraps one.
+func IsDeadlock(err error) bool {
+	if errors.Is(err, ErrDeadlock) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == errDeadlock || mysqlErr.Number == errLockWaitTimeout
+	}
+	return false
+}
+
+// Exec is a wrapper for the sql.Tx.Exec function.
+// WARNING: do no use this for a stored procedure.
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	result, err := exec(ctx, tx.tx, query, args...)
+	tx.dbConn.record("Exec", startTime, err, query, args...)
+	return result, err
+}
+
+// GetRows simply gets rows within the transaction. Empty is OK.
+// Like DBConnection.GetRowsContext it is bounded by the transaction's context only.
+func (tx *Tx) GetRows(query string, args ...interface{}) (*sql.Rows, error) {
+	startTime := time.Now()
+	rows, err := getRows(tx.ctx, tx.tx, query, args...)
+	tx.dbConn.record("GetRows", startTime, err, query, args...)
+	return rows, err
+}
+
+// GetOneRow is functionally identical to QueryRow() within the transaction.
+// Like DBConnection.GetOneRowContext it is bounded by the transaction's context only.
+func (tx *Tx) GetOneRow(query string, args ...interface{}) *sql.Row {
+	startTime := time.Now()
+	row := getOneRow(tx.ctx, tx.tx, query, args...)
+	tx.dbConn.record("GetOneRow", startTime, nil, query, args...)
+	return row
+}
+
+// GetPositiveInt gets one row that consists of only a positive integer.
+func (tx *Tx) GetPositiveInt(query string, args ...interface{}) (int, error) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	intValue, err := getPositiveInt(ctx, tx.tx, query, args...)
+	tx.dbConn.record("GetPositiveInt", startTime, err, query, args...)
+	return intValue, err
+}
+
+// GetPositiveIntDefault gets one row that consists of only a positive integer.
+// It returns default value rather than ErrNoRows.
+func (tx *Tx) GetPositiveIntDefault(intDefault int, query string, args ...interface{}) (int, error) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	intValue, err := getPositiveIntDefault(ctx, tx.tx, intDefault, query, args...)
+	tx.dbConn.record("GetPositiveIntDefault", startTime, err, query, args...)
+	return intValue, err
+}
+
+// GetRecordID returns record Id or 0 rather than ErrNoRows.
+func (tx *Tx) GetRecordID(query string, args ...interface{}) (int, error) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	id, err := getRecordID(ctx, tx.tx, query, args...)
+	tx.dbConn.record("GetRecordID", startTime, err, query, args...)
+	return id, err
+}
+
+// GetRecordCount returns record count. ErrNoRows is an error.
+func (tx *Tx) GetRecordCount(query string, args ...interface{}) (int, error) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	count, err := getRecordCount(ctx, tx.tx, query, args...)
+	tx.dbConn.record("GetRecordCount", startTime, err, query, args...)
+	return count, err
+}
+
+// GetOneString returns one row that consists of only a string value.
+func (tx *Tx) GetOneString(query string, args ...interface{}) (string, error) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	strValue, err := getOneString(ctx, tx.tx, query, args...)
+	tx.dbConn.record("GetOneString", startTime, err, query, args...)
+	return strValue, err
+}
+
+// InsertRow inserts a row and returns the id of the new record.
+func (tx *Tx) InsertRow(query string, args ...interface{}) (int, error) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	id, err := insertRow(ctx, tx.tx, query, args...)
+	tx.dbConn.record("InsertRow", startTime, err, query, args...)
+	return id, err
+}
+
+// InsertRowResult inserts a row and returns the result code and the id of the new record.
+func (tx *Tx) InsertRowResult(query string, args ...interface{}) (int, int, error) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	result, id, err := insertRowResult(ctx, tx.tx, query, args...)
+	tx.dbConn.record("InsertRowResult", startTime, err, query, args...)
+	return result, id, err
+}
+
+// UpdateRows updates row(s) and returns affected count.
+func (tx *Tx) UpdateRows(query string, args ...interface{}) (int, error) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	affectedCount, err := updateRows(ctx, tx.tx, query, args...)
+	tx.dbConn.record("UpdateRows", startTime, err, query, args...)
+	return affectedCount, err
+}
+
+// UpdateRowsWithDeadlock updates row(s) and returns count of affected rows and deadlock flag.
+// A reported deadlock also causes WithTx to roll back and retry the transaction.
+// Any other error is returned by WithTx, which rolls back instead of committing.
+func (tx *Tx) UpdateRowsWithDeadlock(query string, args ...interface{}) (int, bool) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	affectedCount, bDeadlock, err := updateRowsWithDeadlock(ctx, tx.tx, query, args...)
+	tx.dbConn.recordDeadlock("UpdateRowsWithDeadlock", startTime, bDeadlock, err, query, args...)
+	if err != nil && tx.err == nil {
+		tx.err = err
+	}
+	if bDeadlock {
+		tx.bDeadlock = true
+	}
+	return affectedCount, bDeadlock
+}
+
+// UpdateRowsResult updates row(s) and returns result code and affected count.
+func (tx *Tx) UpdateRowsResult(query string, args ...interface{}) (int, int, error) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	result, affectedCount, err := updateRowsResult(ctx, tx.tx, query, args...)
+	tx.dbConn.record("UpdateRowsResult", startTime, err, query, args...)
+	return result, affectedCount, err
+}
+
+// DeleteRows deletes row(s) and returns affected count.
+func (tx *Tx) DeleteRows(query string, args ...interface{}) (int, error) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	affectedCount, err := deleteRows(ctx, tx.tx, query, args...)
+	tx.dbConn.record("DeleteRows", startTime, err, query, args...)
+	return affectedCount, err
+}
+
+// DeleteRowsResult deletes row(s) and returns result code as well as an affected count.
+func (tx *Tx) DeleteRowsResult(query string, args ...interface{}) (int, int, error) {
+	ctx, cancel := tx.dbConn.withTimeout(tx.ctx)
+	defer cancel()
+	startTime := time.Now()
+	result, affectedCount, err := deleteRowsResult(ctx, tx.tx, query, args...)
+	tx.dbConn.record("DeleteRowsResult", startTime, err, query, args...)
+	return result, affectedCount, err
+}
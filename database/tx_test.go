@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/knousere/web-service-commons/go-sql-driver/mysql"
+)
+
+func TestTxBackoff(t *testing.T) {
+	tests := []struct {
+		base    time.Duration
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{0, 0, defaultTxBackoff / 2, defaultTxBackoff},
+		{0, 2, 2 * defaultTxBackoff, 4 * defaultTxBackoff},
+		{0, 70, maxTxBackoff / 2, maxTxBackoff},
+		{0, 1 << 20, maxTxBackoff / 2, maxTxBackoff},
+		{time.Second, 3, maxTxBackoff / 2, maxTxBackoff},
+		{time.Minute, 10, time.Minute / 2, time.Minute},
+	}
+	for _, test := range tests {
+		dbConn := &DBConnection{TxBackoff: test.base}
+		for i := 0; i < 100; i++ {
+			if backoff := dbConn.txBackoff(test.attempt); backoff < test.min || backoff > test.max {
+				t.Fatalf("txBackoff(%d) with base %v = %v, want between %v and %v",
+					test.attempt, test.base, backoff, test.min, test.max)
+			}
+		}
+	}
+}
+
+func TestIsDeadlock(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: errDeadlock, Message: "Deadlock found"}
+	lockWait := &mysql.MySQLError{Number: errLockWaitTimeout, Message: "Lock wait timeout exceeded"}
+	duplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("other"), false},
+		{ErrDeadlock, true},
+		{deadlock, true},
+		{lockWait, true},
+		{duplicate, false},
+		{fmt.Errorf("bulk insert chunk 2 of 3: %w", deadlock), true},
+		{fmt.Errorf("step: %w", fmt.Errorf("call: %w", ErrDeadlock)), true},
+		{fmt.Errorf("bulk insert chunk 2 of 3: %w", duplicate), false},
+	}
+	for _, test := range tests {
+		if got := IsDeadlock(test.err); got != test.want {
+			t.Errorf("IsDeadlock(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestWithTxCommitFailure(t *testing.T) {
+	drv := &loadDriver{commitErr: sql.ErrConnDone}
+	dbConn := &DBConnection{StickyPrimary: time.Minute}
+	dbConn.OpenDB(sql.OpenDB(drv))
+	defer dbConn.Close()
+
+	if err := dbConn.WithTx(func(tx *Tx) error { return nil }); err != sql.ErrConnDone {
+		t.Fatalf("expected the commit error, got %v", err)
+	}
+	if dbConn.lastWrite.Load() != 0 {
+		t.Error("a failed commit started the StickyPrimary window")
+	}
+
+	drv.commitErr = nil
+	if err := dbConn.WithTx(func(tx *Tx) error { return nil }); err != nil || !drv.committed {
+		t.Fatalf("expected a commit, got %v", err)
+	}
+	if dbConn.lastWrite.Load() == 0 {
+		t.Error("a commit did not start the StickyPrimary window")
+	}
+}
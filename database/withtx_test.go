@@ -0,0 +1,120 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/knousere/web-service-commons/database"
+	"github.com/knousere/web-service-commons/database/dbtest"
+	"github.com/knousere/web-service-commons/go-sql-driver/mysql"
+)
+
+// queries returns the queries sent to fake in order.
+func queries(fake *dbtest.Fake) []string {
+	calls := fake.Calls()
+	queries := make([]string, len(calls))
+	for i, call := range calls {
+		queries[i] = call.Query
+	}
+	return queries
+}
+
+// vote is a unit of work that casts a vote and then logs it.
+func vote(tx *database.Tx) error {
+	tx.UpdateRowsWithDeadlock("CALL sp_vote(?)", 7)
+	_, err := tx.UpdateRows("CALL sp_vote_log(?)", 7)
+	return err
+}
+
+func TestWithTx(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+	lockWait := &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}
+	attempt := []string{"BEGIN", "CALL sp_vote(?)", "CALL sp_vote_log(?)"}
+	committed := append(append([]string{}, attempt...), "COMMIT")
+	rolledBack := append(append([]string{}, attempt...), "ROLLBACK")
+
+	tests := []struct {
+		name    string
+		vote    func(rule *dbtest.Rule)
+		err     error
+		queries [][]string
+	}{
+		{
+			"commit",
+			func(rule *dbtest.Rule) { rule.Result(1, false) },
+			nil,
+			[][]string{committed},
+		},
+		{
+			"deadlock reported by the procedure",
+			func(rule *dbtest.Rule) { rule.Once().Deadlock(0) },
+			nil,
+			[][]string{rolledBack, committed},
+		},
+		{
+			"deadlock error of the server",
+			func(rule *dbtest.Rule) { rule.Once().Error(deadlock) },
+			nil,
+			[][]string{rolledBack, committed},
+		},
+		{
+			"lock wait timeout until the retries run out",
+			func(rule *dbtest.Rule) { rule.Error(lockWait) },
+			lockWait,
+			[][]string{rolledBack, rolledBack, rolledBack},
+		},
+		{
+			"other error",
+			func(rule *dbtest.Rule) { rule.Error(sql.ErrConnDone) },
+			sql.ErrConnDone,
+			[][]string{rolledBack},
+		},
+	}
+	for _, test := range tests {
+		fake := dbtest.New()
+		test.vote(fake.OnCall("sp_vote"))
+		fake.OnCall("sp_vote").Rows([]string{"affected_count", "deadlock"}, []interface{}{1, false})
+		fake.OnCall("sp_vote_log").Value(1)
+		db := fake.DB()
+		db.TxMaxRetries = 2
+		db.TxBackoff = 1
+
+		err := db.WithTx(vote)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+		var expected []string
+		for _, attempt := range test.queries {
+			expected = append(expected, attempt...)
+		}
+		if got := queries(fake); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected\n%q\ngot\n%q", test.name, expected, got)
+		}
+	}
+}
+
+func TestWithTxError(t *testing.T) {
+	fake := dbtest.New()
+	fake.OnCall("sp_vote").Result(1, false)
+	db := fake.DB()
+
+	errVote := errors.New("vote closed")
+	err := db.WithTx(func(tx *database.Tx) error {
+		tx.UpdateRowsWithDeadlock("CALL sp_vote(?)", 7)
+		return errVote
+	})
+	if err != errVote {
+		t.Errorf("expected the error of fn, got %v", err)
+	}
+	if expected := []string{"BEGIN", "CALL sp_vote(?)", "ROLLBACK"}; !reflect.DeepEqual(queries(fake), expected) {
+		t.Errorf("expected %q, got %q", expected, queries(fake))
+	}
+
+	fake = dbtest.New()
+	fake.OnQuery("^COMMIT$").Error(sql.ErrConnDone)
+	if err = fake.DB().WithTx(func(tx *database.Tx) error { return nil }); err != sql.ErrConnDone {
+		t.Errorf("expected the commit error, got %v", err)
+	}
+}
@@ -31,6 +31,10 @@ type mysqlConn struct {
 	sequence         uint8
 	parseTime        bool
 	strict           bool
+	connectionID     uint32        // server thread id, used by KILL QUERY
+	dsn              string        // used to open the side connection for KILL QUERY
+	finished         chan struct{} // closed by finish to stop watchCancel
+	killed           chan bool     // watchCancel reports whether it issued KILL QUERY
 }
 
 type config struct {
@@ -372,6 +376,13 @@ func (mc *mysqlConn) Query(query string, args []driver.Value) (driver.Rows, erro
 	return nil, err
 }
 
+// MaxAllowedPacket returns the largest packet the server accepts, read from
+// max_allowed_packet at connect time. It is reachable through sql.Conn.Raw,
+// e.g. to size batched statements.
+func (mc *mysqlConn) MaxAllowedPacket() int {
+	return mc.maxPacketAllowed
+}
+
 // Gets the value of the given MySQL System Variable
 // The returned byte slice is only valid until the next read
 func (mc *mysqlConn) getSystemVar(name string) ([]byte, error) {
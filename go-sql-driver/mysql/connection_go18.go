@@ -0,0 +1,294 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2017 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build go1.8
+// +build go1.8
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strconv"
+)
+
+// Ping implements driver.Pinger interface
+func (mc *mysqlConn) Ping(ctx context.Context) error {
+	if mc.netConn == nil {
+		errLog.Print(ErrInvalidConn)
+		return driver.ErrBadConn
+	}
+
+	if err := mc.watchCancel(ctx); err != nil {
+		return err
+	}
+
+	err := mc.writeCommandPacket(comPing)
+	if err == nil {
+		err = mc.readResultOK()
+	}
+	if mc.finish() {
+		return ctx.Err()
+	}
+	return err
+}
+
+// BeginTx implements driver.ConnBeginTx interface
+func (mc *mysqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if mc.netConn == nil {
+		errLog.Print(ErrInvalidConn)
+		return nil, driver.ErrBadConn
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if sql.IsolationLevel(opts.Isolation) != sql.LevelDefault {
+		level, err := mapIsolationLevel(opts.Isolation)
+		if err != nil {
+			return nil, err
+		}
+		if err = mc.exec("SET TRANSACTION ISOLATION LEVEL " + level); err != nil {
+			return nil, err
+		}
+	}
+
+	query := "START TRANSACTION"
+	if opts.ReadOnly {
+		query = "START TRANSACTION READ ONLY"
+	}
+	if err := mc.exec(query); err != nil {
+		return nil, err
+	}
+	return &mysqlTx{mc}, nil
+}
+
+// QueryContext implements driver.QueryerContext interface
+func (mc *mysqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	dargs, err := namedValueToValue(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mc.watchCancel(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := mc.Query(query, dargs)
+	if err == nil && ctx.Err() != nil {
+		// the query was killed but completed anyway, e.g. SLEEP()
+		rows.Close()
+		err = ctx.Err()
+	}
+	if err != nil {
+		if mc.finish() {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return mc.watchRows(rows), nil
+}
+
+// ExecContext implements driver.ExecerContext interface
+func (mc *mysqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	dargs, err := namedValueToValue(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mc.watchCancel(ctx); err != nil {
+		return nil, err
+	}
+
+	result, err := mc.Exec(query, dargs)
+	if mc.finish() {
+		return nil, ctx.Err()
+	}
+	return result, err
+}
+
+// PrepareContext implements driver.ConnPrepareContext interface
+func (mc *mysqlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := mc.watchCancel(ctx); err != nil {
+		return nil, err
+	}
+
+	stmt, err := mc.Prepare(query)
+	if mc.finish() {
+		if err == nil {
+			stmt.Close()
+		}
+		return nil, ctx.Err()
+	}
+	return stmt, err
+}
+
+// QueryContext implements driver.StmtQueryContext interface
+func (stmt *mysqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	dargs, err := namedValueToValue(args)
+	if err != nil {
+		return nil, err
+	}
+
+	mc := stmt.mc
+	if mc == nil || mc.netConn == nil {
+		errLog.Print(ErrInvalidConn)
+		return nil, driver.ErrBadConn
+	}
+	if err := mc.watchCancel(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(dargs)
+	if err == nil && ctx.Err() != nil {
+		// the query was killed but completed anyway, e.g. SLEEP()
+		rows.Close()
+		err = ctx.Err()
+	}
+	if err != nil {
+		if mc.finish() {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return mc.watchRows(rows), nil
+}
+
+// ExecContext implements driver.StmtExecContext interface
+func (stmt *mysqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	dargs, err := namedValueToValue(args)
+	if err != nil {
+		return nil, err
+	}
+
+	mc := stmt.mc
+	if mc == nil || mc.netConn == nil {
+		errLog.Print(ErrInvalidConn)
+		return nil, driver.ErrBadConn
+	}
+	if err := mc.watchCancel(ctx); err != nil {
+		return nil, err
+	}
+
+	result, err := stmt.Exec(dargs)
+	if mc.finish() {
+		return nil, ctx.Err()
+	}
+	return result, err
+}
+
+// watchCancel starts watching ctx for the command about to be sent.
+// If ctx is done before finish is called, KILL QUERY is issued for this
+// connection on a side connection so the server actually stops working.
+// The connection itself stays usable, which keeps the stored procedure
+// multi result handling intact.
+func (mc *mysqlConn) watchCancel(ctx context.Context) error {
+	if mc.finished != nil {
+		// a previous watcher is still running, e.g. rows not yet closed
+		mc.finish()
+	}
+
+	done := ctx.Done()
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return ctx.Err()
+	default:
+	}
+
+	finished := make(chan struct{})
+	killed := make(chan bool, 1)
+	mc.finished = finished
+	mc.killed = killed
+
+	go func(connectionID uint32, dsn string) {
+		select {
+		case <-done:
+			killQuery(dsn, connectionID)
+			killed <- true
+		case <-finished:
+			killed <- false
+		}
+	}(mc.connectionID, mc.dsn)
+
+	return nil
+}
+
+// finish stops the watcher started by watchCancel and reports whether it
+// issued KILL QUERY. It waits for an in-progress KILL QUERY to complete so
+// the kill can never hit the next command on this connection.
+func (mc *mysqlConn) finish() bool {
+	if mc.finished == nil {
+		return false
+	}
+	close(mc.finished)
+	killed := <-mc.killed
+	mc.finished = nil
+	mc.killed = nil
+	return killed
+}
+
+// watchRows hands the watcher over to rows so that the query can still be
+// killed while the result set is being read.
+func (mc *mysqlConn) watchRows(rows driver.Rows) driver.Rows {
+	switch r := rows.(type) {
+	case *textRows:
+		r.finish = mc.finish
+	case *binaryRows:
+		r.finish = mc.finish
+	default:
+		mc.finish()
+	}
+	return rows
+}
+
+// killQuery opens a side connection and kills the current statement of
+// the connection with the given id.
+func killQuery(dsn string, connectionID uint32) {
+	conn, err := MySQLDriver{}.Open(dsn)
+	if err != nil {
+		errLog.Print("KILL QUERY failed to connect: ", err)
+		return
+	}
+	defer conn.Close()
+
+	mc := conn.(*mysqlConn)
+	if err = mc.exec("KILL QUERY " + strconv.FormatUint(uint64(connectionID), 10)); err != nil {
+		errLog.Print("KILL QUERY failed: ", err)
+	}
+}
+
+func namedValueToValue(named []driver.NamedValue) ([]driver.Value, error) {
+	dargs := make([]driver.Value, len(named))
+	for n, param := range named {
+		if len(param.Name) > 0 {
+			return nil, errors.New("mysql: driver does not support the use of Named Parameters")
+		}
+		dargs[n] = param.Value
+	}
+	return dargs, nil
+}
+
+func mapIsolationLevel(level driver.IsolationLevel) (string, error) {
+	switch sql.IsolationLevel(level) {
+	case sql.LevelRepeatableRead:
+		return "REPEATABLE READ", nil
+	case sql.LevelReadCommitted:
+		return "READ COMMITTED", nil
+	case sql.LevelReadUncommitted:
+		return "READ UNCOMMITTED", nil
+	case sql.LevelSerializable:
+		return "SERIALIZABLE", nil
+	default:
+		return "", errors.New("mysql: unsupported isolation level: " + strconv.Itoa(int(level)))
+	}
+}
@@ -52,6 +52,7 @@ func (d MySQLDriver) Open(dsn string) (driver.Conn, error) {
 	mc := &mysqlConn{
 		maxPacketAllowed: maxPacketSize,
 		maxWriteSize:     maxPacketSize - 1,
+		dsn:              dsn,
 	}
 	mc.cfg, err = parseDSN(dsn)
 	if err != nil {
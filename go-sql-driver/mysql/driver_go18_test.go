@@ -0,0 +1,271 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2017 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build go1.8
+// +build go1.8
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// static interface implementation checks of mysqlConn and mysqlStmt
+var (
+	_ driver.ConnBeginTx        = &mysqlConn{}
+	_ driver.ConnPrepareContext = &mysqlConn{}
+	_ driver.ExecerContext      = &mysqlConn{}
+	_ driver.Pinger             = &mysqlConn{}
+	_ driver.QueryerContext     = &mysqlConn{}
+	_ driver.RowsNextResultSet  = &binaryRows{}
+	_ driver.RowsNextResultSet  = &textRows{}
+	_ driver.StmtExecContext    = &mysqlStmt{}
+	_ driver.StmtQueryContext   = &mysqlStmt{}
+)
+
+func TestNamedValueToValue(t *testing.T) {
+	dargs, err := namedValueToValue([]driver.NamedValue{
+		{Ordinal: 1, Value: int64(1)},
+		{Ordinal: 2, Value: "two"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(dargs) != 2 || dargs[0] != int64(1) || dargs[1] != "two" {
+		t.Errorf("unexpected values: %v", dargs)
+	}
+
+	if _, err = namedValueToValue([]driver.NamedValue{{Name: "id", Ordinal: 1, Value: int64(1)}}); err == nil {
+		t.Error("expected error for named parameter")
+	}
+}
+
+func TestMapIsolationLevel(t *testing.T) {
+	levels := map[sql.IsolationLevel]string{
+		sql.LevelRepeatableRead:  "REPEATABLE READ",
+		sql.LevelReadCommitted:   "READ COMMITTED",
+		sql.LevelReadUncommitted: "READ UNCOMMITTED",
+		sql.LevelSerializable:    "SERIALIZABLE",
+	}
+	for level, expected := range levels {
+		if res, err := mapIsolationLevel(driver.IsolationLevel(level)); err != nil || res != expected {
+			t.Errorf("mapIsolationLevel(%d) => %q, %v, want %q", level, res, err, expected)
+		}
+	}
+
+	if _, err := mapIsolationLevel(driver.IsolationLevel(sql.LevelLinearizable)); err == nil {
+		t.Error("expected error for unsupported isolation level")
+	}
+}
+
+func TestPingContext(t *testing.T) {
+	runTests(t, dsn, func(dbt *DBTest) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := dbt.db.PingContext(ctx); err != context.Canceled {
+			dbt.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func TestContextCancelQuery(t *testing.T) {
+	runTests(t, dsn, func(dbt *DBTest) {
+		ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+		defer cancel()
+
+		startTime := time.Now()
+		if _, err := dbt.db.QueryContext(ctx, "SELECT SLEEP(10)"); err != context.DeadlineExceeded {
+			dbt.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+		if d := time.Since(startTime); d > 5*time.Second {
+			dbt.Errorf("too long execution time: %s", d)
+		}
+
+		// The connection must still be usable after KILL QUERY.
+		var v int
+		if err := dbt.db.QueryRow("SELECT 1").Scan(&v); err != nil || v != 1 {
+			dbt.Errorf("connection unusable after cancel: %v", err)
+		}
+	})
+}
+
+func TestContextCancelExec(t *testing.T) {
+	runTests(t, dsn, func(dbt *DBTest) {
+		dbt.mustExec("CREATE TABLE test (v INTEGER)")
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// Delay execution for just a bit until db.ExecContext has begun.
+		defer time.AfterFunc(250*time.Millisecond, cancel).Stop()
+
+		// This query will be killed after 250ms and the INSERT never happens.
+		if _, err := dbt.db.ExecContext(ctx, "INSERT INTO test VALUES (SLEEP(1))"); err != context.Canceled {
+			dbt.Errorf("expected context.Canceled, got %v", err)
+		}
+
+		// Wait for the INSERT query to be done.
+		time.Sleep(time.Second)
+
+		var v int
+		if err := dbt.db.QueryRow("SELECT COUNT(*) FROM test").Scan(&v); err != nil {
+			dbt.Fatalf("%s", err.Error())
+		}
+		if v != 0 {
+			dbt.Errorf("expected val to be 0, got %d", v)
+		}
+	})
+}
+
+func TestContextBeginReadOnly(t *testing.T) {
+	runTests(t, dsn, func(dbt *DBTest) {
+		dbt.mustExec("CREATE TABLE test (v INTEGER)")
+		tx, err := dbt.db.BeginTx(context.Background(), &sql.TxOptions{
+			Isolation: sql.LevelReadCommitted,
+			ReadOnly:  true,
+		})
+		if err != nil {
+			dbt.Fatal(err)
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("INSERT INTO test VALUES (1)"); err == nil {
+			dbt.Error("expected error writing in a read only transaction")
+		}
+	})
+}
+
+func TestMultiResultSet(t *testing.T) {
+	type result struct {
+		values  [][]int
+		columns []string
+	}
+
+	// checkRows is a helper test function to validate rows containing 3 result
+	// sets with specific values and columns. The basic query would look like this:
+	//
+	// SELECT 1 AS col1, 2 AS col2 UNION SELECT 3, 4;
+	// SELECT 0 UNION SELECT 1;
+	// SELECT 1 AS col1, 2 AS col2, 3 AS col3 UNION SELECT 4, 5, 6;
+	//
+	// to distinguish test cases the first string argument is put in front of
+	// every error or fatal message.
+	checkRows := func(desc string, rows *sql.Rows, dbt *DBTest) {
+		expected := []result{
+			{
+				values:  [][]int{{1, 2}, {3, 4}},
+				columns: []string{"col1", "col2"},
+			},
+			{
+				values:  [][]int{{0}, {1}},
+				columns: []string{"0"},
+			},
+			{
+				values:  [][]int{{1, 2, 3}, {4, 5, 6}},
+				columns: []string{"col1", "col2", "col3"},
+			},
+		}
+
+		var res1 result
+		for rows.Next() {
+			var res [2]int
+			if err := rows.Scan(&res[0], &res[1]); err != nil {
+				dbt.Fatal(err)
+			}
+			res1.values = append(res1.values, res[:])
+		}
+
+		cols, err := rows.Columns()
+		if err != nil {
+			dbt.Fatal(desc, err)
+		}
+		res1.columns = cols
+
+		if !reflect.DeepEqual(expected[0], res1) {
+			dbt.Error(desc, "want =", expected[0], "got =", res1)
+		}
+
+		if !rows.NextResultSet() {
+			dbt.Fatal(desc, "expected next result set")
+		}
+
+		// ignoring one result set
+
+		if !rows.NextResultSet() {
+			dbt.Fatal(desc, "expected next result set")
+		}
+
+		var res2 result
+		cols, err = rows.Columns()
+		if err != nil {
+			dbt.Fatal(desc, err)
+		}
+		res2.columns = cols
+
+		for rows.Next() {
+			var res [3]int
+			if err := rows.Scan(&res[0], &res[1], &res[2]); err != nil {
+				dbt.Fatal(desc, err)
+			}
+			res2.values = append(res2.values, res[:])
+		}
+
+		if !reflect.DeepEqual(expected[2], res2) {
+			dbt.Error(desc, "want =", expected[2], "got =", res2)
+		}
+
+		if rows.NextResultSet() {
+			dbt.Error(desc, "unexpected next result set")
+		}
+
+		if err := rows.Err(); err != nil {
+			dbt.Error(desc, err)
+		}
+	}
+
+	runTests(t, dsn, func(dbt *DBTest) {
+		dbt.mustExec("DROP PROCEDURE IF EXISTS test_mrss")
+		dbt.mustExec(`CREATE PROCEDURE test_mrss()
+		BEGIN
+			SELECT 1 AS col1, 2 AS col2 UNION SELECT 3, 4;
+			SELECT 0 UNION SELECT 1;
+			SELECT 1 AS col1, 2 AS col2, 3 AS col3 UNION SELECT 4, 5, 6;
+		END`)
+		defer dbt.mustExec("DROP PROCEDURE IF EXISTS test_mrss")
+
+		rows := dbt.mustQuery("CALL test_mrss()")
+		checkRows("sp: ", rows, dbt)
+		rows.Close()
+
+		// the same stored procedure through the binary protocol
+		stmt, err := dbt.db.Prepare("CALL test_mrss()")
+		if err != nil {
+			dbt.Fatal(err)
+		}
+		defer stmt.Close()
+
+		for i := 0; i < 2; i++ {
+			rows, err := stmt.Query()
+			if err != nil {
+				dbt.Fatal(err)
+			}
+			checkRows(fmt.Sprintf("prepared stmt query #%d: ", i+1), rows, dbt)
+			rows.Close()
+		}
+
+		// the single result set path must still discard the extra result sets
+		var v int
+		if err := dbt.db.QueryRow("CALL test_mrss()").Scan(&v, new(int)); err != nil || v != 1 {
+			dbt.Errorf("QueryRow on multi result CALL: %v, %d", err, v)
+		}
+	})
+}
@@ -148,7 +148,9 @@ func (mc *mysqlConn) readInitPacket() ([]byte, error) {
 
 	// server version [null terminated string]
 	// connection id [4 bytes]
-	pos := 1 + bytes.IndexByte(data[1:], 0x00) + 1 + 4
+	pos := 1 + bytes.IndexByte(data[1:], 0x00) + 1
+	mc.connectionID = binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
 
 	// first part of the password cipher [8 bytes]
 	cipher := data[pos : pos+8]
@@ -609,11 +611,9 @@ func (rows *textRows) readRow(dest []driver.Value) error {
 	// EOF Packet
 	if data[0] == iEOF && len(data) == 5 {
 		// server_status [2 bytes]
+		// More result sets are read by NextResultSet or discarded by Close
 		rows.mc.status = readStatus(data[3:])
-		if err := rows.mc.discardMoreResultsIfExists(); err != nil {
-			return err
-		}
-		rows.mc = nil
+		rows.done = true
 		return io.EOF
 	}
 	if data[0] == iERR {
@@ -666,6 +666,11 @@ func (mc *mysqlConn) readUntilEOF() error {
 	for {
 		data, err := mc.readPacket()
 
+		// An ERR Packet ends the stream, e.g. after KILL QUERY
+		if err == nil && data[0] == iERR {
+			return mc.handleErrorPacket(data)
+		}
+
 		// No Err and no EOF Packet
 		if err == nil && data[0] != iEOF {
 			continue
@@ -1011,11 +1016,9 @@ func (rows *binaryRows) readRow(dest []driver.Value) error {
 	if data[0] != iOK {
 		// EOF Packet
 		if data[0] == iEOF && len(data) == 5 {
+			// More result sets are read by NextResultSet or discarded by Close
 			rows.mc.status = readStatus(data[3:])
-			if err := rows.mc.discardMoreResultsIfExists(); err != nil {
-				return err
-			}
-			rows.mc = nil
+			rows.done = true
 			return io.EOF
 		}
 		rows.mc = nil
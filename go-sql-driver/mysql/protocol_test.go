@@ -0,0 +1,563 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2017 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The tests in this file run against the testServer stand-in in server_test.go,
+// so they need no MySQL server and run on every `go test`.
+
+func openTestServer(t *testing.T, srv *testServer, params string) *sql.DB {
+	db, err := sql.Open("mysql", srv.dsn(params))
+	if err != nil {
+		t.Fatalf("error connecting: %s", err.Error())
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// quietErrLog silences the driver's error log for tests that break connections on purpose.
+func quietErrLog(t *testing.T) {
+	previous := errLog
+	errLog = log.New(io.Discard, "", 0)
+	t.Cleanup(func() { errLog = previous })
+}
+
+func TestProtocolHandshake(t *testing.T) {
+	srv := newTestServer(t)
+
+	conn, err := MySQLDriver{}.Open(srv.dsn(""))
+	if err != nil {
+		t.Fatalf("error connecting: %s", err.Error())
+	}
+	defer conn.Close()
+
+	mc := conn.(*mysqlConn)
+	conns := srv.connections()
+	if len(conns) != 1 {
+		t.Fatalf("expected 1 server connection, got %d", len(conns))
+	}
+	if mc.connectionID != conns[0].connectionID {
+		t.Errorf("connection id: expected %d, got %d", conns[0].connectionID, mc.connectionID)
+	}
+	if mc.flags&clientProtocol41 == 0 {
+		t.Error("server capabilities not read")
+	}
+	if conns[0].user != srv.user || conns[0].dbname != srv.dbname {
+		t.Errorf("server got user %q and dbname %q", conns[0].user, conns[0].dbname)
+	}
+	if mc.maxPacketAllowed != testMaxAllowedSize-1 {
+		t.Errorf("max_allowed_packet: expected %d, got %d", testMaxAllowedSize-1, mc.maxPacketAllowed)
+	}
+	if err = mc.Ping(context.Background()); err != nil {
+		t.Errorf("ping: %s", err.Error())
+	}
+}
+
+func TestProtocolMaxAllowedPacket(t *testing.T) {
+	srv := newTestServer(t)
+	db := openTestServer(t, srv, "")
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("error connecting: %s", err.Error())
+	}
+	defer conn.Close()
+	var size int
+	err = conn.Raw(func(driverConn interface{}) error {
+		size = driverConn.(interface{ MaxAllowedPacket() int }).MaxAllowedPacket()
+		return nil
+	})
+	if err != nil || size != testMaxAllowedSize-1 {
+		t.Errorf("expected %d, got %d, %v", testMaxAllowedSize-1, size, err)
+	}
+}
+
+func TestProtocolAuthFailure(t *testing.T) {
+	srv := newTestServer(t)
+	dsn := strings.Replace(srv.dsn(""), ":"+srv.passwd+"@", ":wrong@", 1)
+
+	_, err := MySQLDriver{}.Open(dsn)
+	mysqlErr, ok := err.(*MySQLError)
+	if !ok {
+		t.Fatalf("expected MySQLError, got %v", err)
+	}
+	if mysqlErr.Number != 1045 {
+		t.Errorf("expected error 1045, got %d", mysqlErr.Number)
+	}
+}
+
+func TestProtocolTextQuery(t *testing.T) {
+	srv := newTestServer(t)
+	srv.onQuery("SELECT id, name, score FROM test", func(c *serverConn, query string) {
+		c.writeTextResultSet(
+			[]serverColumn{
+				{name: "id", fieldType: fieldTypeLongLong, flags: flagNotNULL},
+				{name: "name", fieldType: fieldTypeVarString},
+				{name: "score", fieldType: fieldTypeDouble},
+			},
+			[][]interface{}{
+				{1, "one", 1.5},
+				{2, nil, nil},
+			},
+			testServerStatus,
+		)
+	})
+	srv.onQuery("UPDATE test SET name = 'x'", func(c *serverConn, query string) {
+		c.writeOK(2, 0, testServerStatus)
+	})
+	srv.onQuery("INSERT INTO test VALUES (3, 'three')", func(c *serverConn, query string) {
+		c.writeOK(1, 3, testServerStatus)
+	})
+	db := openTestServer(t, srv, "")
+
+	rows, err := db.Query("SELECT id, name, score FROM test")
+	if err != nil {
+		t.Fatalf("query: %s", err.Error())
+	}
+	type row struct {
+		id    int64
+		name  sql.NullString
+		score sql.NullFloat64
+	}
+	var got []row
+	for rows.Next() {
+		var r row
+		if err = rows.Scan(&r.id, &r.name, &r.score); err != nil {
+			t.Fatalf("scan: %s", err.Error())
+		}
+		got = append(got, r)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatalf("rows: %s", err.Error())
+	}
+	rows.Close()
+	expected := []row{
+		{1, sql.NullString{String: "one", Valid: true}, sql.NullFloat64{Float64: 1.5, Valid: true}},
+		{2, sql.NullString{}, sql.NullFloat64{}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	res, err := db.Exec("UPDATE test SET name = 'x'")
+	if err != nil {
+		t.Fatalf("exec: %s", err.Error())
+	}
+	if count, _ := res.RowsAffected(); count != 2 {
+		t.Errorf("expected 2 affected rows, got %d", count)
+	}
+
+	res, err = db.Exec("INSERT INTO test VALUES (3, 'three')")
+	if err != nil {
+		t.Fatalf("exec: %s", err.Error())
+	}
+	if id, _ := res.LastInsertId(); id != 3 {
+		t.Errorf("expected insert id 3, got %d", id)
+	}
+}
+
+func TestProtocolErrorPacket(t *testing.T) {
+	srv := newTestServer(t)
+	srv.onQuery("SELECT * FROM missing", func(c *serverConn, query string) {
+		c.writeERR(1146, "42S02", "Table 'gotest.missing' doesn't exist")
+	})
+	srv.onQuery("SELECT 1", func(c *serverConn, query string) {
+		c.writeTextResultSet([]serverColumn{{name: "1", fieldType: fieldTypeLongLong}}, [][]interface{}{{1}}, testServerStatus)
+	})
+	db := openTestServer(t, srv, "")
+	db.SetMaxOpenConns(1)
+
+	_, err := db.Query("SELECT * FROM missing")
+	mysqlErr, ok := err.(*MySQLError)
+	if !ok {
+		t.Fatalf("expected MySQLError, got %v", err)
+	}
+	if mysqlErr.Number != 1146 || !strings.Contains(mysqlErr.Message, "missing") {
+		t.Errorf("unexpected error %v", mysqlErr)
+	}
+
+	// the connection is still usable after an error
+	var n int
+	if err = db.QueryRow("SELECT 1").Scan(&n); err != nil || n != 1 {
+		t.Errorf("expected 1, got %d, %v", n, err)
+	}
+}
+
+func TestProtocolPreparedStatement(t *testing.T) {
+	srv := newTestServer(t)
+	columns := []serverColumn{
+		{name: "id", fieldType: fieldTypeLongLong, flags: flagNotNULL},
+		{name: "flag", fieldType: fieldTypeTiny},
+		{name: "name", fieldType: fieldTypeVarString},
+		{name: "score", fieldType: fieldTypeDouble},
+	}
+	stmt := srv.onPrepare("SELECT id, flag, name, score FROM test WHERE id > ? AND name <> ? AND score < ? AND flag = ?", 4, columns,
+		func(c *serverConn, args []interface{}) {
+			c.writeBinaryResultSet(columns, [][]interface{}{
+				{int64(7), true, "seven", 7.5},
+				{int64(8), nil, nil, nil},
+			}, testServerStatus)
+		})
+	db := openTestServer(t, srv, "")
+
+	rows, err := db.Query(stmt.query, 5, "five", 9.25, nil)
+	if err != nil {
+		t.Fatalf("query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var id int64
+	var flag sql.NullBool
+	var name sql.NullString
+	var score sql.NullFloat64
+	if !rows.Next() {
+		t.Fatalf("expected a row: %v", rows.Err())
+	}
+	if err = rows.Scan(&id, &flag, &name, &score); err != nil {
+		t.Fatalf("scan: %s", err.Error())
+	}
+	if id != 7 || !flag.Bool || name.String != "seven" || score.Float64 != 7.5 {
+		t.Errorf("unexpected row %d %v %v %v", id, flag, name, score)
+	}
+	if !rows.Next() {
+		t.Fatalf("expected a second row: %v", rows.Err())
+	}
+	if err = rows.Scan(&id, &flag, &name, &score); err != nil {
+		t.Fatalf("scan: %s", err.Error())
+	}
+	if id != 8 || flag.Valid || name.Valid || score.Valid {
+		t.Errorf("expected NULLs, got %d %v %v %v", id, flag, name, score)
+	}
+	if rows.Next() {
+		t.Error("unexpected third row")
+	}
+
+	expected := []interface{}{int64(5), "five", 9.25, nil}
+	if !reflect.DeepEqual(stmt.lastArgs, expected) {
+		t.Errorf("server got arguments %v, expected %v", stmt.lastArgs, expected)
+	}
+}
+
+// callResults answers a CALL with two result sets and the final OK of the procedure.
+func callResults(c *serverConn, binary bool) {
+	columns := []serverColumn{{name: "n", fieldType: fieldTypeLongLong}}
+	write := c.writeTextResultSet
+	if binary {
+		write = c.writeBinaryResultSet
+	}
+	if !write(columns, [][]interface{}{{int64(1)}, {int64(2)}}, testServerStatus|statusMoreResultsExists) {
+		return
+	}
+	if !write(columns, [][]interface{}{{int64(3)}}, testServerStatus|statusMoreResultsExists) {
+		return
+	}
+	c.writeOK(0, 0, testServerStatus)
+}
+
+func readAllResultSets(t *testing.T, rows *sql.Rows) [][]int64 {
+	var sets [][]int64
+	for {
+		var set []int64
+		for rows.Next() {
+			var n int64
+			if err := rows.Scan(&n); err != nil {
+				t.Fatalf("scan: %s", err.Error())
+			}
+			set = append(set, n)
+		}
+		sets = append(sets, set)
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows: %s", err.Error())
+	}
+	return sets
+}
+
+func TestProtocolMultiResultCall(t *testing.T) {
+	srv := newTestServer(t)
+	srv.onQuery("CALL sp_test()", func(c *serverConn, query string) {
+		callResults(c, false)
+	})
+	srv.onPrepare("CALL sp_test(?)", 1, []serverColumn{{name: "n", fieldType: fieldTypeLongLong}},
+		func(c *serverConn, args []interface{}) {
+			callResults(c, true)
+		})
+	srv.onQuery("SELECT 1", func(c *serverConn, query string) {
+		c.writeTextResultSet([]serverColumn{{name: "1", fieldType: fieldTypeLongLong}}, [][]interface{}{{1}}, testServerStatus)
+	})
+	db := openTestServer(t, srv, "")
+	db.SetMaxOpenConns(1)
+	expected := [][]int64{{1, 2}, {3}}
+
+	for _, args := range [][]interface{}{nil, {42}} {
+		query := "CALL sp_test()"
+		if args != nil {
+			query = "CALL sp_test(?)"
+		}
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			t.Fatalf("%s: %s", query, err.Error())
+		}
+		if sets := readAllResultSets(t, rows); !reflect.DeepEqual(sets, expected) {
+			t.Errorf("%s: expected %v, got %v", query, expected, sets)
+		}
+		rows.Close()
+
+		// the final OK must have been consumed
+		var n int
+		if err = db.QueryRow("SELECT 1").Scan(&n); err != nil || n != 1 {
+			t.Errorf("%s: connection not reusable: %v", query, err)
+		}
+	}
+
+	// closing early discards the remaining results
+	rows, err := db.Query("CALL sp_test()")
+	if err != nil {
+		t.Fatalf("query: %s", err.Error())
+	}
+	rows.Close()
+	var n int
+	if err = db.QueryRow("SELECT 1").Scan(&n); err != nil || n != 1 {
+		t.Errorf("connection not reusable after early close: %v", err)
+	}
+}
+
+func TestProtocolPacketSplitting(t *testing.T) {
+	srv := newTestServer(t)
+
+	// larger than one packet and not a multiple of the packet size
+	large := bytes.Repeat([]byte("abcdefghij"), (maxPacketSize+100)/10)
+	query := "SELECT '" + string(large) + "'"
+	srv.onQuery(query, func(c *serverConn, q string) {
+		c.writeTextResultSet([]serverColumn{{name: "big", fieldType: fieldTypeLongBLOB}}, [][]interface{}{{large}}, testServerStatus)
+	})
+	db := openTestServer(t, srv, "")
+
+	var got []byte
+	if err := db.QueryRow(query).Scan(&got); err != nil {
+		t.Fatalf("query: %s", err.Error())
+	}
+	if !bytes.Equal(got, large) {
+		t.Errorf("expected %d bytes, got %d", len(large), len(got))
+	}
+}
+
+func TestProtocolPacketSync(t *testing.T) {
+	quietErrLog(t)
+	srv := newTestServer(t)
+	srv.onQuery("SELECT behind", func(c *serverConn, query string) {
+		c.writeRaw(0, []byte{iOK, 0, 0, 2, 0, 0, 0})
+	})
+	srv.onQuery("SELECT ahead", func(c *serverConn, query string) {
+		c.writeRaw(5, []byte{iOK, 0, 0, 2, 0, 0, 0})
+	})
+
+	for query, expected := range map[string]error{
+		"SELECT behind": ErrPktSync,
+		"SELECT ahead":  ErrPktSyncMul,
+	} {
+		conn, err := MySQLDriver{}.Open(srv.dsn(""))
+		if err != nil {
+			t.Fatalf("error connecting: %s", err.Error())
+		}
+		_, err = conn.(driver.Execer).Exec(query, nil)
+		if err != expected {
+			t.Errorf("%s: expected %v, got %v", query, expected, err)
+		}
+		conn.Close()
+	}
+}
+
+// killableQuery answers a query only once it is killed, with the error
+// the server sends for an interrupted statement.
+func killableQuery(interrupted <-chan struct{}) serverHandler {
+	return func(c *serverConn, query string) {
+		select {
+		case <-interrupted:
+		case <-time.After(5 * time.Second):
+		}
+		c.writeERR(1317, "70100", "Query execution was interrupted")
+	}
+}
+
+func TestProtocolContextCancel(t *testing.T) {
+	srv := newTestServer(t)
+	srv.onQuery("SELECT 1", func(c *serverConn, query string) {
+		c.writeTextResultSet([]serverColumn{{name: "1", fieldType: fieldTypeLongLong}}, [][]interface{}{{1}}, testServerStatus)
+	})
+
+	conn, err := MySQLDriver{}.Open(srv.dsn(""))
+	if err != nil {
+		t.Fatalf("error connecting: %s", err.Error())
+	}
+	defer conn.Close()
+	mc := conn.(*mysqlConn)
+
+	// KILL QUERY must name this connection and arrive on another one
+	killedFrom := make(chan uint32, 10)
+	var interrupted chan struct{}
+	srv.onQuery("KILL QUERY "+strconv.FormatUint(uint64(mc.connectionID), 10), func(c *serverConn, query string) {
+		killedFrom <- c.connectionID
+		close(interrupted)
+		c.writeOK(0, 0, testServerStatus)
+	})
+	expectKill := func(name string) {
+		select {
+		case id := <-killedFrom:
+			if id == mc.connectionID {
+				t.Errorf("%s: KILL QUERY was sent on the connection being killed", name)
+			}
+		default:
+			t.Errorf("%s: KILL QUERY %d was not sent", name, mc.connectionID)
+		}
+	}
+
+	// a query that completes in time is not killed
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	rows, err := mc.QueryContext(ctx, "SELECT 1", nil)
+	if err != nil {
+		t.Fatalf("SELECT 1: %s", err.Error())
+	}
+	rows.Close()
+	cancel()
+	if len(killedFrom) != 0 {
+		t.Error("KILL QUERY was sent for a completed query")
+	}
+
+	interrupted = make(chan struct{})
+	srv.onQuery("SELECT SLEEP(10)", killableQuery(interrupted))
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	startTime := time.Now()
+	if _, err = mc.QueryContext(ctx, "SELECT SLEEP(10)", nil); err != context.DeadlineExceeded {
+		t.Errorf("query: expected context.DeadlineExceeded, got %v", err)
+	}
+	cancel()
+	if d := time.Since(startTime); d > 2*time.Second {
+		t.Errorf("query: too long execution time: %s", d)
+	}
+	expectKill("query")
+
+	interrupted = make(chan struct{})
+	srv.onQuery("UPDATE test SET v = SLEEP(10)", killableQuery(interrupted))
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err = mc.ExecContext(ctx, "UPDATE test SET v = SLEEP(10)", nil); err != context.Canceled {
+		t.Errorf("exec: expected context.Canceled, got %v", err)
+	}
+	expectKill("exec")
+
+	// the connection is still usable after KILL QUERY
+	rows, err = mc.QueryContext(context.Background(), "SELECT 1", nil)
+	if err != nil {
+		t.Fatalf("connection unusable after cancel: %s", err.Error())
+	}
+	dest := make([]driver.Value, 1)
+	if err = rows.Next(dest); err != nil || !reflect.DeepEqual(dest[0], []byte("1")) {
+		t.Errorf("connection unusable after cancel: %v, %v", dest[0], err)
+	}
+	rows.Close()
+	if n := len(srv.connections()); n != 3 {
+		t.Errorf("expected 3 server connections, got %d", n)
+	}
+}
+
+// newTestCertificate returns a self-signed certificate for 127.0.0.1.
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "go-sql-driver test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestProtocolTLS(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+	srv := newTestServer(t)
+	srv.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.onQuery("SHOW STATUS LIKE 'Ssl_cipher'", func(c *serverConn, query string) {
+		value := ""
+		if c.isTLS {
+			value = "TLS_AES_128_GCM_SHA256"
+		}
+		c.writeTextResultSet(
+			[]serverColumn{{name: "Variable_name", fieldType: fieldTypeVarString}, {name: "Value", fieldType: fieldTypeVarString}},
+			[][]interface{}{{"Ssl_cipher", value}},
+			testServerStatus,
+		)
+	})
+
+	if err := RegisterTLSConfig("protocol-test", &tls.Config{RootCAs: pool}); err != nil {
+		t.Fatal(err)
+	}
+	defer DeregisterTLSConfig("protocol-test")
+	db := openTestServer(t, srv, "tls=protocol-test")
+
+	var name, cipher string
+	if err := db.QueryRow("SHOW STATUS LIKE 'Ssl_cipher'").Scan(&name, &cipher); err != nil {
+		t.Fatalf("query: %s", err.Error())
+	}
+	if cipher == "" {
+		t.Error("connection is not encrypted")
+	}
+}
+
+func TestProtocolNoTLS(t *testing.T) {
+	quietErrLog(t)
+	srv := newTestServer(t)
+
+	_, err := MySQLDriver{}.Open(srv.dsn("tls=skip-verify"))
+	if err != ErrNoTLS {
+		t.Errorf("expected ErrNoTLS, got %v", err)
+	}
+}
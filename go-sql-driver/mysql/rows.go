@@ -24,6 +24,8 @@ type mysqlField struct {
 type mysqlRows struct {
 	mc      *mysqlConn
 	columns []mysqlField
+	done    bool        // the current result set has been read up to its EOF packet
+	finish  func() bool // stops the context watcher, see watchCancel
 }
 
 type binaryRows struct {
@@ -51,6 +53,8 @@ func (rows *mysqlRows) Columns() []string {
 }
 
 func (rows *mysqlRows) Close() error {
+	defer rows.finishWatch()
+
 	mc := rows.mc
 	if mc == nil {
 		return nil
@@ -60,7 +64,10 @@ func (rows *mysqlRows) Close() error {
 	}
 
 	// Remove unread packets from stream
-	err := mc.readUntilEOF()
+	var err error
+	if !rows.done {
+		err = mc.readUntilEOF()
+	}
 	if err == nil {
 		if err = mc.discardMoreResultsIfExists(); err != nil {
 			return err
@@ -71,7 +78,94 @@ func (rows *mysqlRows) Close() error {
 	return err
 }
 
+// HasNextResultSet implements driver.RowsNextResultSet.
+// Stored procedures always end with an OK packet, so this is true after the
+// last data set of a CALL and NextResultSet then returns io.EOF.
+func (rows *mysqlRows) HasNextResultSet() bool {
+	if rows.mc == nil {
+		return false
+	}
+	return rows.mc.status&statusMoreResultsExists != 0
+}
+
+// nextResultSet skips the rest of the current result set and reads the
+// header of the next one. It returns the column count of the next result set.
+func (rows *mysqlRows) nextResultSet() (int, error) {
+	mc := rows.mc
+	if mc == nil {
+		return 0, io.EOF
+	}
+	if mc.netConn == nil {
+		return 0, ErrInvalidConn
+	}
+
+	// Remove unread packets from stream
+	if !rows.done {
+		if err := mc.readUntilEOF(); err != nil {
+			return 0, err
+		}
+		rows.done = true
+	}
+
+	if !rows.HasNextResultSet() {
+		rows.mc = nil
+		return 0, io.EOF
+	}
+	rows.columns = nil
+	rows.done = false
+
+	return mc.readResultSetHeaderPacket()
+}
+
+// nextNotEmptyResultSet skips OK packets, e.g. the status of a CALL,
+// until a result set with columns or the end of the results is found.
+func (rows *mysqlRows) nextNotEmptyResultSet() (int, error) {
+	for {
+		resLen, err := rows.nextResultSet()
+		if err != nil {
+			return 0, err
+		}
+		if resLen > 0 {
+			return resLen, nil
+		}
+		rows.done = true
+	}
+}
+
+// NextResultSet implements driver.RowsNextResultSet.
+func (rows *binaryRows) NextResultSet() error {
+	resLen, err := rows.nextNotEmptyResultSet()
+	if err != nil {
+		return err
+	}
+
+	rows.columns, err = rows.mc.readColumns(resLen)
+	return err
+}
+
+// NextResultSet implements driver.RowsNextResultSet.
+func (rows *textRows) NextResultSet() error {
+	resLen, err := rows.nextNotEmptyResultSet()
+	if err != nil {
+		return err
+	}
+
+	rows.columns, err = rows.mc.readColumns(resLen)
+	return err
+}
+
+// finishWatch stops the context watcher once the result set is exhausted or closed.
+func (rows *mysqlRows) finishWatch() {
+	if rows.finish != nil {
+		rows.finish()
+		rows.finish = nil
+	}
+}
+
 func (rows *binaryRows) Next(dest []driver.Value) error {
+	if rows.done {
+		return io.EOF
+	}
 	if mc := rows.mc; mc != nil {
 		if mc.netConn == nil {
 			return ErrInvalidConn
@@ -84,6 +178,9 @@ func (rows *binaryRows) Next(dest []driver.Value) error {
 }
 
 func (rows *textRows) Next(dest []driver.Value) error {
+	if rows.done {
+		return io.EOF
+	}
 	if mc := rows.mc; mc != nil {
 		if mc.netConn == nil {
 			return ErrInvalidConn
@@ -0,0 +1,683 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2017 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer is a scriptable stand-in for the server side of the MySQL
+// protocol. It speaks just enough of the protocol for the driver to connect,
+// run COM_QUERY, COM_STMT_PREPARE and COM_STMT_EXECUTE and read the results,
+// so that protocol handling can be tested without a real server.
+//
+// Queries are answered by handlers registered with onQuery and onPrepare.
+// A handler writes its response with the serverConn write helpers and may
+// misbehave on purpose, e.g. with a wrong sequence number.
+type testServer struct {
+	t         *testing.T
+	listener  net.Listener
+	user      string
+	passwd    string
+	dbname    string
+	tlsConfig *tls.Config // offer TLS if set
+	flags     clientFlag  // capability flags of the handshake
+
+	mu      sync.Mutex
+	queries map[string]serverHandler
+	stmts   map[string]*serverStmt
+	nextID  uint32
+	conns   []*serverConn
+	wg      sync.WaitGroup
+}
+
+// serverHandler answers one command.
+type serverHandler func(c *serverConn, query string)
+
+// serverStmt is a statement that can be prepared on the testServer.
+type serverStmt struct {
+	id       uint32
+	query    string
+	params   int
+	columns  []serverColumn
+	execute  func(c *serverConn, args []interface{})
+	lastArgs []interface{}
+}
+
+// serverColumn is the column definition sent by the testServer.
+type serverColumn struct {
+	name      string
+	fieldType byte
+	flags     fieldFlag
+	decimals  byte
+}
+
+// serverConn is one client connection to the testServer.
+type serverConn struct {
+	srv          *testServer
+	netConn      net.Conn
+	seq          byte
+	connectionID uint32
+	isTLS        bool
+	clientFlags  clientFlag
+	user         string
+	dbname       string
+	stmts        map[uint32]*serverStmt
+}
+
+const (
+	testServerVersion  = "5.7.99-teststandin"
+	testServerStatus   = statusInAutocommit
+	testMaxAllowedSize = 64 << 20
+)
+
+// newTestServer starts a testServer on a loopback port.
+// It is stopped when the test ends.
+func newTestServer(t *testing.T) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on loopback: %s", err.Error())
+	}
+	srv := &testServer{
+		t:        t,
+		listener: listener,
+		user:     "gotest",
+		passwd:   "secret",
+		dbname:   "gotest",
+		flags: clientLongPassword | clientLongFlag | clientConnectWithDB |
+			clientProtocol41 | clientTransactions | clientSecureConn |
+			clientMultiStatements | clientMultiResults | clientLocalFiles,
+		queries: make(map[string]serverHandler),
+		stmts:   make(map[string]*serverStmt),
+	}
+
+	// the driver reads max_allowed_packet right after the handshake
+	srv.onQuery("SELECT @@max_allowed_packet", func(c *serverConn, query string) {
+		c.writeTextResultSet(
+			[]serverColumn{{name: "@@max_allowed_packet", fieldType: fieldTypeLongLong}},
+			[][]interface{}{{testMaxAllowedSize}},
+			testServerStatus,
+		)
+	})
+
+	srv.wg.Add(1)
+	go srv.serve()
+	t.Cleanup(srv.close)
+	return srv
+}
+
+// dsn returns a DSN for the testServer with optional params.
+func (srv *testServer) dsn(params string) string {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s", srv.user, srv.passwd, srv.listener.Addr().String(), srv.dbname)
+	if params != "" {
+		dsn += "?" + params
+	}
+	return dsn
+}
+
+// onQuery answers COM_QUERY for query with h.
+func (srv *testServer) onQuery(query string, h serverHandler) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.queries[query] = h
+}
+
+// onPrepare lets query be prepared with the given parameter count and
+// result columns. execute answers each COM_STMT_EXECUTE with the decoded
+// parameters.
+func (srv *testServer) onPrepare(query string, params int, columns []serverColumn, execute func(c *serverConn, args []interface{})) *serverStmt {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	stmt := &serverStmt{query: query, params: params, columns: columns, execute: execute}
+	srv.stmts[query] = stmt
+	return stmt
+}
+
+// serve accepts connections until the listener is closed.
+func (srv *testServer) serve() {
+	defer srv.wg.Done()
+	for {
+		netConn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+		srv.mu.Lock()
+		srv.nextID++
+		c := &serverConn{
+			srv:          srv,
+			netConn:      netConn,
+			connectionID: srv.nextID,
+			stmts:        make(map[uint32]*serverStmt),
+		}
+		srv.conns = append(srv.conns, c)
+		srv.mu.Unlock()
+
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			defer c.netConn.Close()
+			if c.handshake() {
+				c.run()
+			}
+		}()
+	}
+}
+
+// close stops the testServer and every connection.
+func (srv *testServer) close() {
+	srv.listener.Close()
+	srv.mu.Lock()
+	for _, c := range srv.conns {
+		c.netConn.Close()
+	}
+	srv.mu.Unlock()
+	srv.wg.Wait()
+}
+
+// connections returns the connections accepted so far.
+func (srv *testServer) connections() []*serverConn {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]*serverConn(nil), srv.conns...)
+}
+
+/******************************************************************************
+*                              Connection Phase                               *
+******************************************************************************/
+
+// handshake sends the Handshake Initialization Packet and checks the
+// Client Authentication Packet, switching to TLS on an SSL Request.
+func (c *serverConn) handshake() bool {
+	srv := c.srv
+	flags := srv.flags
+	if srv.tlsConfig != nil {
+		flags |= clientSSL
+	}
+	cipher := []byte("0123456789abcdefghij")
+
+	data := []byte{minProtocolVersion}
+	data = append(data, testServerVersion...)
+	data = append(data, 0x00)
+	data = appendUint32(data, c.connectionID)
+	data = append(data, cipher[:8]...)
+	data = append(data, 0x00)
+	data = appendUint16(data, uint16(flags))
+	data = append(data, defaultCollation)
+	data = appendUint16(data, uint16(testServerStatus))
+	data = appendUint16(data, uint16(flags>>16))
+	data = append(data, byte(len(cipher)+1))
+	data = append(data, make([]byte, 10)...)
+	data = append(data, cipher[8:]...)
+	data = append(data, 0x00)
+	data = append(data, "mysql_native_password"...)
+	data = append(data, 0x00)
+	c.seq = 0
+	if !c.writePacket(data) {
+		return false
+	}
+
+	auth, ok := c.readPacket()
+	if !ok {
+		return false
+	}
+	if len(auth) < 32 {
+		srv.t.Errorf("test server: short auth packet of %d bytes", len(auth))
+		return false
+	}
+	c.clientFlags = clientFlag(binary.LittleEndian.Uint32(auth))
+
+	// SSL Request Packet
+	if c.clientFlags&clientSSL != 0 {
+		if len(auth) != 32 {
+			srv.t.Errorf("test server: SSL request of %d bytes", len(auth))
+			return false
+		}
+		if srv.tlsConfig == nil {
+			srv.t.Errorf("test server: SSL request although TLS was not offered")
+			return false
+		}
+		tlsConn := tls.Server(c.netConn, srv.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			srv.t.Errorf("test server: TLS handshake: %s", err.Error())
+			return false
+		}
+		c.netConn = tlsConn
+		c.isTLS = true
+		if auth, ok = c.readPacket(); !ok {
+			return false
+		}
+	}
+
+	// user [null terminated string]
+	pos := 32
+	end := bytes.IndexByte(auth[pos:], 0x00)
+	if end < 0 {
+		srv.t.Errorf("test server: malformed auth packet")
+		return false
+	}
+	c.user = string(auth[pos : pos+end])
+	pos += end + 1
+
+	// scramble [length encoded]
+	scrambleLen := int(auth[pos])
+	scramble := auth[pos+1 : pos+1+scrambleLen]
+	pos += 1 + scrambleLen
+
+	// database [null terminated string]
+	if c.clientFlags&clientConnectWithDB != 0 && pos < len(auth) {
+		c.dbname = string(bytes.TrimRight(auth[pos:], "\x00"))
+	}
+
+	if c.user != srv.user || !bytes.Equal(scramble, scramblePassword(cipher, []byte(srv.passwd))) {
+		c.writeERR(1045, "28000", fmt.Sprintf("Access denied for user '%s'", c.user))
+		return false
+	}
+	return c.writeOK(0, 0, testServerStatus)
+}
+
+/******************************************************************************
+*                               Command Phase                                 *
+******************************************************************************/
+
+// run answers commands until COM_QUIT or the connection is closed.
+func (c *serverConn) run() {
+	for {
+		c.seq = 0
+		data, ok := c.readPacket()
+		if !ok {
+			return
+		}
+
+		switch data[0] {
+		case comQuit:
+			return
+
+		case comPing:
+			c.writeOK(0, 0, testServerStatus)
+
+		case comQuery:
+			query := string(data[1:])
+			c.srv.mu.Lock()
+			h, ok := c.srv.queries[query]
+			c.srv.mu.Unlock()
+			if !ok {
+				c.writeERR(1064, "42000", "test server: unexpected query "+strconv.Quote(truncate(query)))
+				continue
+			}
+			h(c, query)
+
+		case comStmtPrepare:
+			c.prepare(string(data[1:]))
+
+		case comStmtExecute:
+			c.execute(data)
+
+		case comStmtClose:
+			// no response
+			delete(c.stmts, binary.LittleEndian.Uint32(data[1:5]))
+
+		default:
+			c.writeERR(1047, "08S01", fmt.Sprintf("test server: unsupported command %d", data[0]))
+		}
+	}
+}
+
+// prepare answers COM_STMT_PREPARE.
+func (c *serverConn) prepare(query string) {
+	c.srv.mu.Lock()
+	tmpl, ok := c.srv.stmts[query]
+	c.srv.nextID++
+	id := c.srv.nextID
+	c.srv.mu.Unlock()
+	if !ok {
+		c.writeERR(1064, "42000", "test server: unexpected prepare "+strconv.Quote(truncate(query)))
+		return
+	}
+
+	stmt := *tmpl
+	stmt.id = id
+	c.stmts[id] = &stmt
+
+	data := []byte{iOK}
+	data = appendUint32(data, stmt.id)
+	data = appendUint16(data, uint16(len(stmt.columns)))
+	data = appendUint16(data, uint16(stmt.params))
+	data = append(data, 0x00)
+	data = appendUint16(data, 0) // warnings
+	c.writePacket(data)
+
+	if stmt.params > 0 {
+		for i := 0; i < stmt.params; i++ {
+			c.writeColumn(serverColumn{name: "?", fieldType: fieldTypeVarString})
+		}
+		c.writeEOF(testServerStatus)
+	}
+	if len(stmt.columns) > 0 {
+		for _, column := range stmt.columns {
+			c.writeColumn(column)
+		}
+		c.writeEOF(testServerStatus)
+	}
+}
+
+// execute answers COM_STMT_EXECUTE after decoding the parameters.
+func (c *serverConn) execute(data []byte) {
+	stmt, ok := c.stmts[binary.LittleEndian.Uint32(data[1:5])]
+	if !ok {
+		c.writeERR(1243, "HY000", "Unknown prepared statement handler given to mysqld_stmt_execute")
+		return
+	}
+	args, err := decodeExecuteParams(data, stmt.params)
+	if err != nil {
+		c.srv.t.Errorf("test server: %s", err.Error())
+		c.writeERR(1210, "HY000", err.Error())
+		return
+	}
+
+	c.srv.mu.Lock()
+	tmpl := c.srv.stmts[stmt.query]
+	tmpl.lastArgs = args
+	c.srv.mu.Unlock()
+
+	stmt.execute(c, args)
+}
+
+// decodeExecuteParams decodes the parameters of a COM_STMT_EXECUTE packet.
+func decodeExecuteParams(data []byte, params int) ([]interface{}, error) {
+	// command, statement id, flags, iteration count
+	pos := 1 + 4 + 1 + 4
+	if params == 0 {
+		return nil, nil
+	}
+
+	nullMask := data[pos : pos+(params+7)/8]
+	pos += len(nullMask)
+	if data[pos] != 0x01 {
+		return nil, fmt.Errorf("new params bound flag not set")
+	}
+	pos++
+	types := data[pos : pos+2*params]
+	pos += 2 * params
+
+	args := make([]interface{}, params)
+	for i := range args {
+		if nullMask[i/8]&(1<<(uint(i)&7)) != 0 {
+			continue
+		}
+		switch types[2*i] {
+		case fieldTypeTiny:
+			args[i] = int64(int8(data[pos]))
+			pos++
+		case fieldTypeLongLong:
+			args[i] = int64(binary.LittleEndian.Uint64(data[pos : pos+8]))
+			pos += 8
+		case fieldTypeDouble:
+			args[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[pos : pos+8]))
+			pos += 8
+		case fieldTypeString, fieldTypeVarString:
+			value, _, n, err := readLengthEncodedString(data[pos:])
+			if err != nil {
+				return nil, err
+			}
+			args[i] = string(value)
+			pos += n
+		default:
+			return nil, fmt.Errorf("unsupported parameter type %d", types[2*i])
+		}
+	}
+	return args, nil
+}
+
+/******************************************************************************
+*                                  Packets                                    *
+******************************************************************************/
+
+// readPacket reads one logical packet, joining split packets.
+// The sequence number of each packet must be the expected one.
+func (c *serverConn) readPacket() ([]byte, bool) {
+	var payload []byte
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(c.netConn, header); err != nil {
+			return nil, false
+		}
+		pktLen := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+		if header[3] != c.seq {
+			c.srv.t.Errorf("test server: client packet sequence %d, expected %d", header[3], c.seq)
+			return nil, false
+		}
+		c.seq++
+
+		data := make([]byte, pktLen)
+		if _, err := io.ReadFull(c.netConn, data); err != nil {
+			return nil, false
+		}
+		payload = append(payload, data...)
+		if pktLen < maxPacketSize {
+			return payload, true
+		}
+	}
+}
+
+// writePacket writes payload, split into several packets if it is too large.
+// A payload that is a multiple of the maximum size ends with an empty packet.
+func (c *serverConn) writePacket(payload []byte) bool {
+	for {
+		size := len(payload)
+		if size > maxPacketSize {
+			size = maxPacketSize
+		}
+		if !c.writeRaw(c.seq, payload[:size]) {
+			return false
+		}
+		c.seq++
+		payload = payload[size:]
+		if size < maxPacketSize {
+			return true
+		}
+	}
+}
+
+// writeRaw writes one packet with the given sequence number as is.
+// Tests use it to break the protocol on purpose.
+func (c *serverConn) writeRaw(seq byte, payload []byte) bool {
+	header := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}
+	if _, err := c.netConn.Write(append(header, payload...)); err != nil {
+		return false
+	}
+	return true
+}
+
+// writeOK writes an OK packet.
+func (c *serverConn) writeOK(affectedRows, insertID uint64, status statusFlag) bool {
+	data := []byte{iOK}
+	data = appendLengthEncodedInteger(data, affectedRows)
+	data = appendLengthEncodedInteger(data, insertID)
+	data = appendUint16(data, uint16(status))
+	data = appendUint16(data, 0) // warnings
+	return c.writePacket(data)
+}
+
+// writeERR writes an ERR packet.
+func (c *serverConn) writeERR(number uint16, sqlState, message string) bool {
+	data := []byte{iERR}
+	data = appendUint16(data, number)
+	data = append(data, '#')
+	data = append(data, sqlState...)
+	data = append(data, message...)
+	return c.writePacket(data)
+}
+
+// writeEOF writes an EOF packet.
+func (c *serverConn) writeEOF(status statusFlag) bool {
+	data := []byte{iEOF}
+	data = appendUint16(data, 0) // warnings
+	data = appendUint16(data, uint16(status))
+	return c.writePacket(data)
+}
+
+// writeColumn writes a column definition packet.
+func (c *serverConn) writeColumn(column serverColumn) bool {
+	var data []byte
+	for _, s := range []string{"def", c.dbname, "t", "t", column.name, column.name} {
+		data = appendLengthEncodedInteger(data, uint64(len(s)))
+		data = append(data, s...)
+	}
+	data = append(data, 0x0c)
+	data = appendUint16(data, uint16(defaultCollation))
+	data = appendUint32(data, 255) // column length
+	data = append(data, column.fieldType)
+	data = appendUint16(data, uint16(column.flags))
+	data = append(data, column.decimals)
+	data = appendUint16(data, 0) // filler
+	return c.writePacket(data)
+}
+
+// writeTextResultSet writes a text protocol result set for COM_QUERY.
+// Set statusMoreResultsExists in status if another result follows.
+func (c *serverConn) writeTextResultSet(columns []serverColumn, rows [][]interface{}, status statusFlag) bool {
+	if !c.writeColumns(columns) {
+		return false
+	}
+	for _, row := range rows {
+		var data []byte
+		for _, v := range row {
+			if v == nil {
+				data = append(data, 0xfb)
+				continue
+			}
+			s := textValue(v)
+			data = appendLengthEncodedInteger(data, uint64(len(s)))
+			data = append(data, s...)
+		}
+		if !c.writePacket(data) {
+			return false
+		}
+	}
+	return c.writeEOF(status)
+}
+
+// writeBinaryResultSet writes a binary protocol result set for COM_STMT_EXECUTE.
+// Set statusMoreResultsExists in status if another result follows.
+func (c *serverConn) writeBinaryResultSet(columns []serverColumn, rows [][]interface{}, status statusFlag) bool {
+	if !c.writeColumns(columns) {
+		return false
+	}
+	for _, row := range rows {
+		nullMask := make([]byte, (len(columns)+7+2)/8)
+		var values []byte
+		for i, v := range row {
+			if v == nil {
+				nullMask[(i+2)/8] |= 1 << (uint(i+2) & 7)
+				continue
+			}
+			switch columns[i].fieldType {
+			case fieldTypeTiny:
+				values = append(values, byte(toInt64(v)))
+			case fieldTypeLong:
+				values = appendUint32(values, uint32(toInt64(v)))
+			case fieldTypeLongLong:
+				values = appendUint64(values, uint64(toInt64(v)))
+			case fieldTypeDouble:
+				values = appendUint64(values, math.Float64bits(v.(float64)))
+			default:
+				s := textValue(v)
+				values = appendLengthEncodedInteger(values, uint64(len(s)))
+				values = append(values, s...)
+			}
+		}
+		data := append([]byte{iOK}, nullMask...)
+		if !c.writePacket(append(data, values...)) {
+			return false
+		}
+	}
+	return c.writeEOF(status)
+}
+
+// writeColumns writes the column count, the column definitions and EOF.
+func (c *serverConn) writeColumns(columns []serverColumn) bool {
+	if !c.writePacket(appendLengthEncodedInteger(nil, uint64(len(columns)))) {
+		return false
+	}
+	for _, column := range columns {
+		if !c.writeColumn(column) {
+			return false
+		}
+	}
+	return c.writeEOF(testServerStatus)
+}
+
+func textValue(v interface{}) []byte {
+	switch value := v.(type) {
+	case []byte:
+		return value
+	case string:
+		return []byte(value)
+	case int:
+		return strconv.AppendInt(nil, int64(value), 10)
+	case int64:
+		return strconv.AppendInt(nil, value, 10)
+	case float64:
+		return strconv.AppendFloat(nil, value, 'g', -1, 64)
+	case bool:
+		if value {
+			return []byte("1")
+		}
+		return []byte("0")
+	case time.Time:
+		return []byte(value.Format(timeFormat))
+	default:
+		return []byte(fmt.Sprint(value))
+	}
+}
+
+func toInt64(v interface{}) int64 {
+	switch value := v.(type) {
+	case int:
+		return int64(value)
+	case int64:
+		return value
+	case bool:
+		if value {
+			return 1
+		}
+		return 0
+	default:
+		panic(fmt.Sprintf("test server: %T is not an integer", v))
+	}
+}
+
+func truncate(query string) string {
+	if len(query) > 64 {
+		return query[:64] + "..."
+	}
+	return query
+}
+
+func appendUint16(b []byte, n uint16) []byte {
+	return append(b, byte(n), byte(n>>8))
+}
+
+func appendUint32(b []byte, n uint32) []byte {
+	return append(b, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+}
+
+func appendUint64(b []byte, n uint64) []byte {
+	return append(appendUint32(b, uint32(n)), byte(n>>32), byte(n>>40), byte(n>>48), byte(n>>56))
+}
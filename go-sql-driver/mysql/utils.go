@@ -173,6 +173,25 @@ func parseDSN(dsn string) (cfg *config, err error) {
 	return
 }
 
+// DSN holds the connection fields of a data source name as the driver reads them.
+type DSN struct {
+	User   string
+	Passwd string
+	Net    string
+	Addr   string
+	DBName string
+}
+
+// ParseDSN parses a data source name the way Open does, e.g. to check one built
+// by hand. The user and password are taken literally, they are not unescaped.
+func ParseDSN(dsn string) (*DSN, error) {
+	cfg, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &DSN{User: cfg.user, Passwd: cfg.passwd, Net: cfg.net, Addr: cfg.addr, DBName: cfg.dbname}, nil
+}
+
 // parseDSNParams parses the DSN "query string"
 // Values must be url.QueryEscape'ed
 func parseDSNParams(cfg *config, params string) (err error) {
@@ -0,0 +1,140 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"math/big"
+)
+
+// Returned by Verify when the signature does not match.  crypto/ecdsa only reports a bool
+var ErrECDSAVerification = errors.New("crypto/ecdsa: verification error")
+
+// Implements the ECDSA family of signing methods signing methods
+type SigningMethodECDSA struct {
+	Name      string
+	Hash      crypto.Hash
+	KeySize   int // Size in bytes of r and s in the signature
+	CurveBits int // Size in bits of the curve the key must be on
+}
+
+// Specific instances for ES256 and company
+var (
+	SigningMethodES256 *SigningMethodECDSA
+	SigningMethodES384 *SigningMethodECDSA
+	SigningMethodES512 *SigningMethodECDSA
+)
+
+func init() {
+	// ES256
+	SigningMethodES256 = &SigningMethodECDSA{"ES256", crypto.SHA256, 32, 256}
+	RegisterSigningMethod(SigningMethodES256.Alg(), func() SigningMethod {
+		return SigningMethodES256
+	})
+
+	// ES384
+	SigningMethodES384 = &SigningMethodECDSA{"ES384", crypto.SHA384, 48, 384}
+	RegisterSigningMethod(SigningMethodES384.Alg(), func() SigningMethod {
+		return SigningMethodES384
+	})
+
+	// ES512
+	SigningMethodES512 = &SigningMethodECDSA{"ES512", crypto.SHA512, 66, 521}
+	RegisterSigningMethod(SigningMethodES512.Alg(), func() SigningMethod {
+		return SigningMethodES512
+	})
+}
+
+func (m *SigningMethodECDSA) Alg() string {
+	return m.Name
+}
+
+// Implements the Verify method from SigningMethod
+// For this signing method, must be either a PEM encoded PKIX EC public key as
+// []byte, or an ecdsa.PublicKey structure.  The key must be on the curve of the method.
+func (m *SigningMethodECDSA) Verify(signingString, signature string, key interface{}) error {
+	var err error
+
+	// Decode the signature
+	var sig []byte
+	if sig, err = DecodeSegment(signature); err != nil {
+		return err
+	}
+
+	var ecdsaKey *ecdsa.PublicKey
+
+	switch k := key.(type) {
+	case []byte:
+		if ecdsaKey, err = ParseECPublicKeyFromPEM(k); err != nil {
+			return err
+		}
+	case *ecdsa.PublicKey:
+		ecdsaKey = k
+	default:
+		return ErrInvalidKey
+	}
+	if ecdsaKey.Curve.Params().BitSize != m.CurveBits {
+		return ErrInvalidKey
+	}
+
+	// The signature is r and s, each left padded to KeySize
+	if len(sig) != 2*m.KeySize {
+		return ErrECDSAVerification
+	}
+	r := new(big.Int).SetBytes(sig[:m.KeySize])
+	s := new(big.Int).SetBytes(sig[m.KeySize:])
+
+	// Create hasher
+	if !m.Hash.Available() {
+		return ErrHashUnavailable
+	}
+	hasher := m.Hash.New()
+	hasher.Write([]byte(signingString))
+
+	// Verify the signature
+	if !ecdsa.Verify(ecdsaKey, hasher.Sum(nil), r, s) {
+		return ErrECDSAVerification
+	}
+	return nil
+}
+
+// Implements the Sign method from SigningMethod
+// For this signing method, must be either a PEM encoded SEC1 or PKCS8 EC private key as
+// []byte, or an ecdsa.PrivateKey structure.  The key must be on the curve of the method.
+func (m *SigningMethodECDSA) Sign(signingString string, key interface{}) (string, error) {
+	var err error
+	var ecdsaKey *ecdsa.PrivateKey
+
+	switch k := key.(type) {
+	case []byte:
+		if ecdsaKey, err = ParseECPrivateKeyFromPEM(k); err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		ecdsaKey = k
+	default:
+		return "", ErrInvalidKey
+	}
+	if ecdsaKey.Curve.Params().BitSize != m.CurveBits {
+		return "", ErrInvalidKey
+	}
+
+	// Create the hasher
+	if !m.Hash.Available() {
+		return "", ErrHashUnavailable
+	}
+
+	hasher := m.Hash.New()
+	hasher.Write([]byte(signingString))
+
+	// Sign the string and return r and s, each left padded to KeySize
+	r, s, err := ecdsa.Sign(rand.Reader, ecdsaKey, hasher.Sum(nil))
+	if err != nil {
+		return "", err
+	}
+	sigBytes := make([]byte, 2*m.KeySize)
+	r.FillBytes(sigBytes[:m.KeySize])
+	s.FillBytes(sigBytes[m.KeySize:])
+	return EncodeSegment(sigBytes), nil
+}
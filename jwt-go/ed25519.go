@@ -0,0 +1,85 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"errors"
+)
+
+// Returned by Verify when the signature does not match.  crypto/ed25519 only reports a bool
+var ErrEd25519Verification = errors.New("ed25519: verification error")
+
+// Implements the EdDSA signing method with Ed25519 keys
+type SigningMethodEd25519 struct{}
+
+// Specific instance for EdDSA
+var SigningMethodEdDSA *SigningMethodEd25519
+
+func init() {
+	SigningMethodEdDSA = &SigningMethodEd25519{}
+	RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *SigningMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+// Implements the Verify method from SigningMethod
+// For this signing method, must be either a PEM encoded PKIX Ed25519 public key as
+// []byte, or an ed25519.PublicKey.
+func (m *SigningMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	var err error
+
+	// Decode the signature
+	var sig []byte
+	if sig, err = DecodeSegment(signature); err != nil {
+		return err
+	}
+
+	var edKey ed25519.PublicKey
+
+	switch k := key.(type) {
+	case []byte:
+		if edKey, err = ParseEdPublicKeyFromPEM(k); err != nil {
+			return err
+		}
+	case ed25519.PublicKey:
+		edKey = k
+	default:
+		return ErrInvalidKey
+	}
+	if len(edKey) != ed25519.PublicKeySize {
+		return ErrInvalidKey
+	}
+
+	// Verify the signature.  Ed25519 hashes the message itself
+	if !ed25519.Verify(edKey, []byte(signingString), sig) {
+		return ErrEd25519Verification
+	}
+	return nil
+}
+
+// Implements the Sign method from SigningMethod
+// For this signing method, must be either a PEM encoded PKCS8 Ed25519 private key as
+// []byte, or an ed25519.PrivateKey.
+func (m *SigningMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	var err error
+	var edKey ed25519.PrivateKey
+
+	switch k := key.(type) {
+	case []byte:
+		if edKey, err = ParseEdPrivateKeyFromPEM(k); err != nil {
+			return "", err
+		}
+	case ed25519.PrivateKey:
+		edKey = k
+	default:
+		return "", ErrInvalidKey
+	}
+	if len(edKey) != ed25519.PrivateKeySize {
+		return "", ErrInvalidKey
+	}
+
+	return EncodeSegment(ed25519.Sign(edKey, []byte(signingString))), nil
+}
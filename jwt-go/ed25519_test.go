@@ -0,0 +1,132 @@
+package jwt_test
+
+import (
+	"crypto/ed25519"
+	"github.com/dgrijalva/jwt-go"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var ed25519TestData = []struct {
+	name        string
+	tokenString string
+	alg         string
+	valid       bool
+}{
+	{
+		"RFC 8037 A.4 EdDSA",
+		"eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc.hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg",
+		"EdDSA",
+		true,
+	},
+	{
+		"basic EdDSA invalid: foo => bar",
+		"eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc.igyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg",
+		"EdDSA",
+		false,
+	},
+}
+
+func TestEd25519Verify(t *testing.T) {
+	key, _ := ioutil.ReadFile("test/ed25519_key.pub")
+
+	for _, data := range ed25519TestData {
+		parts := strings.Split(data.tokenString, ".")
+
+		method := jwt.GetSigningMethod(data.alg)
+		err := method.Verify(strings.Join(parts[0:2], "."), parts[2], key)
+		if data.valid && err != nil {
+			t.Errorf("[%v] Error while verifying key: %v", data.name, err)
+		}
+		if !data.valid && err == nil {
+			t.Errorf("[%v] Invalid key passed validation", data.name)
+		}
+	}
+}
+
+// Ed25519 signatures are deterministic, so the RFC signature is reproduced
+func TestEd25519Sign(t *testing.T) {
+	key, _ := ioutil.ReadFile("test/ed25519_key")
+
+	for _, data := range ed25519TestData {
+		if data.valid {
+			parts := strings.Split(data.tokenString, ".")
+			method := jwt.GetSigningMethod(data.alg)
+			sig, err := method.Sign(strings.Join(parts[0:2], "."), key)
+			if err != nil {
+				t.Errorf("[%v] Error signing token: %v", data.name, err)
+			}
+			if sig != parts[2] {
+				t.Errorf("[%v] Incorrect signature.\nwas:\n%v\nexpecting:\n%v", data.name, sig, parts[2])
+			}
+		}
+	}
+}
+
+func TestEd25519RoundTrip(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwt.New(jwt.SigningMethodEdDSA)
+	token.Claims["foo"] = "bar"
+	tokenString, err := token.SignedString(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := jwt.Parse(tokenString, func(*jwt.Token) (interface{}, error) { return publicKey, nil },
+		&jwt.ValidationOptions{ValidMethods: []string{"EdDSA"}, RequireKeyType: true})
+	if err != nil || !parsed.Valid {
+		t.Errorf("Error while verifying token: %v", err)
+	}
+
+	// another key does not verify
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	if _, err = jwt.Parse(tokenString, func(*jwt.Token) (interface{}, error) { return otherKey, nil }); err == nil {
+		t.Errorf("Token verified with the wrong key")
+	}
+}
+
+func TestEd25519KeyParsing(t *testing.T) {
+	key, _ := ioutil.ReadFile("test/ed25519_key")
+	pubKey, _ := ioutil.ReadFile("test/ed25519_key.pub")
+	ecKey, _ := ioutil.ReadFile("test/ec256_key")
+	ecPubKey, _ := ioutil.ReadFile("test/ec256_key.pub")
+	badKey := []byte("All your base are belong to key")
+
+	// Test parsePrivateKey
+	if _, e := jwt.ParseEdPrivateKeyFromPEM(key); e != nil {
+		t.Errorf("Failed to parse valid private key: %v", e)
+	}
+
+	if k, e := jwt.ParseEdPrivateKeyFromPEM(pubKey); e == nil {
+		t.Errorf("Parsed public key as valid private key: %v", k)
+	}
+
+	if k, e := jwt.ParseEdPrivateKeyFromPEM(ecKey); e == nil {
+		t.Errorf("Parsed EC key as valid private key: %v", k)
+	}
+
+	if k, e := jwt.ParseEdPrivateKeyFromPEM(badKey); e == nil {
+		t.Errorf("Parsed invalid key as valid private key: %v", k)
+	}
+
+	// Test parsePublicKey
+	if _, e := jwt.ParseEdPublicKeyFromPEM(pubKey); e != nil {
+		t.Errorf("Failed to parse valid public key: %v", e)
+	}
+
+	if k, e := jwt.ParseEdPublicKeyFromPEM(key); e == nil {
+		t.Errorf("Parsed private key as valid public key: %v", k)
+	}
+
+	if k, e := jwt.ParseEdPublicKeyFromPEM(ecPubKey); e == nil {
+		t.Errorf("Parsed EC key as valid public key: %v", k)
+	}
+
+	if k, e := jwt.ParseEdPublicKeyFromPEM(badKey); e == nil {
+		t.Errorf("Parsed invalid key as valid public key: %v", k)
+	}
+}
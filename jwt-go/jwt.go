@@ -18,6 +18,7 @@ var TimeFunc = time.Now
 // the key for verification.  The function receives the parsed,
 // but unverified Token.  This allows you to use propries in the
 // Header of the token (such as `kid`) to identify which key to use.
+// KeySet.Keyfunc does this for keys published as a JWK Set.
 type Keyfunc func(*Token) (interface{}, error)
 
 // Error constants
@@ -90,13 +91,19 @@ func (t *Token) SigningString() (string, error) {
 
 // Parse, validate, and return a token.
 // keyFunc will receive the parsed token and should return the key for validating.
+// The claims are validated with opts, at most one, or the defaults if there is none.
 // If everything is kosher, err will be nil
-func Parse(tokenString string, keyFunc Keyfunc) (*Token, error) {
+func Parse(tokenString string, keyFunc Keyfunc, opts ...*ValidationOptions) (*Token, error) {
 	parts := strings.Split(tokenString, ".")
 	if len(parts) != 3 {
 		return nil, &ValidationError{err: "token contains an invalid number of segments", Errors: ValidationErrorMalformed}
 	}
 
+	var opt *ValidationOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
 	var err error
 	token := &Token{Raw: tokenString}
 	// parse Header
@@ -125,6 +132,9 @@ func Parse(tokenString string, keyFunc Keyfunc) (*Token, error) {
 	} else {
 		return token, &ValidationError{err: "signing method (alg) is unspecified.", Errors: ValidationErrorUnverifiable}
 	}
+	if !opt.methodAllowed(token.Method.Alg()) {
+		return token, &ValidationError{err: "signing method (alg) is not allowed.", Errors: ValidationErrorUnverifiable}
+	}
 
 	// Lookup key
 	var key interface{}
@@ -136,29 +146,17 @@ func Parse(tokenString string, keyFunc Keyfunc) (*Token, error) {
 		// keyFunc returned an error
 		return token, &ValidationError{err: err.Error(), Errors: ValidationErrorUnverifiable}
 	}
+	if opt != nil && opt.RequireKeyType && !keyMatchesMethod(token.Method, key) {
+		return token, &ValidationError{err: "key is of the wrong type for the signing method (alg).", Errors: ValidationErrorUnverifiable}
+	}
 
-	// Check expiration times
+	// Check expiration times and the other registered claims
 	vErr := &ValidationError{}
-	/* !!! time validations suppressed REK
-	now := TimeFunc().Unix()
-	if exp, ok := token.Claims["exp"].(float64); ok {
-		if now > int64(exp) {
-			vErr.err = "token is expired"
-			vErr.Errors |= ValidationErrorExpired
-		}
-	}
-	if nbf, ok := token.Claims["nbf"].(float64); ok {
-		if now < int64(nbf) {
-			vErr.err = "token is not valid yet"
-			vErr.Errors |= ValidationErrorNotValidYet
-		}
-	}
-	*/
+	opt.validate(token.Claims, vErr)
 
 	// Perform validation
 	if err = token.Method.Verify(strings.Join(parts[0:2], "."), parts[2], key); err != nil {
-		vErr.err = err.Error()
-		vErr.Errors |= ValidationErrorSignatureInvalid
+		vErr.add(err.Error(), ValidationErrorSignatureInvalid)
 	}
 
 	if vErr.valid() {
@@ -176,6 +174,10 @@ const (
 	ValidationErrorSignatureInvalid                    // Signature validation failed
 	ValidationErrorExpired                             // Exp validation failed
 	ValidationErrorNotValidYet                         // NBF validation failed
+	ValidationErrorIssuedAt                            // IAT validation failed
+	ValidationErrorIssuer                              // ISS validation failed
+	ValidationErrorAudience                            // AUD validation failed
+	ValidationErrorSubject                             // SUB validation failed
 )
 
 // The error from Parse if token is not valid
@@ -192,6 +194,12 @@ func (e ValidationError) Error() string {
 	return e.err
 }
 
+// Record a failure.  The message of the last one is kept
+func (e *ValidationError) add(err string, bits uint32) {
+	e.err = err
+	e.Errors |= bits
+}
+
 // No errors
 func (e *ValidationError) valid() bool {
 	if e.Errors > 0 {
@@ -204,20 +212,21 @@ func (e *ValidationError) valid() bool {
 // This method will call ParseMultipartForm if there's no token in the header.
 // Currently, it looks in the Authorization header as well as
 // looking for an 'access_token' request parameter in req.Form.
-func ParseFromRequest(req *http.Request, keyFunc Keyfunc) (token *Token, err error) {
+// The claims are validated with opts as in Parse.
+func ParseFromRequest(req *http.Request, keyFunc Keyfunc, opts ...*ValidationOptions) (token *Token, err error) {
 
 	// Look for an Authorization header
 	if ah := req.Header.Get("Authorization"); ah != "" {
 		// Should be a bearer token
 		if len(ah) > 6 && strings.ToUpper(ah[0:6]) == "BEARER" {
-			return Parse(ah[7:], keyFunc)
+			return Parse(ah[7:], keyFunc, opts...)
 		}
 	}
 
 	// Look for "access_token" parameter
 	req.ParseMultipartForm(10e6)
 	if tokStr := req.Form.Get("access_token"); tokStr != "" {
-		return Parse(tokStr, keyFunc)
+		return Parse(tokStr, keyFunc, opts...)
 	}
 
 	return nil, ErrNoTokenInRequest
@@ -0,0 +1,362 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned while loading a key set or looking up a key
+var (
+	ErrKeyNotFound       = errors.New("no key in the key set matches the token")
+	ErrKeySetUnavailable = errors.New("the key set could not be fetched")
+)
+
+// Defaults for remote key sets
+const (
+	defaultKeySetRefresh      = time.Hour
+	defaultKeySetMinRefetch   = time.Minute
+	defaultKeySetFetchTimeout = 10 * time.Second
+	maxKeySetSize             = 1 << 20
+)
+
+// A key of a JWK Set (RFC 7517), converted to the type the signing methods take:
+// *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey or []byte for oct keys.
+type JSONWebKey struct {
+	Kid string
+	Kty string
+	Alg string // Empty if the JWK does not restrict it
+	Use string
+	Key interface{}
+}
+
+// Options for a remote key set.  A nil *KeySetOptions means the defaults.
+type KeySetOptions struct {
+	Client             *http.Client  // Default has a 10s timeout
+	RefreshInterval    time.Duration // Used when the response has no Cache-Control max-age, default 1h
+	MinRefetchInterval time.Duration // Least time between fetches, default 1m
+}
+
+// A JWK Set used to verify tokens.  Use KeySet.Keyfunc with Parse to pick the key
+// by the kid and alg of the token.
+//
+// A remote key set is refreshed in the background when its Cache-Control max-age runs out.
+// A token with an unknown kid makes it fetch again at once, at most every MinRefetchInterval,
+// so that rotated keys are found without letting bad tokens hammer the server.
+type KeySet struct {
+	url        string
+	opts       KeySetOptions
+	mutex      sync.RWMutex
+	keys       []JSONWebKey
+	fetchMutex sync.Mutex
+	lastFetch  time.Time
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// Parse a JWK Set from its JSON.  JWKs of an unknown kty are skipped.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	keys, err := parseJWKSet(data)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{keys: keys}, nil
+}
+
+// Read a JWK Set from a file
+func ReadKeySetFile(path string) (*KeySet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeySet(data)
+}
+
+// Fetch a JWK Set from url and keep it fresh in the background until Close.
+// The first fetch must succeed.
+func FetchKeySet(url string, opts *KeySetOptions) (*KeySet, error) {
+	ks := &KeySet{url: url}
+	if opts != nil {
+		ks.opts = *opts
+	}
+	if ks.opts.Client == nil {
+		ks.opts.Client = &http.Client{Timeout: defaultKeySetFetchTimeout}
+	}
+	if ks.opts.RefreshInterval <= 0 {
+		ks.opts.RefreshInterval = defaultKeySetRefresh
+	}
+	if ks.opts.MinRefetchInterval <= 0 {
+		ks.opts.MinRefetchInterval = defaultKeySetMinRefetch
+	}
+
+	ks.fetchMutex.Lock()
+	next, err := ks.fetch()
+	ks.fetchMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	ks.stop = make(chan struct{})
+	ks.done = make(chan struct{})
+	go ks.refresh(next)
+	return ks, nil
+}
+
+// Stop refreshing a remote key set
+func (ks *KeySet) Close() {
+	if ks.stop != nil {
+		close(ks.stop)
+		<-ks.done
+		ks.stop = nil
+	}
+}
+
+// The keys of the set
+func (ks *KeySet) Keys() []JSONWebKey {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	return append([]JSONWebKey(nil), ks.keys...)
+}
+
+// A Keyfunc that returns the key for the kid and alg of the token.  Without a kid
+// the first key that suits the alg is used.  Use it as jwt.Parse(tokenString, ks.Keyfunc).
+func (ks *KeySet) Keyfunc(token *Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	alg, _ := token.Header["alg"].(string)
+	return ks.Lookup(kid, alg)
+}
+
+// Find the key for kid and alg.  A remote set is fetched again if kid is unknown, and if
+// that fetch fails the error wraps ErrKeySetUnavailable rather than being ErrKeyNotFound.
+func (ks *KeySet) Lookup(kid, alg string) (interface{}, error) {
+	key, bKnownKid := ks.find(kid, alg)
+	if key != nil {
+		return key, nil
+	}
+	if ks.url == "" || kid == "" || bKnownKid {
+		return nil, ErrKeyNotFound
+	}
+
+	// the key may have been rotated in since the last fetch
+	var err error
+	ks.fetchMutex.Lock()
+	if time.Since(ks.lastFetch) >= ks.opts.MinRefetchInterval {
+		_, err = ks.fetch()
+	}
+	ks.fetchMutex.Unlock()
+	if key, _ = ks.find(kid, alg); key != nil {
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Find a key and report whether kid is in the set at all
+func (ks *KeySet) find(kid, alg string) (interface{}, bool) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	bKnownKid := false
+	for _, k := range ks.keys {
+		if kid != "" && k.Kid != kid {
+			continue
+		}
+		bKnownKid = true
+		if k.suits(alg) {
+			return k.Key, true
+		}
+	}
+	return nil, bKnownKid
+}
+
+// Can the key verify alg.  The key must be of the type the alg uses, so that an RSA key
+// is never handed to HMAC, and an alg on the JWK must be the alg of the token.
+func (k *JSONWebKey) suits(alg string) bool {
+	if (k.Alg != "" && k.Alg != alg) || (k.Use != "" && k.Use != "sig") {
+		return false
+	}
+	method := GetSigningMethod(alg)
+	return method != nil && keyMatchesMethod(method, k.Key)
+}
+
+// Fetch the set and return when to refresh it.  The caller holds fetchMutex.
+// The keys are kept if the fetch fails, and the error wraps ErrKeySetUnavailable.
+func (ks *KeySet) fetch() (time.Duration, error) {
+	ks.lastFetch = time.Now()
+	resp, err := ks.opts.Client.Get(ks.url)
+	if err != nil {
+		return ks.opts.MinRefetchInterval, fmt.Errorf("%w: %v", ErrKeySetUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ks.opts.MinRefetchInterval, fmt.Errorf("%w: %s returned %s", ErrKeySetUnavailable, ks.url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxKeySetSize))
+	if err != nil {
+		return ks.opts.MinRefetchInterval, fmt.Errorf("%w: %s: %v", ErrKeySetUnavailable, ks.url, err)
+	}
+	keys, err := parseJWKSet(data)
+	if err != nil {
+		return ks.opts.MinRefetchInterval, fmt.Errorf("%w: %s: %v", ErrKeySetUnavailable, ks.url, err)
+	}
+
+	ks.mutex.Lock()
+	ks.keys = keys
+	ks.mutex.Unlock()
+	return ks.maxAge(resp.Header.Get("Cache-Control")), nil
+}
+
+// How long the response may be cached, bounded below by MinRefetchInterval
+func (ks *KeySet) maxAge(cacheControl string) time.Duration {
+	next := ks.opts.RefreshInterval
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			next = 0
+		case strings.HasPrefix(directive, "max-age="):
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil && seconds >= 0 {
+				next = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if next < ks.opts.MinRefetchInterval {
+		next = ks.opts.MinRefetchInterval
+	}
+	return next
+}
+
+// Refresh the set in the background until Close
+func (ks *KeySet) refresh(next time.Duration) {
+	defer close(ks.done)
+	timer := time.NewTimer(next)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ks.stop:
+			return
+		case <-timer.C:
+		}
+		ks.fetchMutex.Lock()
+		next, _ = ks.fetch()
+		ks.fetchMutex.Unlock()
+		timer.Reset(next)
+	}
+}
+
+// A JWK as it appears in the JSON
+type rawJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// Convert the JWKs of a set.  Unknown kty values are skipped as RFC 7517 asks.
+func parseJWKSet(data []byte) ([]JSONWebKey, error) {
+	var set struct {
+		Keys []rawJWK `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]JSONWebKey, 0, len(set.Keys))
+	for i, raw := range set.Keys {
+		key, err := raw.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d (kid %q): %v", i, raw.Kid, err)
+		}
+		if key != nil {
+			keys = append(keys, JSONWebKey{Kid: raw.Kid, Kty: raw.Kty, Alg: raw.Alg, Use: raw.Use, Key: key})
+		}
+	}
+	return keys, nil
+}
+
+// Convert a JWK.  Private key members are ignored.
+func (raw *rawJWK) publicKey() (interface{}, error) {
+	switch raw.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(raw.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(raw.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch raw.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", raw.Crv)
+		}
+		x, err := decodeJWKInt(raw.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(raw.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		k, err := DecodeSegment(raw.K)
+		if err != nil || len(k) == 0 {
+			return nil, errors.New("invalid oct key")
+		}
+		return k, nil
+	case "OKP":
+		if raw.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", raw.Crv)
+		}
+		x, err := DecodeSegment(raw.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+// Decode a base64url unsigned big-endian integer
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := DecodeSegment(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("missing key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
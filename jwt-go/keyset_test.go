@@ -0,0 +1,314 @@
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RFC 7517 A.1, with a key of an unknown kty added
+const rfc7517KeySet = `{"keys":[
+	{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4","y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM","use":"enc","kid":"1"},
+	{"kty":"RSA","n":"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw","e":"AQAB","alg":"RS256","kid":"2011-04-29"},
+	{"kty":"XYZ","kid":"unknown"}
+]}`
+
+func jwkInt(i *big.Int) string {
+	return jwt.EncodeSegment(i.Bytes())
+}
+
+// A JWK for the public key in a PEM file
+func jwkFromFile(t *testing.T, kid, path string) map[string]string {
+	pemKey, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := map[string]string{"kid": kid}
+	if k, err := jwt.ParseRSAPublicKeyFromPEM(pemKey); err == nil {
+		jwk["kty"], jwk["n"], jwk["e"] = "RSA", jwkInt(k.N), jwkInt(big.NewInt(int64(k.E)))
+	} else if k, err := jwt.ParseECPublicKeyFromPEM(pemKey); err == nil {
+		jwk["kty"], jwk["crv"], jwk["x"], jwk["y"] = "EC", k.Curve.Params().Name, jwkInt(k.X), jwkInt(k.Y)
+	} else if k, err := jwt.ParseEdPublicKeyFromPEM(pemKey); err == nil {
+		jwk["kty"], jwk["crv"], jwk["x"] = "OKP", "Ed25519", jwt.EncodeSegment(k)
+	} else {
+		jwk["kty"], jwk["k"] = "oct", jwt.EncodeSegment(pemKey)
+	}
+	return jwk
+}
+
+func keySetJSON(t *testing.T, keys ...map[string]string) []byte {
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Sign a token with the key in path, naming kid in the header
+func signWithKid(t *testing.T, method jwt.SigningMethod, kid, path string) string {
+	key, _ := ioutil.ReadFile(path)
+	token := jwt.New(method)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	token.Claims["foo"] = "bar"
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenString
+}
+
+func TestKeySetParsing(t *testing.T) {
+	ks, err := jwt.ParseKeySet([]byte(rfc7517KeySet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := ks.Keys()
+	if len(keys) != 2 {
+		t.Fatalf("Expected 2 keys, got %d", len(keys))
+	}
+	if k, ok := keys[0].Key.(*ecdsa.PublicKey); !ok || k.Curve.Params().Name != "P-256" || keys[0].Use != "enc" {
+		t.Errorf("EC key not parsed: %#v", keys[0])
+	}
+	if k, ok := keys[1].Key.(*rsa.PublicKey); !ok || k.E != 65537 || k.N.BitLen() != 2048 || keys[1].Alg != "RS256" {
+		t.Errorf("RSA key not parsed: %#v", keys[1])
+	}
+	if _, err := ks.Lookup("1", "ES256"); err != jwt.ErrKeyNotFound {
+		t.Errorf("Encryption key was used for a signature: %v", err)
+	}
+	if _, err := ks.Lookup("2011-04-29", "RS512"); err != jwt.ErrKeyNotFound {
+		t.Errorf("Key was used for an alg other than its own: %v", err)
+	}
+
+	var invalid = []struct {
+		name string
+		json string
+	}{
+		{"not json", `keys`},
+		{"EC point off the curve", `{"keys":[{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4","y":"AQAB"}]}`},
+		{"unknown curve", `{"keys":[{"kty":"EC","crv":"P-192","x":"AQAB","y":"AQAB"}]}`},
+		{"RSA without modulus", `{"keys":[{"kty":"RSA","e":"AQAB"}]}`},
+		{"empty oct key", `{"keys":[{"kty":"oct","k":""}]}`},
+		{"short Ed25519 key", `{"keys":[{"kty":"OKP","crv":"Ed25519","x":"AQAB"}]}`},
+	}
+	for _, data := range invalid {
+		if _, err := jwt.ParseKeySet([]byte(data.json)); err == nil {
+			t.Errorf("[%v] Invalid key set was parsed", data.name)
+		}
+	}
+}
+
+func TestKeySetKeyfunc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	ioutil.WriteFile(path, keySetJSON(t,
+		jwkFromFile(t, "rsa", "test/sample_key.pub"),
+		jwkFromFile(t, "ec", "test/ec256_key.pub"),
+		jwkFromFile(t, "ec384", "test/ec384_key.pub"),
+		jwkFromFile(t, "hmac", "test/hmacTestKey"),
+		jwkFromFile(t, "ed", "test/ed25519_key.pub"),
+	), 0600)
+	ks, err := jwt.ReadKeySetFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name        string
+		tokenString string
+		valid       bool
+	}{
+		{"RS256 by kid", signWithKid(t, jwt.SigningMethodRS256, "rsa", "test/sample_key"), true},
+		{"PS256 by kid", signWithKid(t, jwt.SigningMethodPS256, "rsa", "test/sample_key"), true},
+		{"ES256 by kid", signWithKid(t, jwt.SigningMethodES256, "ec", "test/ec256_key"), true},
+		{"ES384 without kid", signWithKid(t, jwt.SigningMethodES384, "", "test/ec384_key"), true},
+		{"HS256 by kid", signWithKid(t, jwt.SigningMethodHS256, "hmac", "test/hmacTestKey"), true},
+		{"EdDSA by kid", signWithKid(t, jwt.SigningMethodEdDSA, "ed", "test/ed25519_key"), true},
+		{"unknown kid", signWithKid(t, jwt.SigningMethodRS256, "other", "test/sample_key"), false},
+		{"ES384 with the kid of a P-256 key", signWithKid(t, jwt.SigningMethodES384, "ec", "test/ec384_key"), false},
+		{"HS256 with the kid of an RSA key", signWithKid(t, jwt.SigningMethodHS256, "rsa", "test/sample_key.pub"), false},
+		{"HS256 signed with an RSA public key", signWithKid(t, jwt.SigningMethodHS256, "", "test/sample_key.pub"), false},
+	}
+	for _, data := range tests {
+		token, err := jwt.Parse(data.tokenString, ks.Keyfunc)
+		if data.valid {
+			if err != nil || !token.Valid {
+				t.Errorf("[%v] Error while verifying token: %v", data.name, err)
+			}
+			continue
+		}
+		if err == nil || token.Valid {
+			t.Errorf("[%v] Invalid token passed validation", data.name)
+		}
+	}
+}
+
+// A JWKS endpoint whose keys can be swapped, counting fetches
+type jwksServer struct {
+	*httptest.Server
+	mutex        sync.Mutex
+	keys         []byte
+	cacheControl string
+	status       int
+	fetches      int
+}
+
+func newJWKSServer(keys []byte, cacheControl string) *jwksServer {
+	s := &jwksServer{keys: keys, cacheControl: cacheControl, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.fetches++
+		if s.cacheControl != "" {
+			w.Header().Set("Cache-Control", s.cacheControl)
+		}
+		w.WriteHeader(s.status)
+		w.Write(s.keys)
+	}))
+	return s
+}
+
+func (s *jwksServer) set(keys []byte, status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keys, s.status = keys, status
+}
+
+func (s *jwksServer) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.fetches
+}
+
+func TestRemoteKeySetUnknownKid(t *testing.T) {
+	oldKey := jwkFromFile(t, "old", "test/ec256_key.pub")
+	newKey := jwkFromFile(t, "new", "test/sample_key.pub")
+	server := newJWKSServer(keySetJSON(t, oldKey), "public, max-age=3600")
+	defer server.Close()
+
+	ks, err := jwt.FetchKeySet(server.URL, &jwt.KeySetOptions{MinRefetchInterval: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	tokenString := signWithKid(t, jwt.SigningMethodRS256, "new", "test/sample_key")
+
+	// rotated in, but too soon after the first fetch to look
+	server.set(keySetJSON(t, oldKey, newKey), http.StatusOK)
+	if _, err := jwt.Parse(tokenString, ks.Keyfunc); err == nil {
+		t.Errorf("Key was refetched before MinRefetchInterval")
+	}
+	if n := server.count(); n != 1 {
+		t.Errorf("Expected 1 fetch, got %d", n)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if token, err := jwt.Parse(tokenString, ks.Keyfunc); err != nil || !token.Valid {
+		t.Errorf("Rotated key was not fetched: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		ks.Lookup(fmt.Sprintf("bogus%d", i), "RS256")
+	}
+	if n := server.count(); n != 2 {
+		t.Errorf("Unknown kids were not rate limited: %d fetches", n)
+	}
+
+	// a failed fetch keeps the keys and is reported
+	time.Sleep(60 * time.Millisecond)
+	server.set([]byte("oops"), http.StatusInternalServerError)
+	if _, err := ks.Lookup("bogus", "RS256"); !errors.Is(err, jwt.ErrKeySetUnavailable) {
+		t.Errorf("Expected ErrKeySetUnavailable after a failed fetch, got %v", err)
+	}
+	if _, err := ks.Lookup("bogus", "RS256"); err != jwt.ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound without a fetch, got %v", err)
+	}
+	if n := server.count(); n != 3 {
+		t.Errorf("Expected 3 fetches, got %d", n)
+	}
+	if _, err := ks.Lookup("new", "RS256"); err != nil {
+		t.Errorf("Keys were lost after a failed fetch: %v", err)
+	}
+}
+
+func TestRemoteKeySetRefresh(t *testing.T) {
+	oldKey := jwkFromFile(t, "old", "test/ec256_key.pub")
+	newKey := jwkFromFile(t, "new", "test/ed25519_key.pub")
+	server := newJWKSServer(keySetJSON(t, oldKey), "no-store")
+	defer server.Close()
+
+	ks, err := jwt.FetchKeySet(server.URL, &jwt.KeySetOptions{MinRefetchInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+
+	server.set(keySetJSON(t, newKey), http.StatusOK)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		keys := ks.Keys()
+		if len(keys) == 1 && keys[0].Kid == "new" {
+			if _, ok := keys[0].Key.(ed25519.PublicKey); !ok {
+				t.Errorf("OKP key not parsed: %#v", keys[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Key set was not refreshed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// max-age is honored once the server sends it
+	server.Close()
+	ks.Close()
+	server = newJWKSServer(keySetJSON(t, oldKey), "max-age=3600")
+	defer server.Close()
+	ks, err = jwt.FetchKeySet(server.URL, &jwt.KeySetOptions{MinRefetchInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	time.Sleep(100 * time.Millisecond)
+	if n := server.count(); n != 1 {
+		t.Errorf("Key set was refreshed before max-age: %d fetches", n)
+	}
+}
+
+func TestRemoteKeySetUnavailable(t *testing.T) {
+	server := newJWKSServer(nil, "")
+	server.set([]byte("down"), http.StatusServiceUnavailable)
+	defer server.Close()
+
+	if ks, err := jwt.FetchKeySet(server.URL, nil); !errors.Is(err, jwt.ErrKeySetUnavailable) {
+		if ks != nil {
+			ks.Close()
+		}
+		t.Errorf("Expected ErrKeySetUnavailable from a failing server, got %v", err)
+	}
+
+	server.set([]byte("{not json"), http.StatusOK)
+	if ks, err := jwt.FetchKeySet(server.URL, nil); !errors.Is(err, jwt.ErrKeySetUnavailable) {
+		if ks != nil {
+			ks.Close()
+		}
+		t.Errorf("Expected ErrKeySetUnavailable for an invalid key set, got %v", err)
+	}
+}
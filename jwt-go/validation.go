@@ -0,0 +1,171 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// Options for validating a token in Parse.  The time based claims
+// are checked against TimeFunc, so tests can fix the clock.
+// Without options, exp and nbf are checked with no leeway.
+//
+// The alg header is chosen by whoever made the token.  Set ValidMethods or RequireKeyType
+// so that a token signed with HS256 cannot be verified using an RSA public key as the
+// HMAC secret.  Either failure is reported as ValidationErrorUnverifiable.
+type ValidationOptions struct {
+	ValidMethods   []string      // Accepted alg values, if set
+	RequireKeyType bool          // The key from the Keyfunc must be of the type the alg uses
+	Leeway         time.Duration // Clock skew allowed on exp, nbf and iat, in whole seconds
+	RequireExp     bool          // Reject tokens without an exp claim
+	VerifyIssuedAt bool          // Reject tokens with an iat claim in the future
+	Issuer         string        // Required iss, if set
+	Audience       []string      // The aud claim must contain one of these, if set
+	Subject        string        // Required sub, if set
+}
+
+// Is the alg accepted
+func (opts *ValidationOptions) methodAllowed(alg string) bool {
+	if opts == nil || len(opts.ValidMethods) == 0 {
+		return true
+	}
+	for _, m := range opts.ValidMethods {
+		if m == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// Is the key of the type the signing method uses.  HMAC secrets must not be PEM
+// encoded, since those are public keys.  Methods from outside this package are
+// left to reject keys in Verify.
+func keyMatchesMethod(method SigningMethod, key interface{}) bool {
+	switch m := method.(type) {
+	case *SigningMethodHMAC:
+		keyBytes, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		block, _ := pem.Decode(keyBytes)
+		return block == nil
+	case *SigningMethodRSA, *SigningMethodRSAPSS:
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			return true
+		case []byte:
+			_, err := ParseRSAPublicKeyFromPEM(k)
+			return err == nil
+		}
+		return false
+	case *SigningMethodECDSA:
+		var ecdsaKey *ecdsa.PublicKey
+		switch k := key.(type) {
+		case *ecdsa.PublicKey:
+			ecdsaKey = k
+		case []byte:
+			ecdsaKey, _ = ParseECPublicKeyFromPEM(k)
+		}
+		return ecdsaKey != nil && ecdsaKey.Curve.Params().BitSize == m.CurveBits
+	case *SigningMethodEd25519:
+		switch k := key.(type) {
+		case ed25519.PublicKey:
+			return true
+		case []byte:
+			_, err := ParseEdPublicKeyFromPEM(k)
+			return err == nil
+		}
+		return false
+	}
+	return true
+}
+
+// Check the registered claims of a token, recording failures in vErr
+func (opts *ValidationOptions) validate(claims map[string]interface{}, vErr *ValidationError) {
+	if opts == nil {
+		opts = &ValidationOptions{}
+	}
+	now := TimeFunc().Unix()
+	leeway := int64(opts.Leeway / time.Second)
+
+	if exp, ok, err := numericClaim(claims, "exp"); err != nil {
+		vErr.add(err.Error(), ValidationErrorExpired)
+	} else if !ok && opts.RequireExp {
+		vErr.add("token has no exp claim", ValidationErrorExpired)
+	} else if ok && now > exp+leeway {
+		vErr.add("token is expired", ValidationErrorExpired)
+	}
+
+	if nbf, ok, err := numericClaim(claims, "nbf"); err != nil {
+		vErr.add(err.Error(), ValidationErrorNotValidYet)
+	} else if ok && now < nbf-leeway {
+		vErr.add("token is not valid yet", ValidationErrorNotValidYet)
+	}
+
+	if opts.VerifyIssuedAt {
+		if iat, ok, err := numericClaim(claims, "iat"); err != nil {
+			vErr.add(err.Error(), ValidationErrorIssuedAt)
+		} else if ok && now < iat-leeway {
+			vErr.add("token used before issued", ValidationErrorIssuedAt)
+		}
+	}
+
+	if opts.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != opts.Issuer {
+			vErr.add("token has the wrong issuer", ValidationErrorIssuer)
+		}
+	}
+
+	if len(opts.Audience) > 0 && !audienceMatches(claims["aud"], opts.Audience) {
+		vErr.add("token has the wrong audience", ValidationErrorAudience)
+	}
+
+	if opts.Subject != "" {
+		if sub, _ := claims["sub"].(string); sub != opts.Subject {
+			vErr.add("token has the wrong subject", ValidationErrorSubject)
+		}
+	}
+}
+
+// Read a NumericDate claim.  Reports whether the claim is present.
+func numericClaim(claims map[string]interface{}, name string) (int64, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return 0, false, nil
+	}
+	switch v := value.(type) {
+	case float64:
+		return int64(v), true, nil
+	case int64:
+		return v, true, nil
+	case int:
+		return int64(v), true, nil
+	}
+	return 0, true, fmt.Errorf("%s claim is not a number", name)
+}
+
+// The aud claim is either a single string or an array of strings
+func audienceMatches(aud interface{}, accepted []string) bool {
+	var audiences []string
+	switch v := aud.(type) {
+	case string:
+		audiences = []string{v}
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	for _, a := range audiences {
+		for _, b := range accepted {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
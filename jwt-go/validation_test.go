@@ -0,0 +1,210 @@
+package jwt_test
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"testing"
+	"time"
+)
+
+const validationTestNow = 1400000000
+
+var validationTestData = []struct {
+	name   string
+	claims map[string]interface{}
+	opts   *jwt.ValidationOptions
+	errors uint32
+}{
+	{
+		"no options",
+		map[string]interface{}{"exp": validationTestNow + 10, "nbf": validationTestNow - 10},
+		nil,
+		0,
+	},
+	{
+		"expired",
+		map[string]interface{}{"exp": validationTestNow - 10},
+		nil,
+		jwt.ValidationErrorExpired,
+	},
+	{
+		"expired within leeway",
+		map[string]interface{}{"exp": validationTestNow - 10},
+		&jwt.ValidationOptions{Leeway: 30 * time.Second},
+		0,
+	},
+	{
+		"expired beyond leeway",
+		map[string]interface{}{"exp": validationTestNow - 60},
+		&jwt.ValidationOptions{Leeway: 30 * time.Second},
+		jwt.ValidationErrorExpired,
+	},
+	{
+		"exp is not a number",
+		map[string]interface{}{"exp": "tomorrow"},
+		nil,
+		jwt.ValidationErrorExpired,
+	},
+	{
+		"exp required",
+		map[string]interface{}{"foo": "bar"},
+		&jwt.ValidationOptions{RequireExp: true},
+		jwt.ValidationErrorExpired,
+	},
+	{
+		"not valid yet",
+		map[string]interface{}{"nbf": validationTestNow + 10},
+		nil,
+		jwt.ValidationErrorNotValidYet,
+	},
+	{
+		"not valid yet within leeway",
+		map[string]interface{}{"nbf": validationTestNow + 10},
+		&jwt.ValidationOptions{Leeway: 30 * time.Second},
+		0,
+	},
+	{
+		"iat in the future ignored by default",
+		map[string]interface{}{"iat": validationTestNow + 10},
+		nil,
+		0,
+	},
+	{
+		"iat in the future",
+		map[string]interface{}{"iat": validationTestNow + 10},
+		&jwt.ValidationOptions{VerifyIssuedAt: true},
+		jwt.ValidationErrorIssuedAt,
+	},
+	{
+		"iat in the future within leeway",
+		map[string]interface{}{"iat": validationTestNow + 10},
+		&jwt.ValidationOptions{VerifyIssuedAt: true, Leeway: 30 * time.Second},
+		0,
+	},
+	{
+		"issuer",
+		map[string]interface{}{"iss": "auth.example.com"},
+		&jwt.ValidationOptions{Issuer: "auth.example.com"},
+		0,
+	},
+	{
+		"wrong issuer",
+		map[string]interface{}{"iss": "evil.example.com"},
+		&jwt.ValidationOptions{Issuer: "auth.example.com"},
+		jwt.ValidationErrorIssuer,
+	},
+	{
+		"missing issuer",
+		map[string]interface{}{"foo": "bar"},
+		&jwt.ValidationOptions{Issuer: "auth.example.com"},
+		jwt.ValidationErrorIssuer,
+	},
+	{
+		"audience string",
+		map[string]interface{}{"aud": "api"},
+		&jwt.ValidationOptions{Audience: []string{"web", "api"}},
+		0,
+	},
+	{
+		"audience array",
+		map[string]interface{}{"aud": []interface{}{"other", "web"}},
+		&jwt.ValidationOptions{Audience: []string{"web", "api"}},
+		0,
+	},
+	{
+		"wrong audience",
+		map[string]interface{}{"aud": []interface{}{"other"}},
+		&jwt.ValidationOptions{Audience: []string{"web", "api"}},
+		jwt.ValidationErrorAudience,
+	},
+	{
+		"subject",
+		map[string]interface{}{"sub": "user:7"},
+		&jwt.ValidationOptions{Subject: "user:7"},
+		0,
+	},
+	{
+		"wrong subject",
+		map[string]interface{}{"sub": "user:8"},
+		&jwt.ValidationOptions{Subject: "user:7"},
+		jwt.ValidationErrorSubject,
+	},
+	{
+		"everything wrong",
+		map[string]interface{}{"exp": validationTestNow - 10, "iss": "evil.example.com", "aud": "other"},
+		&jwt.ValidationOptions{Issuer: "auth.example.com", Audience: []string{"api"}},
+		jwt.ValidationErrorExpired | jwt.ValidationErrorIssuer | jwt.ValidationErrorAudience,
+	},
+}
+
+func TestValidationOptions(t *testing.T) {
+	jwt.TimeFunc = func() time.Time { return time.Unix(validationTestNow, 0) }
+	defer func() { jwt.TimeFunc = time.Now }()
+
+	for _, data := range validationTestData {
+		tokenString := makeSample(data.claims)
+		token, err := jwt.Parse(tokenString, defaultKeyFunc, data.opts)
+		if data.errors == 0 {
+			if err != nil || !token.Valid {
+				t.Errorf("[%v] Error while verifying token: %v", data.name, err)
+			}
+			continue
+		}
+		if err == nil || token.Valid {
+			t.Errorf("[%v] Invalid token passed validation", data.name)
+			continue
+		}
+		if e := err.(*jwt.ValidationError).Errors; e != data.errors {
+			t.Errorf("[%v] Errors don't match expectation: %b != %b", data.name, e, data.errors)
+		}
+	}
+}
+
+func TestAlgorithmConfusion(t *testing.T) {
+	// an HS256 token signed with the RSA public key as the HMAC secret
+	forged := jwt.New(jwt.SigningMethodHS256)
+	forged.Claims["foo"] = "bar"
+	forgedString, err := forged.SignedString(jwtTestDefaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaString := makeSample(map[string]interface{}{"foo": "bar"})
+
+	var tests = []struct {
+		name        string
+		tokenString string
+		opts        *jwt.ValidationOptions
+		valid       bool
+	}{
+		{"forged without options", forgedString, nil, true},
+		{"forged with valid methods", forgedString, &jwt.ValidationOptions{ValidMethods: []string{"RS256"}}, false},
+		{"forged with key type", forgedString, &jwt.ValidationOptions{RequireKeyType: true}, false},
+		{"rsa with valid methods", rsaString, &jwt.ValidationOptions{ValidMethods: []string{"RS256"}}, true},
+		{"rsa with key type", rsaString, &jwt.ValidationOptions{RequireKeyType: true}, true},
+		{"rsa with other valid methods", rsaString, &jwt.ValidationOptions{ValidMethods: []string{"RS512"}}, false},
+	}
+	for _, data := range tests {
+		token, err := jwt.Parse(data.tokenString, defaultKeyFunc, data.opts)
+		if data.valid {
+			if err != nil || !token.Valid {
+				t.Errorf("[%v] Error while verifying token: %v", data.name, err)
+			}
+			continue
+		}
+		if err == nil || token.Valid {
+			t.Errorf("[%v] Invalid token passed validation", data.name)
+			continue
+		}
+		if e := err.(*jwt.ValidationError).Errors; e != jwt.ValidationErrorUnverifiable {
+			t.Errorf("[%v] Expected ValidationErrorUnverifiable, got %b", data.name, e)
+		}
+	}
+
+	// an hmac secret is accepted with the key type check
+	hmacKey := []byte("secret")
+	token := jwt.New(jwt.SigningMethodHS256)
+	tokenString, _ := token.SignedString(hmacKey)
+	if _, err := jwt.Parse(tokenString, func(*jwt.Token) (interface{}, error) { return hmacKey, nil },
+		&jwt.ValidationOptions{RequireKeyType: true}); err != nil {
+		t.Errorf("[hmac with key type] Error while verifying token: %v", err)
+	}
+}